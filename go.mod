@@ -1,6 +1,6 @@
 module github.com/blocktree/ontology-adapter
 
-go 1.12
+go 1.13
 
 require (
 	github.com/astaxie/beego v1.12.0
@@ -8,7 +8,7 @@ require (
 	github.com/blocktree/go-owcrypt v1.1.13
 	github.com/blocktree/openwallet/v2 v2.0.10
 	github.com/ethereum/go-ethereum v1.9.9
-	github.com/graarh/golang-socketio v0.0.0-20170510162725-2c44953b9b5f
+	github.com/gorilla/websocket v1.4.1
 	github.com/pborman/uuid v1.2.0
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/shopspring/decimal v0.0.0-20200105231215-408a2507e114
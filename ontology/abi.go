@@ -0,0 +1,230 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/tidwall/gjson"
+)
+
+//ABI 参数类型
+const (
+	ABITypeString    = "string"
+	ABITypeInteger   = "integer"
+	ABITypeBoolean   = "boolean"
+	ABITypeAddress   = "address"
+	ABITypeHash160   = "hash160"
+	ABITypeByteArray = "bytearray"
+)
+
+//ABIParameter ABI中方法或事件的参数
+type ABIParameter struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+//ABIFunction ABI中的方法或事件
+type ABIFunction struct {
+	Name       string         `json:"name"`
+	Parameters []ABIParameter `json:"parameters"`
+	ReturnType string         `json:"returntype"`
+}
+
+//ContractABI Ontology 合约ABI，hash为合约地址
+type ContractABI struct {
+	Hash       string        `json:"hash"`
+	Entrypoint string        `json:"entrypoint"`
+	Functions  []ABIFunction `json:"functions"`
+	Events     []ABIFunction `json:"events"`
+}
+
+//event 按名称查找事件
+func (abi *ContractABI) event(name string) (*ABIFunction, bool) {
+	for i, e := range abi.Events {
+		if e.Name == name {
+			return &abi.Events[i], true
+		}
+	}
+	return nil, false
+}
+
+//DecodeEvent 按ABI解析合约事件，States第一个元素为事件名，其余按事件参数依次解析为命名的字段
+func (abi *ContractABI) DecodeEvent(states []gjson.Result) (string, map[string]interface{}, error) {
+	if len(states) == 0 {
+		return "", nil, fmt.Errorf("empty event states")
+	}
+
+	name := decodeEventName(states[0])
+	event, ok := abi.event(name)
+	if !ok {
+		return name, nil, fmt.Errorf("event %s not found in abi of contract %s", name, abi.Hash)
+	}
+
+	fields := make(map[string]interface{}, len(event.Parameters))
+	for i, param := range event.Parameters {
+		if i+1 >= len(states) {
+			break
+		}
+		value, err := decodeABIValue(param.Type, states[i+1])
+		if err != nil {
+			return name, nil, fmt.Errorf("event %s param %s: %w", name, param.Name, err)
+		}
+		fields[param.Name] = value
+	}
+	return name, fields, nil
+}
+
+//decodeEventName NEOVM合约的事件名为hex编码，原生合约为字符串
+func decodeEventName(value gjson.Result) string {
+	data, err := hex.DecodeString(value.String())
+	if err == nil && len(data) > 0 && utf8.Valid(data) {
+		return string(data)
+	}
+	return value.String()
+}
+
+//decodeABIValue 按ABI参数类型解析NEOVM的返回值
+func decodeABIValue(paramType string, value gjson.Result) (interface{}, error) {
+	switch strings.ToLower(paramType) {
+	case ABITypeString:
+		if data, err := hex.DecodeString(value.String()); err == nil && utf8.Valid(data) {
+			return string(data), nil
+		}
+		return value.String(), nil
+	case ABITypeInteger:
+		n, err := parseNeoVMInteger(value)
+		if err != nil {
+			return nil, err
+		}
+		return n.String(), nil
+	case ABITypeBoolean:
+		if value.Type == gjson.True || value.Type == gjson.False {
+			return value.Bool(), nil
+		}
+		n, err := parseNeoVMInteger(value)
+		if err != nil {
+			return nil, err
+		}
+		return n.Sign() != 0, nil
+	case ABITypeAddress, ABITypeHash160:
+		//原生合约直接返回base58地址
+		if len(value.String()) != 40 {
+			return value.String(), nil
+		}
+		return scriptHashToAddress(value.String())
+	case ABITypeByteArray:
+		return value.String(), nil
+	}
+	return value.Value(), nil
+}
+
+//ABIRegistry 合约ABI注册表，按合约地址查找
+type ABIRegistry struct {
+	abis map[string]*ContractABI
+	mu   sync.RWMutex
+}
+
+//NewABIRegistry 创建合约ABI注册表
+func NewABIRegistry() *ABIRegistry {
+	return &ABIRegistry{
+		abis: make(map[string]*ContractABI),
+	}
+}
+
+//normalizeContractAddress 去掉0x前缀并转为小写
+func normalizeContractAddress(address string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(address, "0x"), "0X"))
+}
+
+//Register 注册合约ABI
+func (r *ABIRegistry) Register(abi *ContractABI) error {
+	hash := normalizeContractAddress(abi.Hash)
+	if _, err := contractAddressBytes(hash); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.abis[hash] = abi
+	return nil
+}
+
+//Get 获取合约ABI，ABI中的hash可能是小端序，找不到时按反转的地址再查找
+func (r *ABIRegistry) Get(contractAddress string) (*ContractABI, bool) {
+	hash := normalizeContractAddress(contractAddress)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if abi, ok := r.abis[hash]; ok {
+		return abi, true
+	}
+	reversed, err := contractAddressBytes(hash)
+	if err != nil {
+		return nil, false
+	}
+	abi, ok := r.abis[hex.EncodeToString(reversed)]
+	return abi, ok
+}
+
+//Len 已注册的ABI数量
+func (r *ABIRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.abis)
+}
+
+//LoadFile 加载ABI JSON文件
+func (r *ABIRegistry) LoadFile(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	abi := &ContractABI{}
+	if err := json.Unmarshal(data, abi); err != nil {
+		return fmt.Errorf("invalid abi file %s: %w", file, err)
+	}
+	if err := r.Register(abi); err != nil {
+		return fmt.Errorf("invalid abi file %s: %w", file, err)
+	}
+	return nil
+}
+
+//LoadDir 加载目录下所有.json的ABI文件，无法解析的文件跳过
+func (r *ABIRegistry) LoadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := r.LoadFile(file); err != nil {
+			log.Std.Warning("load contract abi failed: %v", err)
+			continue
+		}
+	}
+	return nil
+}
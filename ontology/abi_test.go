@@ -0,0 +1,133 @@
+package ontology
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/tidwall/gjson"
+)
+
+const testABI = `{
+	"hash": "0x44332211908f7e6d5c4b3a291807f6e5d4c3b2a1",
+	"entrypoint": "Main",
+	"functions": [{"name": "transfer", "parameters": [{"name": "from_acct", "type": "ByteArray"}], "returntype": "Boolean"}],
+	"events": [
+		{"name": "approval", "parameters": [{"name": "owner", "type": "Address"}, {"name": "spender", "type": "Address"}, {"name": "amount", "type": "Integer"}], "returntype": "Void"},
+		{"name": "memo", "parameters": [{"name": "text", "type": "String"}, {"name": "ok", "type": "Boolean"}, {"name": "data", "type": "ByteArray"}], "returntype": "Void"}
+	]
+}`
+
+//testObserver 记录收到的合约回执
+type testObserver struct {
+	receipts map[string][]*openwallet.SmartContractReceipt
+}
+
+func (o *testObserver) BlockScanNotify(header *openwallet.BlockHeader) error { return nil }
+
+func (o *testObserver) BlockExtractDataNotify(sourceKey string, data *openwallet.TxExtractData) error {
+	return nil
+}
+
+func (o *testObserver) BlockExtractSmartContractDataNotify(sourceKey string, data *openwallet.SmartContractReceipt) error {
+	o.receipts[sourceKey] = append(o.receipts[sourceKey], data)
+	return nil
+}
+
+func TestABIRegistry(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ontabi")
+	defer os.RemoveAll(dir)
+
+	//hash为小端序，按区块事件中的合约地址查找
+	ioutil.WriteFile(filepath.Join(dir, "token.json"), []byte(testABI), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0644)
+
+	registry := NewABIRegistry()
+	if err := registry.LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir failed unexpected error: %v", err)
+	}
+	if registry.Len() != 1 {
+		t.Fatalf("registry has %d abi, want 1", registry.Len())
+	}
+	contract, ok := registry.Get(testOEP4Contract)
+	if !ok {
+		t.Fatalf("abi of %s not found", testOEP4Contract)
+	}
+
+	states := gjson.Parse(`["617070726f76616c","0000000000000000000000000000000000000001","0000000000000000000000000000000000000007","00e1f505"]`).Array()
+	name, fields, err := contract.DecodeEvent(states)
+	if err != nil || name != "approval" {
+		t.Fatalf("DecodeEvent = %s, %v", name, err)
+	}
+	if fields["owner"] != testAddress1 || fields["spender"] != testAddress7 || fields["amount"] != "100000000" {
+		t.Errorf("unexpected approval fields: %v", fields)
+	}
+
+	states = gjson.Parse(`["6d656d6f","6869","01","abcd"]`).Array()
+	if _, fields, _ = contract.DecodeEvent(states); fields["text"] != "hi" || fields["ok"] != true || fields["data"] != "abcd" {
+		t.Errorf("unexpected memo fields: %v", fields)
+	}
+
+	if _, _, err := contract.DecodeEvent(gjson.Parse(`["7472616e73666572"]`).Array()); err == nil {
+		t.Errorf("event not in abi should fail")
+	}
+}
+
+func TestONTBlockScanner_ExtractContractReceipts(t *testing.T) {
+	wm := NewWalletManager()
+	contract := &ContractABI{}
+	json.Unmarshal([]byte(testABI), contract)
+	contract.Hash = testOEP4Contract
+	if err := wm.ABIRegistry.Register(contract); err != nil {
+		t.Fatalf("Register failed unexpected error: %v", err)
+	}
+
+	bs := wm.Blockscanner
+	observer := &testObserver{receipts: make(map[string][]*openwallet.SmartContractReceipt)}
+	bs.AddObserver(observer)
+
+	token := &openwallet.SmartContract{Address: testOEP4Contract, Token: "TST", Protocol: OEP4Protocol, Decimals: 8}
+	scanTargetFunc := func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
+		if target.ScanTargetType == openwallet.ScanTargetTypeContractAddress && target.ScanTarget == token.Address {
+			return openwallet.ScanTargetResult{SourceKey: "token", Exist: true, TargetInfo: token}
+		}
+		return openwallet.ScanTargetResult{}
+	}
+
+	trx := &Transaction{
+		TxID:        "tx1",
+		Payer:       testAddress1,
+		BlockHeight: 10,
+		Events: []ContractEvent{
+			{ContractAddress: testOEP4Contract, States: `["617070726f76616c","0000000000000000000000000000000000000001","0000000000000000000000000000000000000007","64"]`},
+			{ContractAddress: testOEP4Contract, States: `["6d656d6f","6869","00","ab"]`},
+			{ContractAddress: "ffffffffffffffffffffffffffffffffffffffff", States: `["6d656d6f","6869","00","ab"]`},
+		},
+	}
+	result := &ExtractResult{extractData: make(map[string]*openwallet.TxExtractData)}
+	bs.extractTransaction(trx, result, scanTargetFunc)
+	bs.newContractReceiptNotify(trx.BlockHeight, result.contractData)
+
+	receipts := observer.receipts["token"]
+	if len(receipts) != 1 || len(observer.receipts) != 1 {
+		t.Fatalf("unexpected receipts: %+v", observer.receipts)
+	}
+	receipt := receipts[0]
+	if receipt.TxID != "tx1" || receipt.From != testAddress1 || receipt.To != testOEP4Contract || receipt.Coin.Contract.Token != "TST" || len(receipt.Events) != 2 {
+		t.Fatalf("unexpected receipt: %+v", receipt)
+	}
+	if receipt.Events[0].Event != "approval" || receipt.Events[1].Event != "memo" {
+		t.Errorf("unexpected events: %s, %s", receipt.Events[0].Event, receipt.Events[1].Event)
+	}
+	value := map[string]interface{}{}
+	json.Unmarshal([]byte(receipt.Events[0].Value), &value)
+	if value["owner"] != testAddress1 || value["amount"] != "100" {
+		t.Errorf("unexpected approval value: %s", receipt.Events[0].Value)
+	}
+	if !gjson.Valid(receipt.RawReceipt) || gjson.Get(receipt.RawReceipt, "#").Int() != 2 {
+		t.Errorf("unexpected raw receipt: %s", receipt.RawReceipt)
+	}
+}
@@ -16,6 +16,7 @@
 package ontology
 
 import (
+	"encoding/hex"
 	"fmt"
 	"github.com/blocktree/go-owcdrivers/addressEncoder"
 	"github.com/blocktree/go-owcdrivers/ontologyTransaction"
@@ -145,7 +146,11 @@ func (dec *AddressDecoderV2) WIFToPrivateKey(wif string, isTestnet bool) ([]byte
 
 //RedeemScriptToAddress 多重签名赎回脚本转地址
 func (dec *AddressDecoderV2) RedeemScriptToAddress(pubs [][]byte, required uint64, isTestnet bool) (string, error) {
-	return "", fmt.Errorf("RedeemScriptToAddress not implement")
+	multiSig := &MultiSig{Required: required}
+	for _, pub := range pubs {
+		multiSig.PublicKeys = append(multiSig.PublicKeys, hex.EncodeToString(pub))
+	}
+	return multiSig.Address()
 }
 
 // CustomCreateAddress 创建账户地址
@@ -0,0 +1,453 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//地址交易记录方向
+const (
+	TxDirectionIn  = "in"
+	TxDirectionOut = "out"
+)
+
+const DefaultTxIndexCompact = 1000 //地址交易索引文件中被覆盖的记录达到该数量时重写文件
+
+//AddressTxRecord 地址在一笔交易中某个资产的转入或转出
+type AddressTxRecord struct {
+	TxID        string
+	BlockHeight uint64
+	BlockHash   string
+	Address     string
+	Coin        openwallet.Coin
+	Direction   string
+	Amount      string
+	Fee         string //转出记录中该地址支付的ONG手续费
+	Status      string //交易状态，合约执行失败时只有手续费记录，旧版本的记录为空视为成功
+	CreateAt    int64
+}
+
+func (r *AddressTxRecord) key() string {
+	return r.TxID + "_" + r.Address + "_" + r.Direction + "_" + r.Coin.ContractID
+}
+
+//addressTxIndex 本地地址交易索引，记录按行追加到数据文件，回滚或被覆盖的记录达到compact条时重写文件
+type addressTxIndex struct {
+	file    string
+	records map[string]*AddressTxRecord
+	byAddr  map[string]map[string]*AddressTxRecord
+	lines   int //数据文件的行数，减去有效记录数为被覆盖的记录数
+	compact int
+	mu      sync.RWMutex
+}
+
+//newAddressTxIndex 打开地址交易索引，已存在时加载原有记录
+func newAddressTxIndex(file string) (*addressTxIndex, error) {
+	index := &addressTxIndex{
+		file:    file,
+		records: make(map[string]*AddressTxRecord),
+		byAddr:  make(map[string]map[string]*AddressTxRecord),
+		compact: DefaultTxIndexCompact,
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return nil, fmt.Errorf("open address tx index failed: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		index.lines++
+		record := &AddressTxRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			//追加时中断的最后一行
+			log.Std.Warning("address tx index skip broken record: %s", scanner.Text())
+			continue
+		}
+		index.put(record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read address tx index failed: %w", err)
+	}
+	return index, nil
+}
+
+//put 后写入的记录覆盖相同的记录，调用方需持有锁
+func (index *addressTxIndex) put(record *AddressTxRecord) {
+	key := record.key()
+	index.records[key] = record
+	addrRecords, exist := index.byAddr[record.Address]
+	if !exist {
+		addrRecords = make(map[string]*AddressTxRecord)
+		index.byAddr[record.Address] = addrRecords
+	}
+	addrRecords[key] = record
+}
+
+//add 追加记录，重扫区块时覆盖原有记录
+func (index *addressTxIndex) add(records ...*AddressTxRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(index.file), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(index.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+		index.put(record)
+		index.lines++
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	//重扫区块覆盖的记录过多时重写文件，避免文件无限增长
+	if index.lines-len(index.records) >= index.compact {
+		return index.rewrite()
+	}
+	return nil
+}
+
+//deleteFromHeight 删除不低于height的记录，分叉回滚时调用
+func (index *addressTxIndex) deleteFromHeight(height uint64) error {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	deleted := false
+	for key, record := range index.records {
+		if record.BlockHeight >= height {
+			delete(index.records, key)
+			delete(index.byAddr[record.Address], key)
+			deleted = true
+		}
+	}
+	if !deleted {
+		return nil
+	}
+	return index.rewrite()
+}
+
+//rewrite 先写临时文件再替换，调用方需持有锁
+func (index *addressTxIndex) rewrite() error {
+	dir := filepath.Dir(index.file)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(index.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, record := range index.records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), index.file); err != nil {
+		return err
+	}
+	index.lines = len(index.records)
+	return nil
+}
+
+//query 按交易分组查询地址的记录，按区块高度从新到旧分页，coin为合约时只返回该合约资产的记录
+func (index *addressTxIndex) query(offset, limit int, coin openwallet.Coin, addresses ...string) [][]*AddressTxRecord {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	txs := make(map[string][]*AddressTxRecord)
+	for _, address := range addresses {
+		for _, record := range index.byAddr[address] {
+			if coin.IsContract && record.Coin.Contract.Address != coin.Contract.Address {
+				continue
+			}
+			txs[record.TxID] = append(txs[record.TxID], record)
+		}
+	}
+
+	list := make([][]*AddressTxRecord, 0, len(txs))
+	for _, records := range txs {
+		sort.Slice(records, func(i, j int) bool { return records[i].key() < records[j].key() })
+		list = append(list, records)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i][0].BlockHeight != list[j][0].BlockHeight {
+			return list[i][0].BlockHeight > list[j][0].BlockHeight
+		}
+		return list[i][0].TxID < list[j][0].TxID
+	})
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(list) {
+		return nil
+	}
+	list = list[offset:]
+	if limit > 0 && limit < len(list) {
+		list = list[:limit]
+	}
+	return list
+}
+
+//newAddressTxRecords 把提取的交易数据汇总为地址交易记录，同一地址同一资产同一方向的金额合并，手续费记在转出记录
+func newAddressTxRecords(data *openwallet.TxExtractData) []*AddressTxRecord {
+	var (
+		records = make(map[string]*AddressTxRecord)
+		order   = make([]string, 0)
+		fees    = make(map[string]decimal.Decimal)
+		feeTx   = make(map[string]*openwallet.Recharge)
+		status  = openwallet.TxStatusSuccess
+	)
+	if data.Transaction != nil && len(data.Transaction.Status) > 0 {
+		status = data.Transaction.Status
+	}
+
+	add := func(r *openwallet.Recharge, direction string) {
+		record := &AddressTxRecord{
+			TxID:        r.TxID,
+			BlockHeight: r.BlockHeight,
+			BlockHash:   r.BlockHash,
+			Address:     r.Address,
+			Coin:        r.Coin,
+			Direction:   direction,
+			Amount:      r.Amount,
+			Status:      status,
+			CreateAt:    r.CreateAt,
+		}
+		key := record.key()
+		if exist, ok := records[key]; ok {
+			exist.Amount = addAmount(exist.Amount, r.Amount)
+			return
+		}
+		records[key] = record
+		order = append(order, key)
+	}
+
+	for _, input := range data.TxInputs {
+		if len(input.Symbol) == 0 {
+			continue
+		}
+		if input.TxType == 1 {
+			amount, _ := decimal.NewFromString(input.Amount)
+			fees[input.Address] = fees[input.Address].Add(amount)
+			feeTx[input.Address] = &input.Recharge
+			continue
+		}
+		add(&input.Recharge, TxDirectionOut)
+	}
+	for _, output := range data.TxOutputs {
+		if len(output.Symbol) == 0 {
+			continue
+		}
+		add(&output.Recharge, TxDirectionIn)
+	}
+
+	for address, fee := range fees {
+		var outRecord *AddressTxRecord
+		for _, key := range order {
+			if r := records[key]; r.Address == address && r.Direction == TxDirectionOut {
+				outRecord = r
+				break
+			}
+		}
+		if outRecord == nil {
+			//只支付了手续费
+			r := feeTx[address]
+			outRecord = &AddressTxRecord{
+				TxID:        r.TxID,
+				BlockHeight: r.BlockHeight,
+				BlockHash:   r.BlockHash,
+				Address:     address,
+				Coin:        r.Coin,
+				Direction:   TxDirectionOut,
+				Amount:      "0",
+				Status:      status,
+				CreateAt:    r.CreateAt,
+			}
+			key := outRecord.key()
+			records[key] = outRecord
+			order = append(order, key)
+		}
+		outRecord.Fee = fee.String()
+	}
+
+	list := make([]*AddressTxRecord, 0, len(order))
+	for _, key := range order {
+		list = append(list, records[key])
+	}
+	return list
+}
+
+func addAmount(a, b string) string {
+	x, _ := decimal.NewFromString(a)
+	y, _ := decimal.NewFromString(b)
+	return x.Add(y).String()
+}
+
+//newTxExtractDataFromRecords 把一笔交易的地址记录还原为提取数据
+func (bs *ONTBlockScanner) newTxExtractDataFromRecords(records []*AddressTxRecord) *openwallet.TxExtractData {
+	data := openwallet.NewBlockExtractData()
+	first := records[0]
+	tx := &openwallet.Transaction{
+		TxID:        first.TxID,
+		Coin:        first.Coin,
+		BlockHash:   first.BlockHash,
+		BlockHeight: first.BlockHeight,
+		Amount:      "0",
+		Fees:        "0",
+		Decimal:     int32(first.Coin.Contract.Decimals),
+		ConfirmTime: first.CreateAt,
+		Status:      first.Status,
+	}
+	if len(tx.Status) == 0 {
+		tx.Status = openwallet.TxStatusSuccess
+	}
+
+	for i, r := range records {
+		recharge := openwallet.Recharge{
+			TxID:        r.TxID,
+			Address:     r.Address,
+			Symbol:      bs.wm.Symbol(),
+			Coin:        r.Coin,
+			Amount:      r.Amount,
+			BlockHash:   r.BlockHash,
+			BlockHeight: r.BlockHeight,
+			Index:       uint64(i),
+			CreateAt:    r.CreateAt,
+		}
+		if r.Direction == TxDirectionOut {
+			recharge.Sid = openwallet.GenTxInputSID(r.TxID, r.Coin.Symbol, r.Coin.Contract.Address, uint64(i))
+			data.TxInputs = append(data.TxInputs, &openwallet.TxInput{Recharge: recharge})
+			tx.From = append(tx.From, r.Address+":"+r.Amount)
+			if len(r.Fee) > 0 {
+				tx.Fees = addAmount(tx.Fees, r.Fee)
+			}
+		} else {
+			recharge.Received = true
+			recharge.Sid = openwallet.GenTxOutPutSID(r.TxID, r.Coin.Symbol, r.Coin.Contract.Address, uint64(i))
+			data.TxOutputs = append(data.TxOutputs, &openwallet.TxOutPut{Recharge: recharge})
+			tx.To = append(tx.To, r.Address+":"+r.Amount)
+		}
+		if r.Coin.ContractID == first.Coin.ContractID {
+			tx.Amount = addAmount(tx.Amount, r.Amount)
+		}
+	}
+	tx.WxID = openwallet.GenTransactionWxID(tx)
+	data.Transaction = tx
+	return data
+}
+
+//addressTxIndex 获取本地地址交易索引，首次使用时打开数据文件
+func (bs *ONTBlockScanner) addressTxIndex() (*addressTxIndex, error) {
+	bs.daiMu.Lock()
+	defer bs.daiMu.Unlock()
+
+	if bs.txIndex != nil {
+		return bs.txIndex, nil
+	}
+
+	index, err := newAddressTxIndex(bs.wm.Config.txIndexFilePath())
+	if err != nil {
+		return nil, err
+	}
+	bs.txIndex = index
+	return index, nil
+}
+
+//resetAddressTxIndex 数据目录变化后，下次使用时重新打开数据文件
+func (bs *ONTBlockScanner) resetAddressTxIndex() {
+	bs.daiMu.Lock()
+	defer bs.daiMu.Unlock()
+	bs.txIndex = nil
+}
+
+//saveAddressTxRecords 把提取的交易数据写入地址交易索引
+func (bs *ONTBlockScanner) saveAddressTxRecords(extractData map[string]*openwallet.TxExtractData) error {
+	index, err := bs.addressTxIndex()
+	if err != nil {
+		return err
+	}
+
+	records := make([]*AddressTxRecord, 0)
+	for _, data := range extractData {
+		records = append(records, newAddressTxRecords(data)...)
+	}
+	return index.add(records...)
+}
+
+//deleteAddressTxRecords 删除分叉区块的地址交易记录
+func (bs *ONTBlockScanner) deleteAddressTxRecords(height uint64) error {
+	index, err := bs.addressTxIndex()
+	if err != nil {
+		return err
+	}
+	return index.deleteFromHeight(height)
+}
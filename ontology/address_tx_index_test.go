@@ -0,0 +1,153 @@
+package ontology
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/go-owcdrivers/ontologyTransaction"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func testIndexCoin(contract string) openwallet.Coin {
+	return openwallet.Coin{
+		Symbol:     "ONT",
+		IsContract: true,
+		ContractID: contract,
+		Contract:   openwallet.SmartContract{ContractID: contract, Symbol: "ONT", Address: contract},
+	}
+}
+
+func testExtractData(txid string, height uint64, from, to, amount string) *openwallet.TxExtractData {
+	recharge := func(address, amount, contract string) openwallet.Recharge {
+		return openwallet.Recharge{TxID: txid, Address: address, Symbol: "ONT", Coin: testIndexCoin(contract), Amount: amount, BlockHeight: height}
+	}
+	fee := openwallet.TxInput{Recharge: recharge(from, "10000000", ontologyTransaction.ONGContractAddress)}
+	fee.TxType = 1
+	return &openwallet.TxExtractData{
+		TxInputs: []*openwallet.TxInput{
+			{Recharge: recharge(from, amount, ontologyTransaction.ONTContractAddress)},
+			&fee,
+		},
+		TxOutputs: []*openwallet.TxOutPut{
+			{Recharge: recharge(to, amount, ontologyTransaction.ONTContractAddress)},
+			{Recharge: openwallet.Recharge{Address: "fee collector"}},
+		},
+	}
+}
+
+func TestAddressTxIndex(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onttxindex")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "txindex.jsonl")
+	index, err := newAddressTxIndex(file)
+	if err != nil {
+		t.Fatalf("newAddressTxIndex failed unexpected error: %v", err)
+	}
+
+	for h := uint64(1); h <= 3; h++ {
+		data := testExtractData(fmt.Sprintf("tx%d", h), h, "A", "B", "5")
+		if err := index.add(newAddressTxRecords(data)...); err != nil {
+			t.Fatalf("add failed unexpected error: %v", err)
+		}
+	}
+	//重扫区块覆盖原有记录
+	index.add(newAddressTxRecords(testExtractData("tx3", 3, "A", "B", "5"))...)
+
+	index, err = newAddressTxIndex(file)
+	if err != nil {
+		t.Fatalf("reload failed unexpected error: %v", err)
+	}
+
+	all := index.query(0, 0, openwallet.Coin{Symbol: "ONT"}, "A")
+	if len(all) != 3 || all[0][0].TxID != "tx3" || all[2][0].TxID != "tx1" {
+		t.Fatalf("unexpected txs of A: %d", len(all))
+	}
+	if len(all[0]) != 1 || all[0][0].Direction != TxDirectionOut || all[0][0].Amount != "5" || all[0][0].Fee != "10000000" {
+		t.Errorf("unexpected record: %+v", all[0][0])
+	}
+
+	page := index.query(1, 1, openwallet.Coin{Symbol: "ONT"}, "A", "B")
+	if len(page) != 1 || page[0][0].TxID != "tx2" || len(page[0]) != 2 {
+		t.Errorf("unexpected page: %+v", page)
+	}
+
+	ong := index.query(0, 0, testIndexCoin(ontologyTransaction.ONGContractAddress), "A")
+	if len(ong) != 0 {
+		t.Errorf("A has no ONG transfer records, got %d", len(ong))
+	}
+
+	//分叉回滚
+	if err := index.deleteFromHeight(2); err != nil {
+		t.Fatalf("deleteFromHeight failed unexpected error: %v", err)
+	}
+	index, _ = newAddressTxIndex(file)
+	if all := index.query(0, 0, openwallet.Coin{Symbol: "ONT"}, "A", "B"); len(all) != 1 || all[0][0].TxID != "tx1" {
+		t.Errorf("records from height 2 should be deleted")
+	}
+}
+
+func TestAddressTxIndex_Compact(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onttxindex")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "txindex.jsonl")
+	index, err := newAddressTxIndex(file)
+	if err != nil {
+		t.Fatalf("newAddressTxIndex failed unexpected error: %v", err)
+	}
+	index.compact = 10
+
+	countLines := func() int {
+		content, _ := ioutil.ReadFile(file)
+		return strings.Count(string(content), "\n")
+	}
+
+	//重扫同一区块，A的转出记录和B的转入记录被覆盖
+	records := newAddressTxRecords(testExtractData("tx1", 1, "A", "B", "5"))
+	for i := 0; i < 20; i++ {
+		if err := index.add(records...); err != nil {
+			t.Fatalf("add failed unexpected error: %v", err)
+		}
+		if lines := countLines(); lines-len(records) >= index.compact {
+			t.Fatalf("superseded records should be compacted, file has %d lines", lines)
+		}
+	}
+
+	index, err = newAddressTxIndex(file)
+	if err != nil {
+		t.Fatalf("reload failed unexpected error: %v", err)
+	}
+	if index.lines != countLines() || len(index.records) != len(records) {
+		t.Errorf("unexpected reloaded index: %d lines, %d records", index.lines, len(index.records))
+	}
+	if all := index.query(0, 0, openwallet.Coin{Symbol: "ONT"}, "A"); len(all) != 1 || all[0][0].TxID != "tx1" {
+		t.Errorf("unexpected txs of A after compact: %d", len(all))
+	}
+}
+
+func TestONTBlockScanner_FailedAddressTxRecords(t *testing.T) {
+	data := testExtractData("tx1", 1, "A", "B", "5")
+	data.Transaction = &openwallet.Transaction{TxID: "tx1", Status: openwallet.TxStatusFail}
+	records := newAddressTxRecords(data)
+	for _, record := range records {
+		if record.Status != openwallet.TxStatusFail {
+			t.Errorf("record status = %s, want failed", record.Status)
+		}
+	}
+
+	bs := NewONTBlockScanner(NewWalletManager())
+	if tx := bs.newTxExtractDataFromRecords(records).Transaction; tx.Status != openwallet.TxStatusFail {
+		t.Errorf("transaction status = %s, want failed", tx.Status)
+	}
+
+	//旧版本没有状态的记录视为成功
+	records[0].Status = ""
+	if tx := bs.newTxExtractDataFromRecords(records[:1]).Transaction; tx.Status != openwallet.TxStatusSuccess {
+		t.Errorf("transaction status = %s, want success", tx.Status)
+	}
+}
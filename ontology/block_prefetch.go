@@ -0,0 +1,112 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"github.com/blocktree/openwallet/v2/log"
+)
+
+const (
+	DefaultBlockPrefetchSize = 10 //追块时默认并发预取的区块数
+)
+
+//prefetchedBlock 预取的区块和合约事件
+type prefetchedBlock struct {
+	height   uint64
+	hash     string
+	hashErr  error
+	block    *Block
+	blockErr error
+	events   map[string]*smartCodeEvent
+}
+
+//blockPrefetcher 并发预取后续区块，调用方按高度顺序取出
+type blockPrefetcher struct {
+	size    uint64
+	fetch   func(height uint64) *prefetchedBlock
+	pending map[uint64]chan *prefetchedBlock
+	next    uint64
+}
+
+//newBlockPrefetcher 创建预取器，size为同时预取的区块数
+func newBlockPrefetcher(size uint64, fetch func(height uint64) *prefetchedBlock) *blockPrefetcher {
+	if size == 0 {
+		size = 1
+	}
+	return &blockPrefetcher{
+		size:    size,
+		fetch:   fetch,
+		pending: make(map[uint64]chan *prefetchedBlock),
+	}
+}
+
+//get 取出指定高度的区块，同时保持后续不超过maxHeight的size个高度在预取中
+func (p *blockPrefetcher) get(height, maxHeight uint64) *prefetchedBlock {
+
+	//高度不连续时丢弃之前的预取
+	if _, exist := p.pending[height]; !exist {
+		p.reset()
+		p.next = height
+	}
+
+	for p.next <= maxHeight && p.next < height+p.size {
+		//缓冲为1，丢弃的预取不会阻塞
+		ch := make(chan *prefetchedBlock, 1)
+		p.pending[p.next] = ch
+		go func(h uint64) {
+			ch <- p.fetch(h)
+		}(p.next)
+		p.next++
+	}
+
+	ch, exist := p.pending[height]
+	if !exist {
+		return p.fetch(height)
+	}
+	delete(p.pending, height)
+	return <-ch
+}
+
+//reset 丢弃所有预取，分叉回滚后调用
+func (p *blockPrefetcher) reset() {
+	p.pending = make(map[uint64]chan *prefetchedBlock)
+}
+
+//prefetchBlock 获取指定高度的区块和区块内所有交易的合约事件
+func (bs *ONTBlockScanner) prefetchBlock(height uint64) *prefetchedBlock {
+
+	result := &prefetchedBlock{height: height}
+
+	result.hash, result.hashErr = bs.wm.GetBlockHash(height)
+	if result.hashErr != nil {
+		return result
+	}
+
+	result.block, result.blockErr = bs.wm.GetBlock(result.hash)
+	if result.blockErr != nil || len(result.block.Transactions) == 0 {
+		return result
+	}
+
+	events, err := bs.wm.RPCClient.getBlockEvents(height)
+	if err != nil {
+		//提取时再逐笔获取
+		log.Std.Info("block scanner can not get events of block: %d; unexpected error: %v", height, err)
+		return result
+	}
+	result.events = events
+
+	return result
+}
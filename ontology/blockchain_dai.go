@@ -0,0 +1,442 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const (
+	DefaultMaxBlockCache    = 1000  //本地默认保留的最近区块头数量
+	DefaultMaxUnscanRecords = 10000 //本地最多保留的未扫记录数量
+	DefaultChainLogCompact  = 1000  //日志文件追加多少条修改后合并到数据文件
+)
+
+//本地区块链数据的修改类型
+const (
+	chainOpCurrentHead  = "head"
+	chainOpBlockHead    = "block"
+	chainOpUnscanRecord = "unscan"
+	chainOpDeleteID     = "deleteID"
+	chainOpDeleteHeight = "deleteHeight"
+	chainOpMaxCache     = "maxCache"
+)
+
+//localChainOp 追加到日志文件的一条修改
+type localChainOp struct {
+	Op     string
+	Symbol string
+	Header *openwallet.BlockHeader  `json:",omitempty"`
+	Record *openwallet.UnscanRecord `json:",omitempty"`
+	ID     string                   `json:",omitempty"`
+	Height uint64                   `json:",omitempty"`
+}
+
+//localChainData 单个币种的本地区块链数据
+type localChainData struct {
+	CurrentHead   *openwallet.BlockHeader
+	BlockHeads    map[uint64]*openwallet.BlockHeader
+	UnscanRecords map[string]*openwallet.UnscanRecord
+	MaxBlockCache uint64
+}
+
+//LocalBlockchainDAI 内置的区块链数据访问实现，数据以JSON文件保存在本地目录
+//每次修改只追加到日志文件，日志达到compact条后合并到数据文件并清空日志
+type LocalBlockchainDAI struct {
+	file     string
+	data     map[string]*localChainData
+	logCount int
+	compact  int
+	mu       sync.Mutex
+}
+
+//NewLocalBlockchainDAI 创建本地区块链数据访问实现，file为数据文件路径，已存在时加载原有数据并重放日志
+func NewLocalBlockchainDAI(file string) (*LocalBlockchainDAI, error) {
+	dai := &LocalBlockchainDAI{
+		file:    file,
+		data:    make(map[string]*localChainData),
+		compact: DefaultChainLogCompact,
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read blockchain data file failed: %w", err)
+	}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &dai.data); err != nil {
+			return nil, fmt.Errorf("blockchain data file %s is broken: %w", file, err)
+		}
+		for _, chain := range dai.data {
+			chain.init()
+		}
+	}
+
+	if err := dai.replay(); err != nil {
+		return nil, err
+	}
+	return dai, nil
+}
+
+func (chain *localChainData) init() {
+	if chain.BlockHeads == nil {
+		chain.BlockHeads = make(map[uint64]*openwallet.BlockHeader)
+	}
+	if chain.UnscanRecords == nil {
+		chain.UnscanRecords = make(map[string]*openwallet.UnscanRecord)
+	}
+	if chain.MaxBlockCache == 0 {
+		chain.MaxBlockCache = DefaultMaxBlockCache
+	}
+}
+
+//chain 获取币种数据，不存在时创建，调用方需持有锁
+func (dai *LocalBlockchainDAI) chain(symbol string) *localChainData {
+	symbol = strings.ToUpper(symbol)
+	chain, exist := dai.data[symbol]
+	if !exist {
+		chain = &localChainData{}
+		chain.init()
+		dai.data[symbol] = chain
+	}
+	return chain
+}
+
+func (dai *LocalBlockchainDAI) logFile() string {
+	return dai.file + ".log"
+}
+
+//replay 在数据文件之上重放日志中的修改
+func (dai *LocalBlockchainDAI) replay() error {
+	f, err := os.Open(dai.logFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("open blockchain data log failed: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		op := &localChainOp{}
+		if err := json.Unmarshal(scanner.Bytes(), op); err != nil {
+			//追加时中断的最后一行
+			log.Std.Warning("blockchain data log skip broken op: %s", scanner.Text())
+			continue
+		}
+		dai.apply(op)
+		dai.logCount++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read blockchain data log failed: %w", err)
+	}
+	return nil
+}
+
+//apply 修改内存中的数据，调用方需持有锁
+func (dai *LocalBlockchainDAI) apply(op *localChainOp) {
+	chain := dai.chain(op.Symbol)
+	switch op.Op {
+	case chainOpCurrentHead:
+		chain.CurrentHead = op.Header
+	case chainOpBlockHead:
+		chain.BlockHeads[op.Header.Height] = op.Header
+		chain.prune()
+	case chainOpUnscanRecord:
+		chain.UnscanRecords[op.Record.ID] = op.Record
+		chain.prune()
+	case chainOpDeleteID:
+		delete(chain.UnscanRecords, op.ID)
+	case chainOpDeleteHeight:
+		for id, r := range chain.UnscanRecords {
+			if r.BlockHeight == op.Height {
+				delete(chain.UnscanRecords, id)
+			}
+		}
+	case chainOpMaxCache:
+		chain.MaxBlockCache = op.Height
+		chain.prune()
+	}
+}
+
+//commit 应用修改并追加到日志文件，日志达到合并条数时改为重写数据文件，调用方需持有锁
+func (dai *LocalBlockchainDAI) commit(op *localChainOp) error {
+	dai.apply(op)
+
+	if dai.logCount+1 >= dai.compact {
+		return dai.save()
+	}
+
+	line, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dai.file), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dai.logFile(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	dai.logCount++
+	return nil
+}
+
+//save 合并数据到数据文件后清空日志，先写临时文件再替换，避免写入中断导致数据文件损坏，调用方需持有锁
+//替换后清空日志前中断时，重放日志得到的数据不变
+func (dai *LocalBlockchainDAI) save() error {
+	content, err := json.Marshal(dai.data)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(dai.file)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(dai.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dai.file); err != nil {
+		return err
+	}
+	if err := os.Remove(dai.logFile()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	dai.logCount = 0
+	return nil
+}
+
+//prune 只保留最近MaxBlockCache个区块头，未扫记录超出上限时丢弃最早的记录
+func (chain *localChainData) prune() {
+	if uint64(len(chain.BlockHeads)) > chain.MaxBlockCache {
+		heights := make([]uint64, 0, len(chain.BlockHeads))
+		for h := range chain.BlockHeads {
+			heights = append(heights, h)
+		}
+		sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+		for _, h := range heights[:uint64(len(heights))-chain.MaxBlockCache] {
+			delete(chain.BlockHeads, h)
+		}
+	}
+
+	if len(chain.UnscanRecords) > DefaultMaxUnscanRecords {
+		records := make([]*openwallet.UnscanRecord, 0, len(chain.UnscanRecords))
+		for _, r := range chain.UnscanRecords {
+			records = append(records, r)
+		}
+		sort.Slice(records, func(i, j int) bool { return records[i].BlockHeight < records[j].BlockHeight })
+		for _, r := range records[:len(records)-DefaultMaxUnscanRecords] {
+			log.Std.Warning("too many unscan records, drop record of block: %d tx: %s", r.BlockHeight, r.TxID)
+			delete(chain.UnscanRecords, r.ID)
+		}
+	}
+}
+
+//SaveCurrentBlockHead 保存当前已扫区块头
+func (dai *LocalBlockchainDAI) SaveCurrentBlockHead(header *openwallet.BlockHeader) error {
+	if header == nil {
+		return fmt.Errorf("block header is nil")
+	}
+
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+
+	head := *header
+	return dai.commit(&localChainOp{Op: chainOpCurrentHead, Symbol: header.Symbol, Header: &head})
+}
+
+//GetCurrentBlockHead 获取当前已扫区块头，没有记录时返回高度为0的区块头
+func (dai *LocalBlockchainDAI) GetCurrentBlockHead(symbol string) (*openwallet.BlockHeader, error) {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+
+	chain := dai.chain(symbol)
+	if chain.CurrentHead == nil {
+		return &openwallet.BlockHeader{Symbol: symbol}, nil
+	}
+	head := *chain.CurrentHead
+	return &head, nil
+}
+
+//SaveLocalBlockHead 保存区块头，超出缓存数量时删除最早的区块头
+func (dai *LocalBlockchainDAI) SaveLocalBlockHead(header *openwallet.BlockHeader) error {
+	if header == nil {
+		return fmt.Errorf("block header is nil")
+	}
+
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+
+	head := *header
+	return dai.commit(&localChainOp{Op: chainOpBlockHead, Symbol: header.Symbol, Header: &head})
+}
+
+//GetLocalBlockHeadByHeight 获取指定高度的区块头
+func (dai *LocalBlockchainDAI) GetLocalBlockHeadByHeight(height uint64, symbol string) (*openwallet.BlockHeader, error) {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+
+	header, exist := dai.chain(symbol).BlockHeads[height]
+	if !exist {
+		return nil, fmt.Errorf("local block head of height: %d not found", height)
+	}
+	head := *header
+	return &head, nil
+}
+
+//SaveUnscanRecord 保存未扫记录
+func (dai *LocalBlockchainDAI) SaveUnscanRecord(record *openwallet.UnscanRecord) error {
+	if record == nil {
+		return fmt.Errorf("unscan record is nil")
+	}
+
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+
+	r := *record
+	return dai.commit(&localChainOp{Op: chainOpUnscanRecord, Symbol: record.Symbol, Record: &r})
+}
+
+//DeleteUnscanRecordByHeight 删除指定高度的所有未扫记录
+func (dai *LocalBlockchainDAI) DeleteUnscanRecordByHeight(height uint64, symbol string) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+
+	for _, r := range dai.chain(symbol).UnscanRecords {
+		if r.BlockHeight == height {
+			return dai.commit(&localChainOp{Op: chainOpDeleteHeight, Symbol: symbol, Height: height})
+		}
+	}
+	return nil
+}
+
+//DeleteUnscanRecordByID 删除指定ID的未扫记录
+func (dai *LocalBlockchainDAI) DeleteUnscanRecordByID(id string, symbol string) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+
+	if _, exist := dai.chain(symbol).UnscanRecords[id]; !exist {
+		return nil
+	}
+	return dai.commit(&localChainOp{Op: chainOpDeleteID, Symbol: symbol, ID: id})
+}
+
+//GetTransactionsByTxID 本地不保存交易
+func (dai *LocalBlockchainDAI) GetTransactionsByTxID(txid, symbol string) ([]*openwallet.Transaction, error) {
+	return nil, fmt.Errorf("local blockchain data does not store transactions")
+}
+
+//GetUnscanRecords 获取所有未扫记录，按区块高度排序
+func (dai *LocalBlockchainDAI) GetUnscanRecords(symbol string) ([]*openwallet.UnscanRecord, error) {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+
+	chain := dai.chain(symbol)
+	list := make([]*openwallet.UnscanRecord, 0, len(chain.UnscanRecords))
+	for _, record := range chain.UnscanRecords {
+		r := *record
+		list = append(list, &r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].BlockHeight != list[j].BlockHeight {
+			return list[i].BlockHeight < list[j].BlockHeight
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+//SetMaxBlockCache 设置保留的最近区块头数量
+func (dai *LocalBlockchainDAI) SetMaxBlockCache(max uint64, symbol string) error {
+	if max == 0 {
+		return fmt.Errorf("max block cache must greater than 0")
+	}
+
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+
+	return dai.commit(&localChainOp{Op: chainOpMaxCache, Symbol: symbol, Height: max})
+}
+
+//blockchainDAI 获取区块链数据访问接口，外部没有设置时使用本地数据文件
+func (bs *ONTBlockScanner) blockchainDAI() (openwallet.BlockchainDAI, error) {
+	bs.daiMu.Lock()
+	defer bs.daiMu.Unlock()
+
+	if bs.BlockchainDAI != nil {
+		return bs.BlockchainDAI, nil
+	}
+
+	dai, err := NewLocalBlockchainDAI(bs.wm.Config.blockchainFilePath())
+	if err != nil {
+		return nil, fmt.Errorf("Blockchain DAI is not setup: %w", err)
+	}
+	bs.BlockchainDAI = dai
+	return dai, nil
+}
+
+//useLocalBlockchainDAI 数据目录变化后重新加载本地数据文件，外部设置的DAI保持不变
+func (bs *ONTBlockScanner) useLocalBlockchainDAI() error {
+	bs.daiMu.Lock()
+	defer bs.daiMu.Unlock()
+
+	if _, isLocal := bs.BlockchainDAI.(*LocalBlockchainDAI); bs.BlockchainDAI != nil && !isLocal {
+		return nil
+	}
+
+	dai, err := NewLocalBlockchainDAI(bs.wm.Config.blockchainFilePath())
+	if err != nil {
+		return err
+	}
+	bs.BlockchainDAI = dai
+	return nil
+}
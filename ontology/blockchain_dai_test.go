@@ -0,0 +1,124 @@
+package ontology
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestLocalBlockchainDAI(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ontdai")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "blockchain.json")
+	dai, err := NewLocalBlockchainDAI(file)
+	if err != nil {
+		t.Fatalf("NewLocalBlockchainDAI failed unexpected error: %v", err)
+	}
+
+	head, err := dai.GetCurrentBlockHead("ONT")
+	if err != nil || head.Height != 0 {
+		t.Fatalf("GetCurrentBlockHead = %+v, %v, want empty head", head, err)
+	}
+
+	dai.SetMaxBlockCache(3, "ONT")
+	for h := uint64(1); h <= 5; h++ {
+		dai.SaveLocalBlockHead(&openwallet.BlockHeader{Hash: "hash", Height: h, Symbol: "ONT"})
+	}
+	dai.SaveCurrentBlockHead(&openwallet.BlockHeader{Hash: "hash5", Height: 5, Symbol: "ONT"})
+	dai.SaveUnscanRecord(&openwallet.UnscanRecord{ID: "a", BlockHeight: 4, TxID: "tx1", Symbol: "ONT"})
+	dai.SaveUnscanRecord(&openwallet.UnscanRecord{ID: "b", BlockHeight: 5, TxID: "tx2", Symbol: "ONT"})
+	dai.SaveUnscanRecord(&openwallet.UnscanRecord{ID: "c", BlockHeight: 5, TxID: "tx3", Symbol: "ONT"})
+	dai.DeleteUnscanRecordByID("a", "ONT")
+
+	//重新加载数据文件
+	dai, err = NewLocalBlockchainDAI(file)
+	if err != nil {
+		t.Fatalf("reload failed unexpected error: %v", err)
+	}
+
+	head, err = dai.GetCurrentBlockHead("ONT")
+	if err != nil || head.Height != 5 || head.Hash != "hash5" {
+		t.Errorf("GetCurrentBlockHead = %+v, %v, want height 5", head, err)
+	}
+	if _, err := dai.GetLocalBlockHeadByHeight(2, "ONT"); err == nil {
+		t.Errorf("block head 2 should be pruned")
+	}
+	if _, err := dai.GetLocalBlockHeadByHeight(3, "ONT"); err != nil {
+		t.Errorf("GetLocalBlockHeadByHeight(3) unexpected error: %v", err)
+	}
+
+	records, _ := dai.GetUnscanRecords("ONT")
+	if len(records) != 2 || records[0].ID != "b" || records[1].ID != "c" {
+		t.Errorf("unexpected unscan records: %+v", records)
+	}
+	dai.DeleteUnscanRecordByHeight(5, "ONT")
+	records, _ = dai.GetUnscanRecords("ONT")
+	if len(records) != 0 {
+		t.Errorf("unscan records of block 5 should be deleted: %+v", records)
+	}
+}
+
+func TestLocalBlockchainDAI_Compact(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ontdai")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "blockchain.json")
+	dai, _ := NewLocalBlockchainDAI(file)
+	dai.compact = 4
+
+	//未达到合并条数时只追加日志，不写数据文件
+	for h := uint64(1); h <= 3; h++ {
+		dai.SaveCurrentBlockHead(&openwallet.BlockHeader{Hash: "hash", Height: h, Symbol: "ONT"})
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("data file should not be written before compact: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(dai.logFile()); strings.Count(string(content), "\n") != 3 {
+		t.Errorf("data log should have 3 ops: %s", content)
+	}
+
+	//达到合并条数时重写数据文件并清空日志
+	dai.SaveCurrentBlockHead(&openwallet.BlockHeader{Hash: "hash4", Height: 4, Symbol: "ONT"})
+	if _, err := os.Stat(dai.logFile()); !os.IsNotExist(err) {
+		t.Errorf("data log should be removed after compact: %v", err)
+	}
+	dai.SaveUnscanRecord(&openwallet.UnscanRecord{ID: "a", BlockHeight: 4, TxID: "tx1", Symbol: "ONT"})
+
+	//重新加载时在数据文件之上重放日志
+	dai, err := NewLocalBlockchainDAI(file)
+	if err != nil {
+		t.Fatalf("reload failed unexpected error: %v", err)
+	}
+	if head, _ := dai.GetCurrentBlockHead("ONT"); head.Height != 4 || head.Hash != "hash4" {
+		t.Errorf("GetCurrentBlockHead = %+v, want height 4", head)
+	}
+	if records, _ := dai.GetUnscanRecords("ONT"); len(records) != 1 || records[0].ID != "a" {
+		t.Errorf("unexpected unscan records: %+v", records)
+	}
+	if dai.logCount != 1 {
+		t.Errorf("logCount = %d, want 1", dai.logCount)
+	}
+}
+
+func TestONTBlockScanner_DefaultBlockchainDAI(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ontdai")
+	defer os.RemoveAll(dir)
+
+	wm := NewWalletManager()
+	wm.Config.DataDir = dir
+	wm.Config.makeDataDir()
+
+	bs := NewONTBlockScanner(wm)
+	if err := bs.SaveLocalNewBlock(10, "hash10"); err != nil {
+		t.Fatalf("SaveLocalNewBlock failed unexpected error: %v", err)
+	}
+	height, hash, err := bs.GetLocalNewBlock()
+	if err != nil || height != 10 || hash != "hash10" {
+		t.Errorf("GetLocalNewBlock = %d, %s, %v, want 10 hash10", height, hash, err)
+	}
+}
@@ -16,9 +16,20 @@
 package ontology
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/blocktree/go-owcdrivers/ontologyTransaction"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/pborman/uuid"
@@ -298,3 +309,456 @@ func Test_tmp(t *testing.T) {
 	}
 	fmt.Println(string(txid))
 }
+
+func TestBlockPrefetcher(t *testing.T) {
+	var (
+		inflight    int32
+		maxInflight int32
+		mu          sync.Mutex
+		fetched     = make(map[uint64]int)
+	)
+
+	p := newBlockPrefetcher(4, func(height uint64) *prefetchedBlock {
+		n := atomic.AddInt32(&inflight, 1)
+		for {
+			m := atomic.LoadInt32(&maxInflight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInflight, m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&inflight, -1)
+
+		mu.Lock()
+		fetched[height]++
+		mu.Unlock()
+		return &prefetchedBlock{height: height}
+	})
+
+	for h := uint64(1); h <= 20; h++ {
+		if got := p.get(h, 20).height; got != h {
+			t.Fatalf("get(%d) returned height %d", h, got)
+		}
+	}
+
+	if m := atomic.LoadInt32(&maxInflight); m < 2 || m > 4 {
+		t.Errorf("max concurrent fetches = %d, want 2..4", m)
+	}
+	for h := uint64(1); h <= 20; h++ {
+		if fetched[h] != 1 {
+			t.Errorf("height %d fetched %d times, want 1", h, fetched[h])
+		}
+	}
+
+	//回滚后重新获取
+	p.reset()
+	if got := p.get(5, 20).height; got != 5 {
+		t.Errorf("get(5) after reset returned height %d", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fetched[5] != 2 {
+		t.Errorf("height 5 fetched %d times after reset, want 2", fetched[5])
+	}
+}
+
+func TestONTBlockScanner_GetBalanceByAddress(t *testing.T) {
+	node := newTestNode(func(method string, params []interface{}) interface{} {
+		if params[0] == "bad" {
+			return testRpcError(42002)
+		}
+		switch method {
+		case "getbalancev2":
+			return map[string]string{"ont": "1500000000", "ong": "2000000000000000000"}
+		case "getunboundong":
+			return "300000000000000000"
+		}
+		return nil
+	})
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	bs := NewONTBlockScanner(wm)
+
+	balances, err := bs.GetBalanceByAddress("addr1", "bad", "addr2")
+	balanceErr := &BalanceError{}
+	if !errors.As(err, &balanceErr) || len(balanceErr.Errors) != 1 || !errors.Is(balanceErr.Errors["bad"], ErrInvalidParams) {
+		t.Fatalf("GetBalanceByAddress error = %v, want error of address bad", err)
+	}
+	if len(balances) != 2 || balances[0].Address != "addr1" || balances[1].Address != "addr2" {
+		t.Fatalf("unexpected balances: %+v", balances)
+	}
+	if balances[0].Balance != "1.5" || balances[0].ConfirmBalance != "1.5" {
+		t.Errorf("ONT balance = %s, want 1.5", balances[0].Balance)
+	}
+
+	native, err := wm.GetNativeBalanceByAddress("addr1")
+	if err != nil || len(native) != 1 {
+		t.Fatalf("GetNativeBalanceByAddress = %+v, %v", native, err)
+	}
+	if native[0].ONGBalance.String() != "2000000000000000000" || native[0].ONGUnbound.String() != "300000000000000000" {
+		t.Errorf("unexpected ONG balance: %+v", native[0])
+	}
+}
+
+func TestONTBlockScanner_ExtractOEP4Transfer(t *testing.T) {
+	bs := NewONTBlockScanner(NewWalletManager())
+	token := &openwallet.SmartContract{Address: "a1b2c3d4e5f60718293a4b5c6d7e8f9011223344", Token: "TST", Protocol: OEP4Protocol, Decimals: 8}
+
+	scanTargetFunc := func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
+		switch {
+		case target.ScanTargetType == openwallet.ScanTargetTypeContractAddress && target.ScanTarget == token.Address:
+			return openwallet.ScanTargetResult{SourceKey: "token", Exist: true, TargetInfo: token}
+		case target.ScanTargetType == openwallet.ScanTargetTypeAccountAddress && target.ScanTarget == "to":
+			return openwallet.ScanTargetResult{SourceKey: "account", Exist: true}
+		}
+		return openwallet.ScanTargetResult{}
+	}
+
+	trx := &Transaction{
+		TxID:        "tx1",
+		BlockHeight: 10,
+		Notifys: []Notify{
+			{ContractAddress: token.Address, Method: "transfer", From: "from", To: "to", Amount: "100"},
+			{ContractAddress: "ffffffffffffffffffffffffffffffffffffffff", Method: "transfer", From: "from", To: "to", Amount: "1"},
+		},
+	}
+	result := &ExtractResult{extractData: make(map[string]*openwallet.TxExtractData)}
+	bs.extractTransaction(trx, result, scanTargetFunc)
+
+	ed := result.extractData["account"]
+	if !result.Success || ed == nil || len(ed.TxOutputs) != 1 {
+		t.Fatalf("unexpected extract data: %+v", result.extractData)
+	}
+	output := ed.TxOutputs[0]
+	if output.Amount != "100" || output.Coin.Contract.Address != token.Address || output.Coin.Contract.Token != "TST" || !output.Coin.IsContract {
+		t.Errorf("unexpected OEP-4 output: %+v", output)
+	}
+}
+
+func TestONTBlockScanner_ExtractTxStateAndFees(t *testing.T) {
+	bs := NewONTBlockScanner(NewWalletManager())
+	scanTargetFunc := func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
+		if target.ScanTargetType == openwallet.ScanTargetTypeAccountAddress && target.ScanTarget == testAddress1 {
+			return openwallet.ScanTargetResult{SourceKey: "account", Exist: true}
+		}
+		return openwallet.ScanTargetResult{}
+	}
+
+	//执行失败且节点没有返回手续费事件，只生成手续费扣款
+	failed := &Transaction{
+		TxID:        "tx1",
+		Payer:       testAddress1,
+		Failed:      true,
+		GasConsumed: 10000000,
+		BlockHeight: 10,
+		Notifys: []Notify{
+			{ContractAddress: ontologyTransaction.ONTContractAddress, Method: "transfer", From: testAddress1, To: testAddress7, Amount: "1000000000"},
+		},
+	}
+	result := &ExtractResult{extractData: make(map[string]*openwallet.TxExtractData)}
+	bs.extractTransaction(failed, result, scanTargetFunc)
+
+	ed := result.extractData["account"]
+	if !result.Success || ed == nil || len(ed.TxInputs) != 1 {
+		t.Fatalf("unexpected extract data: %+v", result.extractData)
+	}
+	input := ed.TxInputs[0]
+	if input.TxType != 1 || input.Amount != "10000000000000000" || input.Coin.Contract.Address != ontologyTransaction.ONGContractAddress {
+		t.Errorf("unexpected fee input: %+v", input)
+	}
+	if ed.Transaction.Status != openwallet.TxStatusFail || ed.Transaction.Fees != "10000000000000000" || ed.Transaction.TxType != 1 {
+		t.Errorf("unexpected failed transaction: %+v", ed.Transaction)
+	}
+
+	//执行成功，手续费取自手续费事件
+	success := &Transaction{
+		TxID:        "tx2",
+		Payer:       testAddress1,
+		GasConsumed: 10000000,
+		BlockHeight: 10,
+		Notifys: []Notify{
+			{ContractAddress: ontologyTransaction.ONTContractAddress, Method: "transfer", From: testAddress1, To: testAddress7, Amount: "1000000000"},
+			{ContractAddress: ontologyTransaction.ONGContractAddress, Method: "transfer", From: testAddress1, To: governanceAddress, Amount: "5000000000000000", IsFee: true},
+		},
+	}
+	result = &ExtractResult{extractData: make(map[string]*openwallet.TxExtractData)}
+	bs.extractTransaction(success, result, scanTargetFunc)
+
+	tx := result.extractData["account"].Transaction
+	if tx.Status != openwallet.TxStatusSuccess || tx.Fees != "5000000000000000" {
+		t.Errorf("unexpected transaction: %+v", tx)
+	}
+}
+
+func TestONTBlockScanner_ExtractAggregatedTransaction(t *testing.T) {
+	bs := NewONTBlockScanner(NewWalletManager())
+	scanTargetFunc := func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
+		switch target.ScanTarget {
+		case testAddress1:
+			return openwallet.ScanTargetResult{SourceKey: "sender", Exist: true}
+		case "receiver":
+			return openwallet.ScanTargetResult{SourceKey: "receiver", Exist: true}
+		}
+		return openwallet.ScanTargetResult{}
+	}
+
+	trx := &Transaction{
+		TxID:        "tx1",
+		Payer:       testAddress1,
+		BlockHeight: 10,
+		Notifys: []Notify{
+			{ContractAddress: ontologyTransaction.ONTContractAddress, Method: "transfer", From: testAddress1, To: "receiver", Amount: "300"},
+			{ContractAddress: ontologyTransaction.ONTContractAddress, Method: "transfer", From: testAddress1, To: "other", Amount: "200"},
+			{ContractAddress: ontologyTransaction.ONTContractAddress, Method: "transfer", From: testAddress1, To: "receiver", Amount: "100"},
+			{ContractAddress: ontologyTransaction.ONGContractAddress, Method: "transfer", From: "receiver", To: testAddress1, Amount: "50"},
+			{ContractAddress: ontologyTransaction.ONGContractAddress, Method: "transfer", From: testAddress1, To: governanceAddress, Amount: "10", IsFee: true},
+		},
+	}
+	result := &ExtractResult{extractData: make(map[string]*openwallet.TxExtractData)}
+	bs.extractTransaction(trx, result, scanTargetFunc)
+
+	if len(result.extractData) != 2 {
+		t.Fatalf("unexpected extract data: %+v", result.extractData)
+	}
+
+	sender := result.extractData["sender"]
+	if len(sender.TxInputs) != 4 || len(sender.TxOutputs) != 1 {
+		t.Fatalf("unexpected sender data: %d inputs, %d outputs", len(sender.TxInputs), len(sender.TxOutputs))
+	}
+	tx := sender.Transaction
+	if tx.TxID != "tx1" || tx.Coin.Contract.Token != "ONT" || tx.Amount != "600" || tx.Received || tx.Fees != "10" {
+		t.Errorf("unexpected sender transaction: %+v", tx)
+	}
+	wantFrom := []string{testAddress1 + ":600"}
+	wantTo := []string{"receiver:400", "other:200"}
+	if fmt.Sprint(tx.From) != fmt.Sprint(wantFrom) || fmt.Sprint(tx.To) != fmt.Sprint(wantTo) {
+		t.Errorf("unexpected parties: %v -> %v", tx.From, tx.To)
+	}
+	assets := struct {
+		Amounts []txAssetAmount `json:"amounts"`
+	}{}
+	json.Unmarshal([]byte(tx.ExtParam), &assets)
+	if len(assets.Amounts) != 2 || assets.Amounts[0].Amount != "-600" || assets.Amounts[1].Token != "ONG" || assets.Amounts[1].Amount != "50" {
+		t.Errorf("unexpected asset amounts: %s", tx.ExtParam)
+	}
+
+	receiver := result.extractData["receiver"].Transaction
+	if receiver.Coin.Contract.Token != "ONT" || receiver.Amount != "400" || !receiver.Received || fmt.Sprint(receiver.To) != fmt.Sprint(wantTo) {
+		t.Errorf("unexpected receiver transaction: %+v", receiver)
+	}
+}
+
+func TestONTBlockScanner_ExtractCachedBlockEvents(t *testing.T) {
+	node := newTestNode(func(method string, params []interface{}) interface{} {
+		switch method {
+		case "getblock":
+			return map[string]interface{}{
+				"Hash":         "hash10",
+				"Header":       map[string]interface{}{"Height": 10},
+				"Transactions": []interface{}{map[string]interface{}{"TxType": 209, "Hash": "tx1", "Payer": testAddress1, "GasPrice": 2500, "GasLimit": 20000}},
+			}
+		case "getsmartcodeevent":
+			return []interface{}{map[string]interface{}{"TxHash": "tx1", "State": 0, "GasConsumed": 50000000, "Notify": []interface{}{}}}
+		}
+		//合约事件和交易头部都已缓存，不需要逐笔请求交易
+		return testRpcError(42002)
+	})
+	defer node.Close()
+
+	bs := NewONTBlockScanner(newTestWalletManager(node.URL))
+	bs.ScanTargetFuncV2 = func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
+		if target.ScanTargetType == openwallet.ScanTargetTypeAccountAddress && target.ScanTarget == testAddress1 {
+			return openwallet.ScanTargetResult{SourceKey: "account", Exist: true}
+		}
+		return openwallet.ScanTargetResult{}
+	}
+
+	block, err := bs.wm.GetBlock("hash10")
+	if err != nil {
+		t.Fatalf("GetBlock failed unexpected error: %v", err)
+	}
+	if header := block.TxHeaders["tx1"]; header == nil || header.Payer != testAddress1 || header.GasPrice != 2500 {
+		t.Fatalf("unexpected tx headers: %+v", block.TxHeaders)
+	}
+	bs.cacheBlockEvents(10, block.Transactions, block.TxHeaders, nil)
+
+	//执行失败的交易按付款地址生成手续费扣款
+	result := bs.ExtractTransaction(10, "hash10", "tx1", nil)
+	ed := result.extractData["account"]
+	if !result.Success || ed == nil || len(ed.TxInputs) != 1 {
+		t.Fatalf("unexpected extract data: %+v", result.extractData)
+	}
+	if input := ed.TxInputs[0]; input.TxType != 1 || input.Address != testAddress1 || ed.Transaction.Status != openwallet.TxStatusFail {
+		t.Errorf("unexpected fee input: %+v, status: %s", input, ed.Transaction.Status)
+	}
+}
+
+//testChainHash 测试链的区块hash，前缀加补零的高度凑足64个字符
+func testChainHash(prefix string, height uint64) string {
+	return fmt.Sprintf("%s%060d", prefix, height)
+}
+
+//newTestChainNode 模拟只有区块高度和区块头的节点，区块hash以"hash"开头，
+//forkHeight不为0时从该高度开始切换到hash以"fork"开头的分叉链
+func newTestChainNode(blockCount, forkHeight *uint64) *httptest.Server {
+	chainHash := func(height uint64) string {
+		if fork := atomic.LoadUint64(forkHeight); fork > 0 && height >= fork {
+			return testChainHash("fork", height)
+		}
+		return testChainHash("hash", height)
+	}
+	return newTestNode(func(method string, params []interface{}) interface{} {
+		switch method {
+		case "getblockcount":
+			return atomic.LoadUint64(blockCount)
+		case "getblockhash":
+			return chainHash(uint64(params[0].(float64)))
+		case "getblock":
+			hash := params[0].(string)
+			height, _ := strconv.ParseUint(hash[4:], 10, 64)
+			prev := testChainHash("hash", height-1)
+			if strings.HasPrefix(hash, "fork") {
+				prev = chainHash(height - 1)
+			}
+			return map[string]interface{}{
+				"Hash":   hash,
+				"Header": map[string]interface{}{"Height": height, "PrevBlockHash": prev},
+			}
+		}
+		return testRpcError(42002)
+	})
+}
+
+func TestONTBlockScanner_SubscribedPushLag(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ontpush")
+	defer os.RemoveAll(dir)
+
+	blockCount, forkHeight := uint64(12), uint64(0)
+	node := newTestChainNode(&blockCount, &forkHeight)
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.DataDir = dir
+	wm.Config.makeDataDir()
+	bs := wm.Blockscanner
+	bs.SaveLocalNewBlock(10, testChainHash("hash", 10))
+
+	if bs.isPushLagging() {
+		t.Errorf("height 11 is within the push lag of local height 10")
+	}
+	atomic.StoreUint64(&blockCount, 20)
+	if !bs.isPushLagging() {
+		t.Errorf("height 19 should be lagging behind local height 10")
+	}
+}
+
+func TestONTBlockScanner_QueueBlockPush(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ontpush")
+	defer os.RemoveAll(dir)
+
+	blockCount, forkHeight := uint64(11), uint64(0)
+	node := newTestChainNode(&blockCount, &forkHeight)
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.DataDir = dir
+	wm.Config.makeDataDir()
+	bs := wm.Blockscanner
+	bs.SaveLocalNewBlock(10, testChainHash("hash", 10))
+	bs.blockPushes = make(chan *wsBlockTxHashs, maxQueuedBlockPushes)
+
+	//扫描中时推送不能阻塞WebSocket读取，队列满时改为补扫
+	bs.scanMu.Lock()
+	done := make(chan struct{})
+	go func() {
+		for h := uint64(0); h <= maxQueuedBlockPushes; h++ {
+			bs.queueBlockPush(&wsBlockTxHashs{Height: 11 + h, Hash: testChainHash("hash", 11+h)})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("queueBlockPush blocked while scanning")
+	}
+	if atomic.LoadInt32(&bs.catchingUp) != 1 {
+		t.Errorf("catch up should be started when the push queue is full")
+	}
+	bs.scanMu.Unlock()
+
+	for i := 0; i < 100 && atomic.LoadInt32(&bs.catchingUp) != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&bs.catchingUp) != 0 {
+		t.Errorf("catch up should finish after the scanner is released")
+	}
+}
+
+func TestONTBlockScanner_FindForkPoint(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ontfork")
+	defer os.RemoveAll(dir)
+
+	blockCount, forkHeight := uint64(11), uint64(9)
+	node := newTestChainNode(&blockCount, &forkHeight)
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.DataDir = dir
+	wm.Config.makeDataDir()
+	bs := wm.Blockscanner
+
+	//本地记录的是分叉前的链，8没有记录
+	for _, h := range []uint64{6, 7, 9, 10} {
+		bs.SaveLocalBlock(&Block{Hash: testChainHash("hash", h), Height: h})
+	}
+
+	ancestor, orphans, err := bs.findForkPoint(10, testChainHash("hash", 10))
+	if err != nil {
+		t.Fatalf("findForkPoint failed unexpected error: %v", err)
+	}
+	if ancestor.Hash != testChainHash("hash", 7) || len(orphans) != 2 || orphans[0].Hash != testChainHash("hash", 10) || orphans[1].Hash != testChainHash("hash", 9) {
+		t.Errorf("ancestor = %+v, orphans = %+v", ancestor, orphans)
+	}
+
+	//共同祖先超过最大回滚深度
+	wm.Config.MaxReorgDepth = 2
+	if _, _, err := bs.findForkPoint(10, testChainHash("hash", 10)); err == nil {
+		t.Errorf("findForkPoint should fail beyond max reorg depth")
+	}
+}
+
+func TestONTBlockScanner_Reorg(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ontreorg")
+	defer os.RemoveAll(dir)
+
+	blockCount, forkHeight := uint64(11), uint64(0)
+	node := newTestChainNode(&blockCount, &forkHeight)
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.DataDir = dir
+	wm.Config.makeDataDir()
+	bs := wm.Blockscanner
+	bs.Scanning = true
+	bs.SaveLocalNewBlock(5, testChainHash("hash", 5))
+	bs.SaveLocalBlock(&Block{Hash: testChainHash("hash", 5), Height: 5})
+
+	bs.scanBlockTask()
+	if height, hash, _ := bs.GetLocalNewBlock(); height != 10 || hash != testChainHash("hash", 10) {
+		t.Fatalf("local block = %d %s, want 10 hash10", height, hash)
+	}
+
+	//节点从高度8开始切换到更长的分叉链，回滚后扫描到分叉链的最新高度
+	atomic.StoreUint64(&forkHeight, 8)
+	atomic.StoreUint64(&blockCount, 13)
+	bs.scanBlockTask()
+	if height, hash, _ := bs.GetLocalNewBlock(); height != 12 || hash != testChainHash("fork", 12) {
+		t.Fatalf("local block = %d %s, want 12 fork12", height, hash)
+	}
+	for h := uint64(8); h <= 12; h++ {
+		if block, err := bs.GetLocalBlock(uint32(h)); err != nil || block.Hash != testChainHash("fork", h) {
+			t.Errorf("local block %d = %+v, %v, want fork%d", h, block, err, h)
+		}
+	}
+}
@@ -16,48 +16,60 @@
 package ontology
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
-	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/blocktree/go-owcdrivers/ontologyTransaction"
-	"github.com/blocktree/openwallet/v2/common"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
-	"github.com/graarh/golang-socketio"
-	"github.com/graarh/golang-socketio/transport"
 	"github.com/shopspring/decimal"
 )
 
 const (
-	maxExtractingSize    = 20 //并发的扫描线程数
-	RPCServerRest        = 0  //RPC服务，Restful 测试 API
-	RPCServerMainnetNode = 1  // RPC服务，主网节点 API
+	maxExtractingSize    = 20    //并发的扫描线程数
+	maxCachedEventsSize  = 10000 //缓存合约事件的最大交易数
+	DefaultMaxReorgDepth = 100   //默认最大回滚区块数
+	maxPushLagBlocks     = 3     //WebSocket连接正常时允许推送落后的区块数，超过时轮询补扫
+	maxQueuedBlockPushes = 16    //等待扫描的推送区块数，队列满时改为补扫
+	RPCServerRest        = 0     //RPC服务，Restful 测试 API
+	RPCServerMainnetNode = 1     // RPC服务，主网节点 API
 )
 
 //ONTBlockScanner ontology的区块链扫描器
 type ONTBlockScanner struct {
 	*openwallet.BlockScannerBase
 
-	CurrentBlockHeight   uint64             //当前区块高度
-	extractingCH         chan struct{}      //扫描工作令牌
-	wm                   *WalletManager     //钱包管理者
-	IsScanMemPool        bool               //是否扫描交易池
-	RescanLastBlockCount uint64             //重扫上N个区块数量
-	socketIO             *gosocketio.Client //socketIO客户端
+	CurrentBlockHeight   uint64                     //当前区块高度
+	extractingCH         chan struct{}              //扫描工作令牌
+	wm                   *WalletManager             //钱包管理者
+	IsScanMemPool        bool                       //是否扫描交易池
+	RescanLastBlockCount uint64                     //重扫上N个区块数量
+	ws                   *WebSocketClient           //WebSocket客户端
+	txEvents             map[string]*smartCodeEvent //按区块获取或推送的合约事件
+	txHeaders            map[string]*Transaction    //区块内交易的付款地址和gas，与合约事件一起组成交易
+	eventsMu             sync.Mutex                 //合约事件缓存锁
+	scanMu               sync.Mutex                 //轮询和推送不能同时扫描
+	daiMu                sync.Mutex                 //本地数据初始化锁
+	txIndex              *addressTxIndex            //本地地址交易索引
+	catchingUp           int32                      //推送跳块时是否已有补扫任务
+	blockPushes          chan *wsBlockTxHashs       //等待扫描的推送区块，不阻塞WebSocket读取
 	RPCServer            int
 }
 
 //ExtractResult 扫描完成的提取结果
 type ExtractResult struct {
-	extractData map[string]*openwallet.TxExtractData
-	TxID        string
-	BlockHeight uint64
-	Success     bool
+	extractData  map[string]*openwallet.TxExtractData
+	contractData map[string][]*openwallet.SmartContractReceipt //按ABI解析的合约回执
+	TxID         string
+	BlockHeight  uint64
+	Success      bool
 }
 
 //SaveResult 保存结果
@@ -78,6 +90,8 @@ func NewONTBlockScanner(wm *WalletManager) *ONTBlockScanner {
 	bs.IsScanMemPool = false
 	bs.RescanLastBlockCount = 0
 	bs.RPCServer = RPCServerRest
+	bs.txEvents = make(map[string]*smartCodeEvent)
+	bs.txHeaders = make(map[string]*Transaction)
 
 	//设置扫描任务
 	bs.SetTask(bs.ScanBlockTask)
@@ -105,6 +119,44 @@ func (bs *ONTBlockScanner) SetRescanBlockHeight(height uint64) error {
 //ScanBlockTask 扫描任务
 func (bs *ONTBlockScanner) ScanBlockTask() {
 
+	bs.scanMu.Lock()
+	defer bs.scanMu.Unlock()
+
+	//WebSocket连接正常时由推送驱动扫描，断开时轮询
+	if bs.isSubscribed() {
+		bs.RescanFailedRecord()
+
+		//连接正常但推送停止时，落后过多也需要轮询补扫
+		if !bs.isPushLagging() {
+			return
+		}
+	}
+
+	bs.scanBlockTask()
+}
+
+//isPushLagging 本地扫描高度是否落后节点超过允许的推送延迟
+func (bs *ONTBlockScanner) isPushLagging() bool {
+	header, err := bs.GetScannedBlockHeader()
+	if err != nil {
+		log.Std.Info("block scanner can not get new block height; unexpected error: %v", err)
+		return false
+	}
+	maxHeight, err := bs.wm.GetBlockHeight()
+	if err != nil {
+		log.Std.Info("block scanner can not get rpc-server block height; unexpected error: %v", err)
+		return false
+	}
+	if maxHeight-1 <= header.Height+maxPushLagBlocks {
+		return false
+	}
+	log.Std.Info("block scanner websocket push is lagging, local height: %d, node height: %d", header.Height, maxHeight-1)
+	return true
+}
+
+//scanBlockTask 轮询扫描到最新高度
+func (bs *ONTBlockScanner) scanBlockTask() {
+
 	//获取本地区块高度
 	blockHeader, err := bs.GetScannedBlockHeader()
 	if err != nil {
@@ -114,6 +166,9 @@ func (bs *ONTBlockScanner) ScanBlockTask() {
 	currentHeight := blockHeader.Height
 	currentHash := blockHeader.Hash
 
+	//并发预取后续区块，按高度顺序提交
+	prefetcher := newBlockPrefetcher(bs.wm.Config.BlockPrefetchSize, bs.prefetchBlock)
+
 	for {
 
 		if !bs.Scanning {
@@ -141,14 +196,16 @@ func (bs *ONTBlockScanner) ScanBlockTask() {
 
 		log.Std.Info("block scanner scanning height: %d ...", currentHeight)
 
-		hash, err := bs.wm.GetBlockHash(currentHeight)
+		prefetched := prefetcher.get(currentHeight, maxHeight)
+
+		hash, err := prefetched.hash, prefetched.hashErr
 		if err != nil {
 			//下一个高度找不到会报异常
 			log.Std.Info("block scanner can not get new block hash; unexpected error: %v", err)
 			break
 		}
 
-		block, err := bs.wm.GetBlock(hash)
+		block, err := prefetched.block, prefetched.blockErr
 		if err != nil {
 			log.Std.Info("block scanner can not get new block data; unexpected error: %v", err)
 
@@ -168,60 +225,45 @@ func (bs *ONTBlockScanner) ScanBlockTask() {
 			log.Std.Info("block height: %d local hash = %s ", currentHeight-1, currentHash)
 			log.Std.Info("block height: %d mainnet hash = %s ", currentHeight-1, block.PrevBlockHash)
 
-			log.Std.Info("delete recharge records on block height: %d.", currentHeight-1)
-
-			//查询本地分叉的区块
-			forkBlock, _ := bs.GetLocalBlock(uint32(currentHeight - 1))
-
-			//删除上一区块链的所有充值记录
-			//bs.DeleteRechargesByHeight(currentHeight - 1)
-			//删除上一区块链的未扫记录
-			bs.wm.Blockscanner.DeleteUnscanRecord(uint32(currentHeight - 1))
-			currentHeight = currentHeight - 2 //倒退2个区块重新扫描
-			if currentHeight <= 0 {
-				currentHeight = 1
+			//向前查找与节点一致的共同祖先
+			ancestor, orphans, err := bs.findForkPoint(currentHeight-1, currentHash)
+			if err != nil {
+				log.Std.Error("block scanner can not find fork point; unexpected error: %v", err)
+				break
 			}
 
-			localBlock, err := bs.GetLocalBlock(uint32(currentHeight))
-			if err != nil {
-				log.Std.Error("block scanner can not get local block; unexpected error: %v", err)
+			isFork = true
 
-				//查找core钱包的RPC
-				log.Info("block scanner prev block height:", currentHeight)
+			//预取的区块可能属于分叉前的链
+			prefetcher.reset()
 
-				prevHash, err := bs.wm.GetBlockHash(currentHeight)
-				if err != nil {
-					log.Std.Error("block scanner can not get prev block; unexpected error: %v", err)
-					break
-				}
+			//通知分叉区块给观测者，从高到低依次回滚
+			for _, orphan := range orphans {
+				log.Std.Info("delete recharge records on block height: %d.", orphan.Height)
 
-				localBlock, err = bs.wm.GetBlock(prevHash)
-				if err != nil {
-					log.Std.Error("block scanner can not get prev block; unexpected error: %v", err)
-					break
-				}
+				//删除分叉区块的未扫记录
+				bs.wm.Blockscanner.DeleteUnscanRecord(uint32(orphan.Height))
 
+				bs.newBlockNotify(orphan, isFork)
 			}
 
-			//重置当前区块的hash
-			currentHash = localBlock.Hash
+			//删除分叉区块的地址交易记录
+			if err := bs.deleteAddressTxRecords(ancestor.Height + 1); err != nil {
+				log.Std.Error("block scanner can not delete address tx records from height: %d; unexpected error: %v", ancestor.Height+1, err)
+			}
+
+			//从共同祖先重新扫描
+			currentHeight = ancestor.Height
+			currentHash = ancestor.Hash
 
 			log.Std.Info("rescan block on height: %d, hash: %s .", currentHeight, currentHash)
 
 			//重新记录一个新扫描起点
-			bs.wm.Blockscanner.SaveLocalNewBlock(localBlock.Height, localBlock.Hash)
-
-			isFork = true
-
-			if forkBlock != nil {
-
-				//通知分叉区块给观测者，异步处理
-				bs.newBlockNotify(forkBlock, isFork)
-			}
+			bs.wm.Blockscanner.SaveLocalNewBlock(currentHeight, currentHash)
 
 		} else {
 
-			err = bs.BatchExtractTransaction(block.Height, block.Hash, block.Transactions)
+			err = bs.batchExtractTransaction(block.Height, block.Hash, block.Transactions, block.TxHeaders, prefetched.events)
 			if err != nil {
 				log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
 			}
@@ -256,6 +298,45 @@ func (bs *ONTBlockScanner) ScanBlockTask() {
 
 }
 
+//findForkPoint 从分叉高度向前对比本地区块和节点区块的hash，返回共同祖先和被孤立的本地区块，孤立区块按高度从高到低排列
+//本地缺少记录的区块无法对比，继续向前查找且不作为孤立区块通知，回滚超过MaxReorgDepth个区块时返回错误，不再继续扫描
+func (bs *ONTBlockScanner) findForkPoint(height uint64, localHash string) (*Block, []*Block, error) {
+
+	orphans := make([]*Block, 0)
+
+	for h := height; ; h-- {
+
+		hash, err := bs.wm.GetBlockHash(h)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		local, err := bs.GetLocalBlock(uint32(h))
+		if err != nil && h == height {
+			//分叉高度就是本地当前高度，使用已记录的hash
+			local, err = &Block{Hash: localHash, Height: h}, nil
+		}
+
+		if err != nil {
+			log.Std.Warning("block scanner can not get local block: %d, keep looking for common ancestor; unexpected error: %v", h, err)
+		} else if local.Hash == hash {
+			return local, orphans, nil
+		}
+
+		if height-h >= bs.wm.Config.MaxReorgDepth {
+			return nil, nil, fmt.Errorf("block fork at height: %d is deeper than max reorg depth: %d", height, bs.wm.Config.MaxReorgDepth)
+		}
+
+		if err == nil {
+			orphans = append(orphans, local)
+		}
+
+		if h == 0 {
+			return nil, nil, fmt.Errorf("block fork at height: %d can not find common ancestor", height)
+		}
+	}
+}
+
 //ScanBlock 扫描指定高度区块
 func (bs *ONTBlockScanner) ScanBlock(height uint64) error {
 
@@ -287,7 +368,7 @@ func (bs *ONTBlockScanner) scanBlock(height uint64) (*Block, error) {
 	}
 	log.Std.Info("block scanner scanning height: %d ...", block.Height)
 
-	err = bs.BatchExtractTransaction(block.Height, block.Hash, block.Transactions)
+	err = bs.batchExtractTransaction(block.Height, block.Hash, block.Transactions, block.TxHeaders, nil)
 
 	if err != nil {
 		log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
@@ -350,7 +431,7 @@ func (bs *ONTBlockScanner) RescanFailedRecord() {
 
 		if len(txs) == 0 {
 
-			hash, err := bs.wm.GetBlockHash(height)
+			hash, err = bs.wm.GetBlockHash(height)
 			if err != nil {
 				//下一个高度找不到会报异常
 				log.Std.Info("block scanner can not get new block hash; unexpected error: %v", err)
@@ -390,6 +471,12 @@ func (bs *ONTBlockScanner) newBlockNotify(block *Block, isFork bool) {
 //BatchExtractTransaction 批量提取交易单
 //bitcoin 1M的区块链可以容纳3000笔交易，批量多线程处理，速度更快
 func (bs *ONTBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash string, txs []string) error {
+	return bs.batchExtractTransaction(blockHeight, blockHash, txs, nil, nil)
+}
+
+//batchExtractTransaction 批量提取交易单，headers为区块内交易的头部信息，为空时按区块获取，
+//events为已获取的区块合约事件，为空时按区块获取
+func (bs *ONTBlockScanner) batchExtractTransaction(blockHeight uint64, blockHash string, txs []string, headers map[string]*Transaction, events map[string]*smartCodeEvent) error {
 
 	var (
 		quit       = make(chan struct{})
@@ -402,6 +489,21 @@ func (bs *ONTBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash
 		return nil
 	}
 
+	//区块内的交易一次获取全部合约事件
+	if blockHeight > 0 {
+		if len(blockHash) == 0 {
+			blockHash, _ = bs.wm.GetBlockHash(blockHeight)
+		}
+		if len(blockHash) > 0 && headers == nil {
+			if block, err := bs.wm.GetBlock(blockHash); err == nil {
+				headers = block.TxHeaders
+			}
+		}
+		if headers != nil {
+			bs.cacheBlockEvents(blockHeight, txs, headers, events)
+		}
+	}
+
 	//生产通道
 	producer := make(chan ExtractResult)
 	defer close(producer)
@@ -418,6 +520,7 @@ func (bs *ONTBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash
 			if gets.Success {
 
 				notifyErr := bs.newExtractDataNotify(height, gets.extractData)
+				bs.newContractReceiptNotify(height, gets.contractData)
 				//saveErr := bs.SaveRechargeToWalletDB(height, gets.Recharges)
 				if notifyErr != nil {
 					failed++ //标记保存失败数
@@ -528,7 +631,7 @@ func (bs *ONTBlockScanner) ExtractTransaction(blockHeight uint64, blockHash stri
 	if txid == "6ff104dd5249a736b99046f0dc1844c3cb9ba090447a075cfafe235cad1ce201" {
 		fmt.Println("break here")
 	}
-	trx, err := bs.wm.GetTransaction(txid)
+	trx, err := bs.getTransaction(blockHeight, blockHash, txid)
 
 	if err != nil {
 		log.Std.Info("block scanner can not extract transaction data; unexpected error: %v", err)
@@ -546,6 +649,95 @@ func (bs *ONTBlockScanner) ExtractTransaction(blockHeight uint64, blockHash stri
 
 }
 
+//getTransaction 获取交易单，已缓存交易头部和合约事件时不再请求节点
+func (bs *ONTBlockScanner) getTransaction(blockHeight uint64, blockHash string, txid string) (*Transaction, error) {
+	if blockHeight > 0 && len(blockHash) > 0 {
+		if trx, ok := bs.popCachedTransaction(txid); ok {
+			trx.BlockHeight = blockHeight
+			trx.BlockHash = blockHash
+			return trx, nil
+		}
+	}
+	return bs.wm.GetTransaction(txid)
+}
+
+//cacheBlockEvents 缓存区块内需要提取的交易的头部和合约事件，events为空时一次获取区块内所有交易的合约事件，失败时逐笔请求
+func (bs *ONTBlockScanner) cacheBlockEvents(blockHeight uint64, txs []string, headers map[string]*Transaction, events map[string]*smartCodeEvent) {
+	cachedHeaders := make(map[string]*Transaction, len(txs))
+	for _, txid := range txs {
+		if header, ok := headers[txid]; ok {
+			cachedHeaders[txid] = header
+		}
+	}
+	bs.cacheTxHeaders(cachedHeaders)
+
+	if events == nil {
+		var err error
+		events, err = bs.wm.RPCClient.getBlockEvents(blockHeight)
+		if err != nil {
+			log.Std.Info("block scanner can not get events of block: %d; unexpected error: %v", blockHeight, err)
+			return
+		}
+	}
+
+	//区块事件中没有的交易仍逐笔请求
+	cached := make(map[string]*smartCodeEvent, len(txs))
+	for _, txid := range txs {
+		if event, ok := events[txid]; ok {
+			cached[txid] = event
+		}
+	}
+	bs.cacheTxEvents(cached)
+}
+
+//cacheTxEvents 缓存交易的合约事件
+func (bs *ONTBlockScanner) cacheTxEvents(events map[string]*smartCodeEvent) {
+	bs.eventsMu.Lock()
+	defer bs.eventsMu.Unlock()
+
+	//区块推送丢失时缓存不会被取走，超过上限直接清空
+	if len(bs.txEvents)+len(events) > maxCachedEventsSize {
+		bs.txEvents = make(map[string]*smartCodeEvent)
+	}
+	for txid, event := range events {
+		bs.txEvents[txid] = event
+	}
+}
+
+//cacheTxHeaders 缓存区块内交易的头部信息
+func (bs *ONTBlockScanner) cacheTxHeaders(headers map[string]*Transaction) {
+	bs.eventsMu.Lock()
+	defer bs.eventsMu.Unlock()
+
+	if len(bs.txHeaders)+len(headers) > maxCachedEventsSize {
+		bs.txHeaders = make(map[string]*Transaction)
+	}
+	for txid, header := range headers {
+		bs.txHeaders[txid] = header
+	}
+}
+
+//popCachedTransaction 用缓存的交易头部和合约事件组成交易，缺少任一项时需要向节点请求
+func (bs *ONTBlockScanner) popCachedTransaction(txid string) (*Transaction, bool) {
+	bs.eventsMu.Lock()
+	defer bs.eventsMu.Unlock()
+
+	header, ok := bs.txHeaders[txid]
+	if !ok {
+		return nil, false
+	}
+	event, ok := bs.txEvents[txid]
+	if !ok {
+		return nil, false
+	}
+	delete(bs.txHeaders, txid)
+	delete(bs.txEvents, txid)
+
+	trx := *header
+	trx.setEvent(event)
+	return &trx, true
+}
+
 // 从最小单位的 amount 转为带小数点的表示
 func convertToAmount(amount uint64, offset int) string {
 	amountStr := fmt.Sprintf("%d", amount)
@@ -568,7 +760,7 @@ func convertFromAmount(amountStr string) uint64 {
 	return uint64(r)
 }
 
-//ExtractTransactionData 提取交易单
+//ExtractTransactionData 提取交易单，每个数据源汇总为一条交易记录
 func (bs *ONTBlockScanner) extractTransaction(trx *Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) {
 	var (
 		success = true
@@ -579,295 +771,304 @@ func (bs *ONTBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 		success = false
 	} else {
 
-		if success && len(trx.Notifys) != 0 {
-
-			for _, notify := range trx.Notifys {
-				if notify.Method != "transfer" {
-					continue
-				}
-				targetResult := scanAddressFunc(openwallet.ScanTargetParam{
-					ScanTarget:     notify.From,
-					Symbol:         bs.wm.Symbol(),
-					ScanTargetType: openwallet.ScanTargetTypeAccountAddress,
-				})
-
-				if targetResult.Exist {
-					input := openwallet.TxInput{}
-					input.TxID = trx.TxID
-					input.Address = notify.From
-					input.Symbol = bs.wm.Symbol()
-					input.Amount = notify.Amount
-					if notify.IsFee {
-						input.TxType = 1
-					}
-
-					if notify.ContractAddress == ontologyTransaction.ONTContractAddress {
-						input.Coin = openwallet.Coin{
-							Symbol:     bs.wm.Symbol(),
-							IsContract: true,
-							ContractID: openwallet.GenContractID(bs.wm.Symbol(), ontologyTransaction.ONTContractAddress),
-							Contract: openwallet.SmartContract{
-								ContractID: openwallet.GenContractID(bs.wm.Symbol(), ontologyTransaction.ONTContractAddress),
-								Symbol:     bs.wm.Symbol(),
-								Address:    ontologyTransaction.ONTContractAddress,
-								Token:      "ONT",
-								Name:       bs.wm.FullName(),
-								Decimals:   0,
-							},
-						}
-					} else if notify.ContractAddress == ontologyTransaction.ONGContractAddress {
-						input.Coin = openwallet.Coin{
-							Symbol:     bs.wm.Symbol(),
-							IsContract: true,
-							ContractID: openwallet.GenContractID(bs.wm.Symbol(), ontologyTransaction.ONGContractAddress),
-							Contract: openwallet.SmartContract{
-								ContractID: openwallet.GenContractID(bs.wm.Symbol(), ontologyTransaction.ONGContractAddress),
-								Symbol:     bs.wm.Symbol(),
-								Address:    ontologyTransaction.ONGContractAddress,
-								Token:      "ONG",
-								Name:       bs.wm.FullName(),
-								Decimals:   9,
-							},
-						}
-					} else {
-						//
-					}
-					input.Index = 0
-					input.Sid = openwallet.GenTxInputSID(trx.TxID, input.Coin.Symbol, input.Coin.Contract.Address, 0)
-					input.CreateAt = createAt
-					input.BlockHeight = trx.BlockHeight
-					input.BlockHash = trx.BlockHash
-
-					ed := result.extractData[targetResult.SourceKey]
-					if ed == nil {
-						ed = openwallet.NewBlockExtractData()
-						result.extractData[targetResult.SourceKey] = ed
-					}
-
-					ed.TxInputs = append(ed.TxInputs, &input)
-
-					targetResult1 := scanAddressFunc(openwallet.ScanTargetParam{
-						ScanTarget:     notify.To,
-						Symbol:         bs.wm.Symbol(),
-						ScanTargetType: openwallet.ScanTargetTypeAccountAddress,
-					})
-
-					if targetResult.Exist && targetResult.SourceKey == targetResult1.SourceKey {
-
-						output := openwallet.TxOutPut{}
-						output.Received = true
-						output.TxID = trx.TxID
-						output.Address = notify.To
-						output.Symbol = bs.wm.Symbol()
-						output.Amount = notify.Amount
-						if notify.ContractAddress == ontologyTransaction.ONTContractAddress {
-							output.Coin = openwallet.Coin{
-								Symbol:     bs.wm.Symbol(),
-								IsContract: true,
-								ContractID: openwallet.GenContractID(bs.wm.Symbol(), ontologyTransaction.ONTContractAddress),
-								Contract: openwallet.SmartContract{
-									ContractID: openwallet.GenContractID(bs.wm.Symbol(), ontologyTransaction.ONTContractAddress),
-									Symbol:     bs.wm.Symbol(),
-									Address:    ontologyTransaction.ONTContractAddress,
-									Token:      "ONT",
-									Name:       bs.wm.FullName(),
-									Decimals:   0,
-								},
-							}
-						} else if notify.ContractAddress == ontologyTransaction.ONGContractAddress {
-							output.Coin = openwallet.Coin{
-								Symbol:     bs.wm.Symbol(),
-								IsContract: true,
-								ContractID: openwallet.GenContractID(bs.wm.Symbol(), ontologyTransaction.ONGContractAddress),
-								Contract: openwallet.SmartContract{
-									ContractID: openwallet.GenContractID(bs.wm.Symbol(), ontologyTransaction.ONGContractAddress),
-									Symbol:     bs.wm.Symbol(),
-									Address:    ontologyTransaction.ONGContractAddress,
-									Token:      "ONG",
-									Name:       bs.wm.FullName(),
-									Decimals:   9,
-								},
-							}
-						} else {
-							//
-						}
-						output.Index = 0
-						output.Sid = openwallet.GenTxOutPutSID(trx.TxID, output.Coin.Symbol, output.Coin.Contract.Address, 0)
-						output.CreateAt = createAt
-						output.BlockHeight = trx.BlockHeight
-						output.BlockHash = trx.BlockHash
-
-						ed := result.extractData[targetResult.SourceKey]
-
-						if ed == nil {
-							ed = openwallet.NewBlockExtractData()
-							result.extractData[targetResult.SourceKey] = ed
-						}
-
-						ed.TxOutputs = append(ed.TxOutputs, &output)
-						continue
-					} else {
-						output := openwallet.TxOutPut{}
-						output.Address = notify.To
-						ed.TxOutputs = append(ed.TxOutputs, &output)
-					}
+		notifys := txNotifys(trx)
+		transfers := make([]*txTransfer, 0, len(notifys))
 
+		for i, notify := range notifys {
+			if notify.Method != "transfer" {
+				continue
+			}
+			coin, watched := bs.notifyCoin(notify.ContractAddress, scanAddressFunc)
+			if !watched {
+				continue
+			}
+			transfers = append(transfers, &txTransfer{Notify: notify, Coin: coin})
+
+			targetResult := scanAddressFunc(openwallet.ScanTargetParam{
+				ScanTarget:     notify.From,
+				Symbol:         bs.wm.Symbol(),
+				ScanTargetType: openwallet.ScanTargetTypeAccountAddress,
+			})
+			if targetResult.Exist {
+				input := openwallet.TxInput{}
+				input.TxID = trx.TxID
+				input.Address = notify.From
+				input.Symbol = bs.wm.Symbol()
+				input.Amount = notify.Amount
+				if notify.IsFee {
+					input.TxType = 1
 				}
+				input.Coin = coin
+				input.Index = uint64(i)
+				input.Sid = openwallet.GenTxInputSID(trx.TxID, input.Coin.Symbol, input.Coin.Contract.Address, uint64(i))
+				input.CreateAt = createAt
+				input.BlockHeight = trx.BlockHeight
+				input.BlockHash = trx.BlockHash
+
+				ed := result.txExtractData(targetResult.SourceKey)
+				ed.TxInputs = append(ed.TxInputs, &input)
+			}
 
-				targetResult = scanAddressFunc(openwallet.ScanTargetParam{
-					ScanTarget:     notify.To,
-					Symbol:         bs.wm.Symbol(),
-					ScanTargetType: openwallet.ScanTargetTypeAccountAddress,
-				})
-
-				if targetResult.Exist {
-					output := openwallet.TxOutPut{}
-					output.Received = true
-					output.TxID = trx.TxID
-					output.Address = notify.To
-					output.Symbol = bs.wm.Symbol()
-					output.Amount = notify.Amount
-					if notify.ContractAddress == ontologyTransaction.ONTContractAddress {
-						output.Coin = openwallet.Coin{
-							Symbol:     bs.wm.Symbol(),
-							IsContract: true,
-							ContractID: openwallet.GenContractID(bs.wm.Symbol(), ontologyTransaction.ONTContractAddress),
-							Contract: openwallet.SmartContract{
-								ContractID: openwallet.GenContractID(bs.wm.Symbol(), ontologyTransaction.ONTContractAddress),
-								Symbol:     bs.wm.Symbol(),
-								Address:    ontologyTransaction.ONTContractAddress,
-								Token:      "ONT",
-								Name:       bs.wm.FullName(),
-								Decimals:   0,
-							},
-						}
-					} else if notify.ContractAddress == ontologyTransaction.ONGContractAddress {
-						output.Coin = openwallet.Coin{
-							Symbol:     bs.wm.Symbol(),
-							IsContract: true,
-							ContractID: openwallet.GenContractID(bs.wm.Symbol(), ontologyTransaction.ONGContractAddress),
-							Contract: openwallet.SmartContract{
-								ContractID: openwallet.GenContractID(bs.wm.Symbol(), ontologyTransaction.ONGContractAddress),
-								Symbol:     bs.wm.Symbol(),
-								Address:    ontologyTransaction.ONGContractAddress,
-								Token:      "ONG",
-								Name:       bs.wm.FullName(),
-								Decimals:   9,
-							},
-						}
-					} else {
-						//
-					}
-					output.Index = 0
-					output.Sid = openwallet.GenTxOutPutSID(trx.TxID, output.Coin.Symbol, output.Coin.Contract.Address, 0)
-					output.CreateAt = createAt
-					output.BlockHeight = trx.BlockHeight
-					output.BlockHash = trx.BlockHash
-
-					ed := result.extractData[targetResult.SourceKey]
-
-					if ed == nil {
-						ed = openwallet.NewBlockExtractData()
-						result.extractData[targetResult.SourceKey] = ed
-					}
-
-					ed.TxOutputs = append(ed.TxOutputs, &output)
-					input := openwallet.TxInput{}
-					input.Address = notify.From
-					ed.TxInputs = append(ed.TxInputs, &input)
-				}
+			targetResult = scanAddressFunc(openwallet.ScanTargetParam{
+				ScanTarget:     notify.To,
+				Symbol:         bs.wm.Symbol(),
+				ScanTargetType: openwallet.ScanTargetTypeAccountAddress,
+			})
+			if targetResult.Exist {
+				output := openwallet.TxOutPut{}
+				output.Received = true
+				output.TxID = trx.TxID
+				output.Address = notify.To
+				output.Symbol = bs.wm.Symbol()
+				output.Amount = notify.Amount
+				output.Coin = coin
+				output.Index = uint64(i)
+				output.Sid = openwallet.GenTxOutPutSID(trx.TxID, output.Coin.Symbol, output.Coin.Contract.Address, uint64(i))
+				output.CreateAt = createAt
+				output.BlockHeight = trx.BlockHeight
+				output.BlockHash = trx.BlockHash
+
+				ed := result.txExtractData(targetResult.SourceKey)
+				ed.TxOutputs = append(ed.TxOutputs, &output)
 			}
+		}
 
+		//交易的执行状态和实际手续费
+		status := openwallet.TxStatusSuccess
+		if trx.Failed {
+			status = openwallet.TxStatusFail
+		}
+		fees := txFees(trx)
+		for _, ed := range result.extractData {
+			ed.Transaction = newExtractTransaction(trx, transfers, ed, status, fees)
 		}
 
+		bs.extractContractReceipts(trx, result, scanAddressFunc)
+
 		success = true
 
 	}
 	result.Success = success
 }
 
-func modifyExtractData(data *openwallet.TxExtractData) []*openwallet.TxExtractData {
-	var eds []*openwallet.TxExtractData
-	for index := 0; index < len(data.TxInputs); index++ {
-		ed := &openwallet.TxExtractData{}
-		if data.TxInputs[index].Symbol == "" {
-			ed.TxOutputs = append(ed.TxOutputs, data.TxOutputs[index])
-			tx := &openwallet.Transaction{
-				From:        []string{data.TxInputs[index].Address + ":" + data.TxOutputs[index].Amount},
-				To:          []string{data.TxOutputs[index].Address + ":" + data.TxOutputs[index].Amount},
-				Amount:      data.TxOutputs[index].Amount,
-				Fees:        "0",
-				Coin:        data.TxOutputs[index].Coin,
-				BlockHash:   data.TxOutputs[index].BlockHash,
-				BlockHeight: data.TxOutputs[index].BlockHeight,
-				TxID:        data.TxOutputs[index].TxID,
-				Decimal:     0,
-				Status:      "1",
-			}
-			tx.WxID = openwallet.GenTransactionWxID(tx)
-			ed.Transaction = tx
-		} else if data.TxOutputs[index].Symbol == "" {
-			ed.TxInputs = append(ed.TxInputs, data.TxInputs[index])
-			tx := &openwallet.Transaction{
-				From:        []string{data.TxInputs[index].Address + ":" + data.TxInputs[index].Amount},
-				To:          []string{data.TxOutputs[index].Address + ":" + data.TxInputs[index].Amount},
-				Amount:      data.TxInputs[index].Amount,
-				Fees:        "0",
-				Coin:        data.TxInputs[index].Coin,
-				BlockHash:   data.TxInputs[index].BlockHash,
-				BlockHeight: data.TxInputs[index].BlockHeight,
-				TxID:        data.TxInputs[index].TxID,
-				Decimal:     0,
-				Status:      "1",
-				TxType:      data.TxInputs[index].TxType,
-			}
-			tx.WxID = openwallet.GenTransactionWxID(tx)
-			ed.Transaction = tx
-		} else {
-			ed.TxInputs = append(ed.TxInputs, data.TxInputs[index])
-			ed.TxOutputs = append(ed.TxOutputs, data.TxOutputs[index])
-			tx := &openwallet.Transaction{
-				From:        []string{data.TxInputs[index].Address + ":" + data.TxInputs[index].Amount},
-				To:          []string{data.TxOutputs[index].Address + ":" + data.TxInputs[index].Amount},
-				Amount:      "0",
-				Fees:        "0",
-				Coin:        data.TxInputs[index].Coin,
-				BlockHash:   data.TxInputs[index].BlockHash,
-				BlockHeight: data.TxInputs[index].BlockHeight,
-				TxID:        data.TxInputs[index].TxID,
-				Decimal:     0,
-				Status:      "1",
-				TxType:      data.TxInputs[index].TxType,
+//txExtractData 获取数据源的提取数据，不存在时创建
+func (result *ExtractResult) txExtractData(sourceKey string) *openwallet.TxExtractData {
+	ed := result.extractData[sourceKey]
+	if ed == nil {
+		ed = openwallet.NewBlockExtractData()
+		result.extractData[sourceKey] = ed
+	}
+	return ed
+}
+
+//txTransfer 交易中关注资产的一笔转账
+type txTransfer struct {
+	Notify
+	Coin openwallet.Coin
+}
+
+//txAssetAmount 数据源在一笔交易中某个资产的净额，转入为正，转出为负，不含手续费
+type txAssetAmount struct {
+	Contract string `json:"contract"`
+	Token    string `json:"token"`
+	Amount   string `json:"amount"`
+}
+
+//newExtractTransaction 汇总一笔交易在一个数据源中的转账。
+//Coin为数据源第一笔非手续费转账的资产，From/To为该资产的全部参与方，
+//Amount为该资产的净额，各资产的净额记录在ExtParam，手续费只记录一次
+func newExtractTransaction(trx *Transaction, transfers []*txTransfer, data *openwallet.TxExtractData, status, fees string) *openwallet.Transaction {
+	var (
+		coin    *openwallet.Coin
+		index   uint64
+		feeOnly = true
+		nets    = make(map[string]*big.Int)
+	)
+
+	addNet := func(r *openwallet.Recharge, sign int64) {
+		amount, ok := new(big.Int).SetString(r.Amount, 10)
+		if !ok {
+			return
+		}
+		net := nets[r.Coin.Contract.Address]
+		if net == nil {
+			net = big.NewInt(0)
+			nets[r.Coin.Contract.Address] = net
+		}
+		net.Add(net, amount.Mul(amount, big.NewInt(sign)))
+	}
+	//按转账事件的顺序选择主要资产
+	setCoin := func(r *openwallet.Recharge) {
+		if feeOnly || r.Index < index {
+			coin, index, feeOnly = &r.Coin, r.Index, false
+		}
+	}
+	for _, input := range data.TxInputs {
+		if input.TxType == 1 {
+			if coin == nil {
+				coin = &input.Coin
 			}
-			tx.WxID = openwallet.GenTransactionWxID(tx)
-			ed.Transaction = tx
+			continue
+		}
+		setCoin(&input.Recharge)
+		addNet(&input.Recharge, -1)
+	}
+	for _, output := range data.TxOutputs {
+		setCoin(&output.Recharge)
+		addNet(&output.Recharge, 1)
+	}
+
+	tx := &openwallet.Transaction{
+		TxID:        trx.TxID,
+		Amount:      "0",
+		Fees:        fees,
+		BlockHash:   trx.BlockHash,
+		BlockHeight: trx.BlockHeight,
+		Status:      status,
+	}
+	if coin != nil {
+		tx.Coin = *coin
+	}
+	if feeOnly {
+		tx.TxType = 1
+	}
+
+	//参与方按出现顺序合并同一地址的金额
+	from := newPartyAmounts()
+	to := newPartyAmounts()
+	assets := make([]txAssetAmount, 0, len(nets))
+	for _, transfer := range transfers {
+		if net, ok := nets[transfer.Coin.Contract.Address]; ok {
+			assets = append(assets, txAssetAmount{
+				Contract: transfer.Coin.Contract.Address,
+				Token:    transfer.Coin.Contract.Token,
+				Amount:   net.String(),
+			})
+			delete(nets, transfer.Coin.Contract.Address)
+		}
+		if coin == nil || transfer.Coin.Contract.Address != coin.Contract.Address || transfer.IsFee != feeOnly {
+			continue
+		}
+		from.add(transfer.From, transfer.Amount)
+		to.add(transfer.To, transfer.Amount)
+	}
+	tx.From = from.list()
+	tx.To = to.list()
+
+	for _, asset := range assets {
+		if asset.Contract != tx.Coin.Contract.Address {
+			continue
+		}
+		net, _ := new(big.Int).SetString(asset.Amount, 10)
+		tx.Received = net.Sign() > 0
+		tx.Amount = net.Abs(net).String()
+	}
+	if len(assets) > 0 {
+		extParam, _ := json.Marshal(map[string]interface{}{"amounts": assets})
+		tx.ExtParam = string(extParam)
+	}
+
+	tx.WxID = openwallet.GenTransactionWxID(tx)
+	return tx
+}
+
+//partyAmounts 交易参与方的金额，按地址合并
+type partyAmounts struct {
+	order   []string
+	amounts map[string]*big.Int
+}
+
+func newPartyAmounts() *partyAmounts {
+	return &partyAmounts{amounts: make(map[string]*big.Int)}
+}
+
+func (p *partyAmounts) add(address, amount string) {
+	n, ok := new(big.Int).SetString(amount, 10)
+	if !ok {
+		n = big.NewInt(0)
+	}
+	if exist, ok := p.amounts[address]; ok {
+		exist.Add(exist, n)
+		return
+	}
+	p.amounts[address] = n
+	p.order = append(p.order, address)
+}
+
+//list 转为 address:amount 的列表
+func (p *partyAmounts) list() []string {
+	list := make([]string, 0, len(p.order))
+	for _, address := range p.order {
+		list = append(list, address+":"+p.amounts[address].String())
+	}
+	return list
+}
+
+//txNotifys 需要提取的转账事件，执行失败的交易只扣除了手续费
+func txNotifys(trx *Transaction) []Notify {
+	if !trx.Failed {
+		return trx.Notifys
+	}
+
+	var notifys []Notify
+	for _, notify := range trx.Notifys {
+		if notify.IsFee {
+			notifys = append(notifys, notify)
 		}
+	}
+	//节点没有返回手续费事件时，按实际消耗的gas生成付款地址的手续费扣款
+	if len(notifys) == 0 && trx.Payer != "" && trx.GasConsumed > 0 {
+		notifys = append(notifys, Notify{
+			ContractAddress: ontologyTransaction.ONGContractAddress,
+			IsFee:           true,
+			Method:          "transfer",
+			From:            trx.Payer,
+			To:              governanceAddress,
+			Amount:          calculateAmount(strconv.FormatUint(trx.GasConsumed, 10), ""),
+		})
+	}
+	return notifys
+}
 
-		eds = append(eds, ed)
+//txFees 交易的实际手续费，优先使用手续费事件的金额，没有时使用GasConsumed
+func txFees(trx *Transaction) string {
+	fees := big.NewInt(0)
+	for _, notify := range trx.Notifys {
+		if !notify.IsFee {
+			continue
+		}
+		amount, ok := new(big.Int).SetString(notify.Amount, 10)
+		if ok {
+			fees.Add(fees, amount)
+		}
+	}
+	if fees.Sign() == 0 && trx.GasConsumed > 0 {
+		return calculateAmount(strconv.FormatUint(trx.GasConsumed, 10), "")
 	}
-	return eds
+	return fees.String()
 }
 
 //newExtractDataNotify 发送通知
 func (bs *ONTBlockScanner) newExtractDataNotify(height uint64, extractData map[string]*openwallet.TxExtractData) error {
 
+	//记录到本地地址交易索引
+	if err := bs.saveAddressTxRecords(extractData); err != nil {
+		log.Std.Error("block height: %d, save address tx records failed. unexpected error: %v", height, err)
+	}
+
 	for o, _ := range bs.Observers {
 		for key, data := range extractData {
-			eds := modifyExtractData(data)
-			for _, ed := range eds {
-				err := o.BlockExtractDataNotify(key, ed)
+			err := o.BlockExtractDataNotify(key, data)
+			if err != nil {
+				log.Error("BlockExtractDataNotify unexpected error:", err)
+				//记录未扫区块
+				unscanRecord := openwallet.NewUnscanRecord(height, "", "ExtractData Notify failed.", bs.wm.Symbol())
+				err = bs.SaveUnscanRecord(unscanRecord)
 				if err != nil {
-					log.Error("BlockExtractDataNotify unexpected error:", err)
-					//记录未扫区块
-					unscanRecord := openwallet.NewUnscanRecord(height, "", "ExtractData Notify failed.", bs.wm.Symbol())
-					err = bs.SaveUnscanRecord(unscanRecord)
-					if err != nil {
-						log.Std.Error("block height: %d, save unscan record failed. unexpected error: %v", height, err.Error())
-					}
-
+					log.Std.Error("block height: %d, save unscan record failed. unexpected error: %v", height, err.Error())
 				}
-			}
 
+			}
 		}
 	}
 
@@ -880,18 +1081,19 @@ func (bs *ONTBlockScanner) DeleteUnscanRecordNotFindTX() error {
 	//删除找不到交易单
 	reason := "[-5]No information available about transaction"
 
-	if bs.BlockchainDAI == nil {
-		return fmt.Errorf("Blockchain DAI is not setup ")
+	dai, err := bs.blockchainDAI()
+	if err != nil {
+		return err
 	}
 
-	list, err := bs.BlockchainDAI.GetUnscanRecords(bs.wm.Symbol())
+	list, err := dai.GetUnscanRecords(bs.wm.Symbol())
 	if err != nil {
 		return err
 	}
 
 	for _, r := range list {
 		if strings.HasPrefix(r.Reason, reason) {
-			bs.BlockchainDAI.DeleteUnscanRecordByID(r.ID, bs.wm.Symbol())
+			dai.DeleteUnscanRecordByID(r.ID, bs.wm.Symbol())
 		}
 	}
 	return nil
@@ -1014,11 +1216,12 @@ func (wm *WalletManager) GetBlockHeight() (uint64, error) {
 //GetLocalNewBlock 获取本地记录的区块高度和hash
 func (bs *ONTBlockScanner) GetLocalNewBlock() (uint64, string, error) {
 
-	if bs.BlockchainDAI == nil {
-		return 0, "", fmt.Errorf("Blockchain DAI is not setup ")
+	dai, err := bs.blockchainDAI()
+	if err != nil {
+		return 0, "", err
 	}
 
-	header, err := bs.BlockchainDAI.GetCurrentBlockHead(bs.wm.Symbol())
+	header, err := dai.GetCurrentBlockHead(bs.wm.Symbol())
 	if err != nil {
 		return 0, "", err
 	}
@@ -1029,8 +1232,9 @@ func (bs *ONTBlockScanner) GetLocalNewBlock() (uint64, string, error) {
 //SaveLocalNewBlock 记录区块高度和hash到本地
 func (bs *ONTBlockScanner) SaveLocalNewBlock(blockHeight uint64, blockHash string) error {
 
-	if bs.BlockchainDAI == nil {
-		return fmt.Errorf("Blockchain DAI is not setup ")
+	dai, err := bs.blockchainDAI()
+	if err != nil {
+		return err
 	}
 
 	header := &openwallet.BlockHeader{
@@ -1040,7 +1244,7 @@ func (bs *ONTBlockScanner) SaveLocalNewBlock(blockHeight uint64, blockHash strin
 		Symbol: bs.wm.Symbol(),
 	}
 
-	return bs.BlockchainDAI.SaveCurrentBlockHead(header)
+	return dai.SaveCurrentBlockHead(header)
 }
 
 //GetBlockHash 根据区块高度获得区块hash
@@ -1065,37 +1269,72 @@ func (wm *WalletManager) GetTransaction(txid string) (*Transaction, error) {
 	return wm.RPCClient.getTransaction(txid)
 }
 
-//GetAssetsAccountBalanceByAddress 查询账户相关地址的交易记录
-func (bs *ONTBlockScanner) GetBalanceByAddress(address ...string) ([]*openwallet.Balance, error) {
+//GetNativeBalanceByAddress 并发查询地址的ONT、ONG和未解绑的ONG，结果与传入地址顺序一致
+//查询失败的地址不在结果中，失败原因通过 *BalanceError 返回
+func (wm *WalletManager) GetNativeBalanceByAddress(address ...string) ([]*AddrBalance, error) {
 
-	addrsBalance := make([]*openwallet.Balance, 0)
+	list := make([]*AddrBalance, len(address))
+	errs := make([]error, len(address))
+	parallel(len(address), maxRpcFanOutConcurrency, func(i int) {
+		list[i], errs[i] = wm.RPCClient.getBalance(address[i])
+	})
 
-	for _, addr := range address {
-		balance, err := bs.wm.RPCClient.getONTBalance(addr)
-		if err != nil {
-			return nil, err
+	balances := make([]*AddrBalance, 0, len(address))
+	balanceErr := &BalanceError{Errors: make(map[string]error)}
+	for i, addr := range address {
+		if errs[i] != nil {
+			balanceErr.Errors[addr] = errs[i]
+			continue
 		}
+		balances = append(balances, list[i])
+	}
 
+	if len(balanceErr.Errors) > 0 {
+		return balances, balanceErr
+	}
+	return balances, nil
+}
+
+//GetBalanceByAddress 查询地址的ONT余额，查询失败的地址通过 *BalanceError 返回
+func (bs *ONTBlockScanner) GetBalanceByAddress(address ...string) ([]*openwallet.Balance, error) {
+
+	balances, err := bs.wm.GetNativeBalanceByAddress(address...)
+
+	addrsBalance := make([]*openwallet.Balance, 0, len(balances))
+	for _, balance := range balances {
+		ont, _ := convertBigIntToFloatViaDecimal(balance.ONTBalance.String(), ONTBalanceDecimals)
 		addrsBalance = append(addrsBalance, &openwallet.Balance{
-			Symbol:  bs.wm.Symbol(),
-			Address: addr,
-			Balance: balance.ONTBalance.String(),
+			Symbol:           bs.wm.Symbol(),
+			Address:          balance.Address,
+			Balance:          ont.String(),
+			ConfirmBalance:   ont.String(),
+			UnconfirmBalance: "0",
 		})
 	}
 
-	return nil, nil
-
+	return addrsBalance, err
 }
 
 func (bs *ONTBlockScanner) GetBalanceByAddressAndContract(fee *big.Int, contractAddress string, address ...string) ([]*openwallet.Balance, []bool, error) {
 
-	addrsBalance := make([]*openwallet.Balance, 0)
-	feeEnough := make([]bool, 0)
-	for _, addr := range address {
-		balance, err := bs.wm.RPCClient.getBalance(addr)
+	balances, err := bs.wm.RPCClient.getBalances(address...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//OEP-4代币余额通过预执行合约查询
+	var tokenBalances []*big.Int
+	if contractAddress != ontologyTransaction.ONTContractAddress && contractAddress != ontologyTransaction.ONGContractAddress {
+		tokenBalances, err = bs.wm.getOEP4Balances(contractAddress, address...)
 		if err != nil {
 			return nil, nil, err
 		}
+	}
+
+	addrsBalance := make([]*openwallet.Balance, 0)
+	feeEnough := make([]bool, 0)
+	for i, addr := range address {
+		balance := balances[i]
 
 		balanceStr := ""
 		symbol := ""
@@ -1122,7 +1361,15 @@ func (bs *ONTBlockScanner) GetBalanceByAddressAndContract(fee *big.Int, contract
 				feeEnough = append(feeEnough, true)
 			}
 		} else {
-			//
+			balanceStr = tokenBalances[i].String()
+			if balanceStr == "0" {
+				continue
+			}
+			if balance.ONGBalance.Cmp(fee) < 0 {
+				feeEnough = append(feeEnough, false)
+			} else {
+				feeEnough = append(feeEnough, true)
+			}
 		}
 
 		addrsBalance = append(addrsBalance, &openwallet.Balance{
@@ -1136,55 +1383,27 @@ func (bs *ONTBlockScanner) GetBalanceByAddressAndContract(fee *big.Int, contract
 
 }
 
-//GetAssetsAccountTransactionsByAddress 查询账户相关地址的交易记录
+//GetTransactionsByAddress 从本地地址交易索引查询地址的交易记录，按区块高度从新到旧分页
 func (bs *ONTBlockScanner) GetTransactionsByAddress(offset, limit int, coin openwallet.Coin, address ...string) ([]*openwallet.TxExtractData, error) {
 
-	// var (
-	// 	array = make([]*openwallet.TxExtractData, 0)
-	// )
-
-	// trxs, err := bs.wm.getMultiAddrTransactionsByExplorer(offset, limit, address...)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	// key := "account"
-
-	// //提取账户相关的交易单
-	// var scanAddressFunc openwallet.BlockScanAddressFunc = func(findAddr string) (string, bool) {
-	// 	for _, a := range address {
-	// 		if findAddr == a {
-	// 			return key, true
-	// 		}
-	// 	}
-	// 	return "", false
-	// }
-
-	// //要检查一下tx.BlockHeight是否有值
-
-	// for _, tx := range trxs {
-
-	// 	result := ExtractResult{
-	// 		BlockHeight: tx.BlockHeight,
-	// 		TxID:        tx.TxID,
-	// 		extractData: make(map[string]*openwallet.TxExtractData),
-	// 	}
+	index, err := bs.addressTxIndex()
+	if err != nil {
+		return nil, err
+	}
 
-	// 	bs.extractTransaction(tx, &result, scanAddressFunc)
-	// 	data := result.extractData
-	// 	txExtract := data[key]
-	// 	if txExtract != nil {
-	// 		array = append(array, txExtract)
-	// 	}
-	// }
+	array := make([]*openwallet.TxExtractData, 0)
+	for _, records := range index.query(offset, limit, coin, address...) {
+		array = append(array, bs.newTxExtractDataFromRecords(records))
+	}
 
-	// return array, nil
-	return nil, nil
+	return array, nil
 }
 
 //Run 运行
 func (bs *ONTBlockScanner) Run() error {
 
+	bs.setupWebSocket()
+
 	bs.BlockScannerBase.Run()
 
 	return nil
@@ -1193,6 +1412,10 @@ func (bs *ONTBlockScanner) Run() error {
 ////Stop 停止扫描
 func (bs *ONTBlockScanner) Stop() error {
 
+	if bs.ws != nil {
+		bs.ws.Stop()
+	}
+
 	bs.BlockScannerBase.Stop()
 
 	return nil
@@ -1214,91 +1437,114 @@ func (bs *ONTBlockScanner) Restart() error {
 	return nil
 }
 
-/******************* 使用insight socket.io 监听区块 *******************/
+/******************* 使用Ontology WebSocket 监听区块 *******************/
 
-//setupSocketIO 配置socketIO监听新区块
-func (bs *ONTBlockScanner) setupSocketIO() error {
+//setupWebSocket 配置WebSocket监听新区块和合约事件，未配置webSocketAPI时只使用轮询
+func (bs *ONTBlockScanner) setupWebSocket() {
 
-	log.Info("block scanner use socketIO to listen new data")
+	if len(bs.wm.Config.WebSocketAPI) == 0 {
+		return
+	}
 
-	var (
-		room = "inv"
-	)
+	if bs.ws == nil {
+		log.Info("block scanner use websocket to listen new data")
+		bs.blockPushes = make(chan *wsBlockTxHashs, maxQueuedBlockPushes)
+		go bs.blockPushWorker()
+		bs.ws = NewWebSocketClient(bs.wm.Config.WebSocketAPI, bs.queueBlockPush, bs.onEventPush)
+		bs.ws.SetReconnectInterval(bs.wm.Config.WebSocketReconnectInterval)
+	}
 
-	if bs.socketIO == nil {
+	bs.ws.Start()
+}
 
-		apiUrl, err := url.Parse(bs.wm.Config.ServerAPI)
-		if err != nil {
-			return err
-		}
-		domain := apiUrl.Hostname()
-		port := common.NewString(apiUrl.Port()).Int()
-		c, err := gosocketio.Dial(
-			gosocketio.GetUrl(domain, port, false),
-			transport.GetDefaultWebsocketTransport())
-		if err != nil {
-			return err
-		}
+//isSubscribed WebSocket是否正在接收推送
+func (bs *ONTBlockScanner) isSubscribed() bool {
+	return bs.ws != nil && bs.ws.IsConnected()
+}
 
-		bs.socketIO = c
+//queueBlockPush 推送的区块交给扫描协程处理，队列满时说明扫描跟不上推送，改为补扫
+func (bs *ONTBlockScanner) queueBlockPush(push *wsBlockTxHashs) {
+	select {
+	case bs.blockPushes <- push:
+	default:
+		log.Std.Info("block scanner drop pushed height: %d, too many blocks are waiting", push.Height)
+		bs.catchUp()
+	}
+}
 
+//blockPushWorker 按推送顺序扫描区块
+func (bs *ONTBlockScanner) blockPushWorker() {
+	for push := range bs.blockPushes {
+		bs.onBlockPush(push)
 	}
+}
 
-	err := bs.socketIO.On("tx", func(h *gosocketio.Channel, args interface{}) {
-		//log.Info("block scanner socketIO get new transaction received: ", args)
-		txMap, ok := args.(map[string]interface{})
-		if ok {
-			txid := txMap["txid"].(string)
-			errInner := bs.BatchExtractTransaction(0, "", []string{txid})
-			if errInner != nil {
-				log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", errInner)
-			}
-		}
+//onBlockPush 处理推送的新区块，连续的区块直接提取推送的交易，跳块或分叉时按轮询流程扫描
+func (bs *ONTBlockScanner) onBlockPush(push *wsBlockTxHashs) {
 
-	})
+	if !bs.Scanning {
+		return
+	}
+
+	bs.scanMu.Lock()
+	defer bs.scanMu.Unlock()
+
+	header, err := bs.GetScannedBlockHeader()
 	if err != nil {
-		return err
+		log.Std.Info("block scanner can not get new block height; unexpected error: %v", err)
+		return
 	}
 
-	/*
-		err = bs.socketIO.On("block", func(h *gosocketio.Channel, args interface{}) {
-			log.Info("block scanner socketIO get new block received: ", args)
-			hash, ok := args.(string)
-			if ok {
+	//已扫描的区块
+	if push.Height <= header.Height {
+		return
+	}
 
-				block, errInner := bs.wm.GetBlock(hash)
-				if errInner != nil {
-					log.Std.Info("block scanner can not get new block data; unexpected error: %v", errInner)
-				}
+	if push.Height == header.Height+1 {
 
-				errInner = bs.scanBlock(block)
-				if errInner != nil {
-					log.Std.Info("block scanner can not block: %d; unexpected error: %v", block.Height, errInner)
-				}
+		block, err := bs.wm.GetBlock(push.Hash)
+		if err != nil {
+			log.Std.Info("block scanner can not get pushed block data; unexpected error: %v", err)
+		} else if block.PrevBlockHash == header.Hash {
+
+			log.Std.Info("block scanner scanning pushed height: %d ...", push.Height)
+
+			err = bs.batchExtractTransaction(push.Height, push.Hash, push.Transactions, block.TxHeaders, nil)
+			if err != nil {
+				log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
 			}
 
-		})
-		if err != nil {
-			return err
-		}
-	*/
+			//保存本地新高度
+			bs.wm.Blockscanner.SaveLocalNewBlock(push.Height, push.Hash)
+			bs.SaveLocalBlock(block)
 
-	err = bs.socketIO.On(gosocketio.OnDisconnection, func(h *gosocketio.Channel) {
-		log.Info("block scanner socketIO disconnected")
-	})
-	if err != nil {
-		return err
+			//通知新区块给观测者，异步处理
+			bs.newBlockNotify(block, false)
+			return
+		}
 	}
 
-	err = bs.socketIO.On(gosocketio.OnConnection, func(h *gosocketio.Channel) {
-		log.Info("block scanner socketIO connected")
-		h.Emit("subscribe", room)
-	})
-	if err != nil {
-		return err
+	bs.catchUp()
+}
+
+//catchUp 跳块或分叉时在WebSocket读取循环外按轮询流程扫描，同一时间只有一个补扫任务
+func (bs *ONTBlockScanner) catchUp() {
+	if !atomic.CompareAndSwapInt32(&bs.catchingUp, 0, 1) {
+		return
 	}
+	go func() {
+		defer atomic.StoreInt32(&bs.catchingUp, 0)
 
-	return nil
+		bs.scanMu.Lock()
+		defer bs.scanMu.Unlock()
+
+		bs.scanBlockTask()
+	}()
+}
+
+//onEventPush 缓存推送的合约事件，提取交易时使用
+func (bs *ONTBlockScanner) onEventPush(event *smartCodeEvent) {
+	bs.cacheTxEvents(map[string]*smartCodeEvent{event.TxHash: event})
 }
 
 //SupportBlockchainDAI 支持外部设置区块链数据访问接口
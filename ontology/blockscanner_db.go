@@ -16,15 +16,15 @@
 package ontology
 
 import (
-	"fmt"
 	"github.com/blocktree/openwallet/v2/openwallet"
 )
 
 //SaveLocalBlockHead 记录区块高度和hash到本地
 func (bs *ONTBlockScanner) SaveLocalBlockHead(blockHeight uint32, blockHash string) error {
 
-	if bs.BlockchainDAI == nil {
-		return fmt.Errorf("Blockchain DAI is not setup ")
+	dai, err := bs.blockchainDAI()
+	if err != nil {
+		return err
 	}
 
 	header := &openwallet.BlockHeader{
@@ -34,17 +34,18 @@ func (bs *ONTBlockScanner) SaveLocalBlockHead(blockHeight uint32, blockHash stri
 		Symbol: bs.wm.Symbol(),
 	}
 
-	return bs.BlockchainDAI.SaveCurrentBlockHead(header)
+	return dai.SaveCurrentBlockHead(header)
 }
 
 //GetLocalBlockHead 获取本地记录的区块高度和hash
 func (bs *ONTBlockScanner) GetLocalBlockHead() (uint32, string, error) {
 
-	if bs.BlockchainDAI == nil {
-		return 0, "", fmt.Errorf("Blockchain DAI is not setup ")
+	dai, err := bs.blockchainDAI()
+	if err != nil {
+		return 0, "", err
 	}
 
-	header, err := bs.BlockchainDAI.GetCurrentBlockHead(bs.wm.Symbol())
+	header, err := dai.GetCurrentBlockHead(bs.wm.Symbol())
 	if err != nil {
 		return 0, "", err
 	}
@@ -55,8 +56,9 @@ func (bs *ONTBlockScanner) GetLocalBlockHead() (uint32, string, error) {
 //SaveLocalBlock 记录本地新区块
 func (bs *ONTBlockScanner) SaveLocalBlock(blockHeader *Block) error {
 
-	if bs.BlockchainDAI == nil {
-		return fmt.Errorf("Blockchain DAI is not setup ")
+	dai, err := bs.blockchainDAI()
+	if err != nil {
+		return err
 	}
 
 	header := &openwallet.BlockHeader{
@@ -68,24 +70,27 @@ func (bs *ONTBlockScanner) SaveLocalBlock(blockHeader *Block) error {
 		Symbol:            bs.wm.Symbol(),
 	}
 
-	return bs.BlockchainDAI.SaveLocalBlockHead(header)
+	return dai.SaveLocalBlockHead(header)
 }
 
 //GetLocalBlock 获取本地区块数据
 func (bs *ONTBlockScanner) GetLocalBlock(height uint32) (*Block, error) {
 
-	if bs.BlockchainDAI == nil {
-		return nil, fmt.Errorf("Blockchain DAI is not setup ")
+	dai, err := bs.blockchainDAI()
+	if err != nil {
+		return nil, err
 	}
 
-	header, err := bs.BlockchainDAI.GetLocalBlockHeadByHeight(uint64(height), bs.wm.Symbol())
+	header, err := dai.GetLocalBlockHeadByHeight(uint64(height), bs.wm.Symbol())
 	if err != nil {
 		return nil, err
 	}
 
 	block := &Block{
-		Hash:   header.Hash,
-		Height: header.Height,
+		Hash:          header.Hash,
+		PrevBlockHash: header.Previousblockhash,
+		Height:        header.Height,
+		Timestamp:     header.Time,
 	}
 
 	return block, nil
@@ -94,28 +99,31 @@ func (bs *ONTBlockScanner) GetLocalBlock(height uint32) (*Block, error) {
 //SaveUnscanRecord 保存交易记录到钱包数据库
 func (bs *ONTBlockScanner) SaveUnscanRecord(record *openwallet.UnscanRecord) error {
 
-	if bs.BlockchainDAI == nil {
-		return fmt.Errorf("Blockchain DAI is not setup ")
+	dai, err := bs.blockchainDAI()
+	if err != nil {
+		return err
 	}
 
-	return bs.BlockchainDAI.SaveUnscanRecord(record)
+	return dai.SaveUnscanRecord(record)
 }
 
 //DeleteUnscanRecord 删除指定高度的未扫记录
 func (bs *ONTBlockScanner) DeleteUnscanRecord(height uint32) error {
 
-	if bs.BlockchainDAI == nil {
-		return fmt.Errorf("Blockchain DAI is not setup ")
+	dai, err := bs.blockchainDAI()
+	if err != nil {
+		return err
 	}
 
-	return bs.BlockchainDAI.DeleteUnscanRecordByHeight(uint64(height), bs.wm.Symbol())
+	return dai.DeleteUnscanRecordByHeight(uint64(height), bs.wm.Symbol())
 }
 
 func (bs *ONTBlockScanner) GetUnscanRecords() ([]*openwallet.UnscanRecord, error) {
 
-	if bs.BlockchainDAI == nil {
-		return nil, fmt.Errorf("Blockchain DAI is not setup ")
+	dai, err := bs.blockchainDAI()
+	if err != nil {
+		return nil, err
 	}
 
-	return bs.BlockchainDAI.GetUnscanRecords(bs.wm.Symbol())
+	return dai.GetUnscanRecords(bs.wm.Symbol())
 }
@@ -55,8 +55,10 @@ type WalletConfig struct {
 	configFileName string
 	//rpc证书
 	CertFileName string
-	//区块链数据文件
-	//BlockchainFile string
+	//区块链数据文件，未设置外部BlockchainDAI时使用
+	BlockchainFile string
+	//地址交易索引文件
+	TxIndexFile string
 	//是否测试网络
 	IsTestNet bool
 	// 核心钱包是否只做监听
@@ -71,6 +73,26 @@ type WalletConfig struct {
 	ServerAPI string
 	//Restful API
 	RestfulServerAPI string
+	//节点接口类型，NodeAPIJsonRpc 或 NodeAPIRest
+	NodeAPIType int
+	//Restful API 节点列表，第一个为首选节点
+	RestfulServerAPIs []string
+	//节点健康检查间隔
+	NodeCheckInterval time.Duration
+	//节点允许落后最高高度的最大区块数
+	NodeMaxHeightLag uint64
+	//RPC请求失败的重试次数
+	RpcMaxRetries int
+	//RPC请求首次重试的间隔
+	RpcRetryBackoff time.Duration
+	//追块时并发预取的区块数
+	BlockPrefetchSize uint64
+	//区块分叉时最多回滚的区块数
+	MaxReorgDepth uint64
+	//WebSocket API，为空时只轮询扫描
+	WebSocketAPI string
+	//WebSocket断线重连间隔
+	WebSocketReconnectInterval time.Duration
 	//Mainnet node API
 	MainnetNodeAPI string
 	//钱包安装的路径
@@ -93,12 +115,16 @@ type WalletConfig struct {
 	WalletPassword string
 	//s是否支持隔离验证
 	SupportSegWit bool
-	//GasLimit
+	//GasLimit，为0时预执行交易估算
 	GasLimit      uint64
 	GasPriceFixed uint64
 	GasPriceType  uint64
+	//预执行估算gasLimit时增加的百分比
+	GasLimitMargin uint64
 	// data directory
 	DataDir string
+	//合约ABI文件目录，关注的合约事件按ABI解析为合约回执
+	ABIDir string
 }
 
 func NewConfig(symbol string, masterKey string) *WalletConfig {
@@ -129,7 +155,9 @@ func NewConfig(symbol string, masterKey string) *WalletConfig {
 	//rpc证书
 	c.CertFileName = "rpc.cert"
 	//区块链数据文件
-	//c.BlockchainFile = "blockchain.db"
+	c.BlockchainFile = "blockchain.json"
+	//地址交易索引文件
+	c.TxIndexFile = "txindex.jsonl"
 	//是否测试网络
 	c.IsTestNet = true
 	// 核心钱包是否只做监听
@@ -144,6 +172,22 @@ func NewConfig(symbol string, masterKey string) *WalletConfig {
 	c.ServerAPI = "http://127.0.0.1:20336"
 	//Rest url
 	c.RestfulServerAPI = "http://127.0.0.1:20336"
+	//节点接口类型
+	c.NodeAPIType = NodeAPIJsonRpc
+	//节点健康检查
+	c.NodeCheckInterval = DefaultNodeCheckInterval
+	c.NodeMaxHeightLag = DefaultNodeMaxHeightLag
+	//RPC请求重试
+	c.RpcMaxRetries = DefaultRpcMaxRetries
+	c.RpcRetryBackoff = DefaultRpcRetryBackoff
+	//追块预取区块数
+	c.BlockPrefetchSize = DefaultBlockPrefetchSize
+	//区块分叉最大回滚数
+	c.MaxReorgDepth = DefaultMaxReorgDepth
+	//WebSocket断线重连
+	c.WebSocketReconnectInterval = DefaultWSReconnectInterval
+	//预执行估算gasLimit的余量
+	c.GasLimitMargin = DefaultGasLimitMargin
 	//钱包安装的路径
 	c.NodeInstallPath = ""
 	//钱包数据文件目录
@@ -176,6 +220,8 @@ mainNetDataPath = ""
 testNetDataPath = ""
 # RPC Server Type，0: Rest API
 rpcServerType = 0
+# node api type, 0: JSON-RPC  1: REST /api/v1
+nodeAPIType = 0
 # RPC api url
 serverAPI = ""
 # RPC Authentication Username
@@ -250,6 +296,16 @@ func (wc *WalletConfig) makeDataDir() {
 	file.MkdirAll(wc.dbPath)
 }
 
+//blockchainFilePath 本地区块链数据文件路径
+func (wc *WalletConfig) blockchainFilePath() string {
+	return filepath.Join(wc.dbPath, wc.BlockchainFile)
+}
+
+//txIndexFilePath 本地地址交易索引文件路径
+func (wc *WalletConfig) txIndexFilePath() string {
+	return filepath.Join(wc.dbPath, wc.TxIndexFile)
+}
+
 //initConfig 初始化配置文件
 func (wc *WalletConfig) InitConfig() {
 
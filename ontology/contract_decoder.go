@@ -2,7 +2,6 @@ package ontology
 
 import (
 	"errors"
-	"fmt"
 	"math/big"
 	"strconv"
 
@@ -12,6 +11,13 @@ import (
 	"github.com/tidwall/gjson"
 )
 
+//getbalancev2 返回余额的精度
+const (
+	ONTBalanceDecimals = 9
+	ONGBalanceDecimals = 18
+)
+
+//AddrBalance 地址的ONT、ONG余额和未解绑的ONG
 type AddrBalance struct {
 	Address    string
 	ONTBalance *big.Int
@@ -124,48 +130,98 @@ func NewContractDecoder(wm *WalletManager) *ContractDecoder {
 	return &decoder
 }
 
+//GetTokenBalanceByAddress 查询地址的ONT、ONG或OEP-4代币余额，ONG的UnconfirmBalance为未解绑的ONG
+//查询失败的地址不在结果中，失败原因通过 *BalanceError 返回
 func (decoder *ContractDecoder) GetTokenBalanceByAddress(contract openwallet.SmartContract, address ...string) ([]*openwallet.TokenBalance, error) {
 
 	var tokenBalanceList []*openwallet.TokenBalance
 
-	for i := 0; i < len(address); i++ {
+	if contract.Address != ontologyTransaction.ONTContractAddress && contract.Address != ontologyTransaction.ONGContractAddress {
+		return decoder.getOEP4BalanceByAddress(contract, address...)
+	}
+
+	balances, err := decoder.wm.GetNativeBalanceByAddress(address...)
+
+	for _, balance := range balances {
 		tokenBalance := openwallet.TokenBalance{
 			Contract: &contract,
 		}
 		if contract.Address == ontologyTransaction.ONTContractAddress {
-			balance, err := decoder.wm.RPCClient.getONTBalance(address[i])
-			if err != nil {
-				return nil, fmt.Errorf("Get ONT balance of address [%v] failed with error : " + address[i])
-			}
-
-			balanceWithDecimal, err := convertBigIntToFloatViaDecimal(balance.ONTBalance.String(), int(contract.Decimals))
+			balanceWithDecimal, _ := convertBigIntToFloatViaDecimal(balance.ONTBalance.String(), int(contract.Decimals))
 			tokenBalance.Balance = &openwallet.Balance{
-				Address:          address[i],
+				Address:          balance.Address,
 				Symbol:           contract.Symbol,
 				Balance:          balanceWithDecimal.String(),
 				ConfirmBalance:   balanceWithDecimal.String(),
 				UnconfirmBalance: "0",
 			}
-		} else if contract.Address == ontologyTransaction.ONGContractAddress {
-			balance, err := decoder.wm.RPCClient.getONGBalance(address[i])
-			if err != nil {
-				return nil, fmt.Errorf("Get ONG balance of address [%v] failed with error : " + address[i])
-			}
-
-			balanceWithDecimal, err := convertBigIntToFloatViaDecimal(balance.ONGBalance.String(), int(contract.Decimals))
-			unboundBalanceWithDecimal, err := convertBigIntToFloatViaDecimal(balance.ONGUnbound.String(), int(contract.Decimals))
+		} else {
+			balanceWithDecimal, _ := convertBigIntToFloatViaDecimal(balance.ONGBalance.String(), int(contract.Decimals))
+			unboundBalanceWithDecimal, _ := convertBigIntToFloatViaDecimal(balance.ONGUnbound.String(), int(contract.Decimals))
 			tokenBalance.Balance = &openwallet.Balance{
-				Address:          address[i],
+				Address:          balance.Address,
 				Symbol:           contract.Symbol,
 				Balance:          balanceWithDecimal.String(),
 				ConfirmBalance:   balanceWithDecimal.String(),
 				UnconfirmBalance: unboundBalanceWithDecimal.String(),
 			}
-		} else {
-			// other contract
 		}
 		tokenBalanceList = append(tokenBalanceList, &tokenBalance)
 	}
 
+	return tokenBalanceList, err
+}
+
+//getOEP4BalanceByAddress 并发预执行balanceOf查询OEP-4代币余额
+func (decoder *ContractDecoder) getOEP4BalanceByAddress(contract openwallet.SmartContract, address ...string) ([]*openwallet.TokenBalance, error) {
+	balances := make([]*big.Int, len(address))
+	errs := make([]error, len(address))
+	parallel(len(address), maxRpcFanOutConcurrency, func(i int) {
+		balances[i], errs[i] = decoder.wm.getOEP4Balance(contract.Address, address[i])
+	})
+
+	var (
+		tokenBalanceList []*openwallet.TokenBalance
+		balanceErr       = &BalanceError{Errors: make(map[string]error)}
+	)
+	for i, balance := range balances {
+		if errs[i] != nil {
+			balanceErr.Errors[address[i]] = errs[i]
+			continue
+		}
+		balanceWithDecimal, _ := convertBigIntToFloatViaDecimal(balance.String(), int(contract.Decimals))
+		tokenBalanceList = append(tokenBalanceList, &openwallet.TokenBalance{
+			Contract: &contract,
+			Balance: &openwallet.Balance{
+				Address:          address[i],
+				Symbol:           contract.Symbol,
+				Balance:          balanceWithDecimal.String(),
+				ConfirmBalance:   balanceWithDecimal.String(),
+				UnconfirmBalance: "0",
+			},
+		})
+	}
+
+	if len(balanceErr.Errors) > 0 {
+		return tokenBalanceList, balanceErr
+	}
 	return tokenBalanceList, nil
 }
+
+//GetTokenMetadata 查询OEP-4代币的元数据，用于添加代币前校验合约
+func (decoder *ContractDecoder) GetTokenMetadata(contract string) (*openwallet.SmartContract, error) {
+	info, err := decoder.wm.GetOEP4TokenInfo(contract)
+	if err != nil {
+		return nil, err
+	}
+
+	return &openwallet.SmartContract{
+		ContractID: openwallet.GenContractID(decoder.wm.Symbol(), contract),
+		Symbol:     decoder.wm.Symbol(),
+		Address:    contract,
+		Token:      info.Symbol,
+		Protocol:   OEP4Protocol,
+		Name:       info.Name,
+		Decimals:   info.Decimals,
+	}, nil
+}
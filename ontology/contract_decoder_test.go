@@ -1,7 +1,11 @@
 package ontology
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/blocktree/go-owcdrivers/ontologyTransaction"
@@ -38,3 +42,60 @@ func Test_GetTokenBalanceByAddress(t *testing.T) {
 		fmt.Println(ret)
 	}
 }
+
+//newTestPreExecNode 按交易中调用的方法名返回预执行结果，balanceOf 只有 testAddress1 有余额
+func newTestPreExecNode() *httptest.Server {
+	results := map[string]interface{}{
+		"name":        hex.EncodeToString([]byte("Test Token")),
+		"symbol":      hex.EncodeToString([]byte("TST")),
+		"decimals":    8,
+		"totalSupply": "00e40b5402",
+	}
+	return newTestNode(func(method string, params []interface{}) interface{} {
+		txHex := params[0].(string)
+
+		var result interface{} = ""
+		for method, ret := range results {
+			if strings.Contains(txHex, hex.EncodeToString(append([]byte{byte(len(method))}, method...))) {
+				result = ret
+			}
+		}
+		if strings.Contains(txHex, "0962616c616e63654f66") && strings.Contains(txHex, "140000000000000000000000000000000000000001") {
+			result = "40420f"
+		}
+		return testPreExecResult(20000, result)
+	})
+}
+
+func TestContractDecoder_OEP4(t *testing.T) {
+	node := newTestPreExecNode()
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+
+	token, err := wm.ContractDecoder.GetTokenMetadata(testOEP4Contract)
+	if err != nil {
+		t.Fatalf("GetTokenMetadata failed unexpected error: %v", err)
+	}
+	if token.Name != "Test Token" || token.Token != "TST" || token.Decimals != 8 || token.Protocol != OEP4Protocol {
+		t.Errorf("unexpected token metadata: %+v", token)
+	}
+	info, _ := wm.GetOEP4TokenInfo(testOEP4Contract)
+	if info == nil || info.TotalSupply != "10000000000" {
+		t.Errorf("unexpected token info: %+v", info)
+	}
+
+	balances, err := wm.ContractDecoder.GetTokenBalanceByAddress(*token, testAddress1, testAddress7)
+	if err != nil || len(balances) != 2 {
+		t.Fatalf("GetTokenBalanceByAddress = %+v, %v", balances, err)
+	}
+	if balances[0].Balance.Balance != "0.01" || balances[1].Balance.Balance != "0" {
+		t.Errorf("unexpected balances: %s, %s", balances[0].Balance.Balance, balances[1].Balance.Balance)
+	}
+
+	_, err = wm.ContractDecoder.GetTokenBalanceByAddress(*token, "bad")
+	balanceErr := &BalanceError{}
+	if !errors.As(err, &balanceErr) || balanceErr.Errors["bad"] == nil {
+		t.Errorf("GetTokenBalanceByAddress error = %v, want error of address bad", err)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/tidwall/gjson"
+)
+
+//extractContractReceipts 按ABI解析关注合约的事件，每个数据源的每个合约生成一个回执
+func (bs *ONTBlockScanner) extractContractReceipts(trx *Transaction, result *ExtractResult, scanTargetFunc openwallet.BlockScanTargetFuncV2) {
+	if len(trx.Events) == 0 || bs.wm.ABIRegistry == nil || bs.wm.ABIRegistry.Len() == 0 {
+		return
+	}
+
+	var (
+		createAt = time.Now().Unix()
+		status   = openwallet.TxStatusSuccess
+		receipts = make(map[string]*openwallet.SmartContractReceipt)
+		rawEvent = make(map[*openwallet.SmartContractReceipt][]json.RawMessage)
+	)
+
+	if trx.Failed {
+		status = openwallet.TxStatusFail
+	}
+
+	for _, event := range trx.Events {
+		abi, ok := bs.wm.ABIRegistry.Get(event.ContractAddress)
+		if !ok {
+			continue
+		}
+
+		targetResult := scanTargetFunc(openwallet.ScanTargetParam{
+			ScanTarget:     event.ContractAddress,
+			Symbol:         bs.wm.Symbol(),
+			ScanTargetType: openwallet.ScanTargetTypeContractAddress,
+		})
+		if !targetResult.Exist {
+			continue
+		}
+		coin, _ := bs.notifyCoin(event.ContractAddress, scanTargetFunc)
+
+		name, fields, err := abi.DecodeEvent(gjson.Parse(event.States).Array())
+		if err != nil {
+			log.Std.Debug("tx: %s can not decode event of contract %s: %v", trx.TxID, event.ContractAddress, err)
+			continue
+		}
+		value, _ := json.Marshal(fields)
+
+		key := targetResult.SourceKey + "_" + event.ContractAddress
+		receipt := receipts[key]
+		if receipt == nil {
+			receipt = &openwallet.SmartContractReceipt{
+				Coin:        coin,
+				TxID:        trx.TxID,
+				From:        trx.Payer,
+				To:          event.ContractAddress,
+				Value:       "0",
+				Fees:        txFees(trx),
+				Status:      status,
+				BlockHash:   trx.BlockHash,
+				BlockHeight: trx.BlockHeight,
+				ConfirmTime: createAt,
+			}
+			receipts[key] = receipt
+			if result.contractData == nil {
+				result.contractData = make(map[string][]*openwallet.SmartContractReceipt)
+			}
+			result.contractData[targetResult.SourceKey] = append(result.contractData[targetResult.SourceKey], receipt)
+		}
+
+		receipt.Events = append(receipt.Events, &openwallet.SmartContractEvent{
+			Contract: &coin.Contract,
+			Event:    name,
+			Value:    string(value),
+		})
+		rawEvent[receipt] = append(rawEvent[receipt], json.RawMessage(event.States))
+	}
+
+	for receipt, raw := range rawEvent {
+		rawReceipt, _ := json.Marshal(raw)
+		receipt.RawReceipt = string(rawReceipt)
+		receipt.GenWxID()
+	}
+}
+
+//newContractReceiptNotify 发送合约回执通知
+func (bs *ONTBlockScanner) newContractReceiptNotify(height uint64, contractData map[string][]*openwallet.SmartContractReceipt) {
+	for o := range bs.Observers {
+		for key, receipts := range contractData {
+			for _, receipt := range receipts {
+				err := o.BlockExtractSmartContractDataNotify(key, receipt)
+				if err != nil {
+					log.Error("BlockExtractSmartContractDataNotify unexpected error:", err)
+					//记录未扫区块
+					unscanRecord := openwallet.NewUnscanRecord(height, receipt.TxID, "ExtractSmartContractData Notify failed.", bs.wm.Symbol())
+					err = bs.SaveUnscanRecord(unscanRecord)
+					if err != nil {
+						log.Std.Error("block height: %d, save unscan record failed. unexpected error: %v", height, err.Error())
+					}
+				}
+			}
+		}
+	}
+}
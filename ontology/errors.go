@@ -0,0 +1,172 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//Ontology 节点 JsonRpcResponse.Error 错误码
+const (
+	ErrCodeSuccess            = 0
+	ErrCodeSessionExpired     = 41001
+	ErrCodeServiceCeiling     = 41002
+	ErrCodeIllegalDataFormat  = 41003
+	ErrCodeInvalidVersion     = 41004
+	ErrCodeInvalidMethod      = 42001
+	ErrCodeInvalidParams      = 42002
+	ErrCodeInvalidTransaction = 43001
+	ErrCodeInvalidAsset       = 43002
+	ErrCodeInvalidBlock       = 43003
+	ErrCodeUnknownTransaction = 44001
+	ErrCodeUnknownAsset       = 44002
+	ErrCodeUnknownBlock       = 44003
+	ErrCodeUnknownContract    = 44004
+	ErrCodeInternalError      = 45001
+	ErrCodeSmartCodeError     = 47001
+	ErrCodePreExecError       = 47002
+)
+
+//Ontology 交易池拒绝交易的错误码，REST 接口直接返回
+const (
+	ErrCodeTxPoolDuplicatedTx   = 45002
+	ErrCodeTxPoolDuplicateInput = 45003
+	ErrCodeTxPoolDuplicateHash  = 45010
+	ErrCodeTxPoolGasPrice       = 45020
+)
+
+//可通过 errors.Is 判断的错误类型
+var (
+	ErrNodeUnavailable      = errors.New("rpc node unavailable")
+	ErrServiceCeiling       = errors.New("rpc service ceiling")
+	ErrInvalidMethod        = errors.New("invalid rpc method")
+	ErrInvalidParams        = errors.New("invalid rpc params")
+	ErrInvalidTransaction   = errors.New("invalid transaction")
+	ErrDuplicateTransaction = errors.New("duplicate transaction")
+	ErrInsufficientGas      = errors.New("insufficient gas")
+	ErrUnknownTransaction   = errors.New("unknown transaction")
+	ErrUnknownBlock         = errors.New("unknown block")
+	ErrUnknownContract      = errors.New("unknown contract")
+	ErrNodeInternal         = errors.New("rpc node internal error")
+	ErrSmartCodeExecution   = errors.New("smart contract execution error")
+	ErrPreExecution         = errors.New("transaction pre-execution error")
+)
+
+var rpcErrorCodeKinds = map[int64]error{
+	ErrCodeServiceCeiling:     ErrServiceCeiling,
+	ErrCodeInvalidMethod:      ErrInvalidMethod,
+	ErrCodeInvalidParams:      ErrInvalidParams,
+	ErrCodeInvalidTransaction: ErrInvalidTransaction,
+	ErrCodeUnknownTransaction: ErrUnknownTransaction,
+	ErrCodeUnknownBlock:       ErrUnknownBlock,
+	ErrCodeUnknownContract:    ErrUnknownContract,
+	ErrCodeInternalError:      ErrNodeInternal,
+	ErrCodeSmartCodeError:     ErrSmartCodeExecution,
+	ErrCodePreExecError:       ErrPreExecution,
+
+	ErrCodeTxPoolDuplicatedTx:   ErrDuplicateTransaction,
+	ErrCodeTxPoolDuplicateInput: ErrDuplicateTransaction,
+	ErrCodeTxPoolDuplicateHash:  ErrDuplicateTransaction,
+	ErrCodeTxPoolGasPrice:       ErrInsufficientGas,
+}
+
+//JSON-RPC 接口交易池拒绝时返回 ErrCodeInvalidTransaction，拒绝原因是交易池的描述
+var txPoolRejectKinds = []struct {
+	reason *regexp.Regexp
+	kind   error
+}{
+	{regexp.MustCompile(`^duplicated transaction (input )?detected$`), ErrDuplicateTransaction},
+	{regexp.MustCompile(`^transaction [0-9a-f]{64} is already in the tx pool$`), ErrDuplicateTransaction},
+	{regexp.MustCompile(`^Please input gasLimit >= \d+ and gasPrice >= \d+$`), ErrInsufficientGas},
+}
+
+//RpcError 节点返回的错误，可通过 errors.As 获取错误码
+type RpcError struct {
+	Method string
+	Code   int64
+	Desc   string
+	Result string
+}
+
+func (e *RpcError) Error() string {
+	return fmt.Sprintf("JsonRpcResponse method:%s error code:%d desc:%s result:%s", e.Method, e.Code, e.Desc, e.Result)
+}
+
+//Is 按错误码匹配错误类型，ErrCodeInvalidTransaction 再按交易池的拒绝原因匹配
+func (e *RpcError) Is(target error) bool {
+	if kind, ok := rpcErrorCodeKinds[e.Code]; ok && kind == target {
+		return true
+	}
+	if e.Code != ErrCodeInvalidTransaction {
+		return false
+	}
+
+	reason := e.Result
+	json.Unmarshal([]byte(e.Result), &reason)
+	for _, reject := range txPoolRejectKinds {
+		if reject.kind == target && reject.reason.MatchString(reason) {
+			return true
+		}
+	}
+	return false
+}
+
+//temporary 是否可重试的错误
+func (e *RpcError) temporary() bool {
+	return e.Code == ErrCodeServiceCeiling
+}
+
+//NodeError 节点访问失败，包含网络错误和无法解析的响应
+type NodeError struct {
+	Addr string
+	Err  error
+}
+
+func (e *NodeError) Error() string {
+	return fmt.Sprintf("rpc node %s error:%v", e.Addr, e.Err)
+}
+
+func (e *NodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *NodeError) Is(target error) bool {
+	return target == ErrNodeUnavailable
+}
+
+//BalanceError 部分地址余额查询失败，Errors 按地址记录失败原因
+type BalanceError struct {
+	Errors map[string]error
+}
+
+func (e *BalanceError) Error() string {
+	addresses := make([]string, 0, len(e.Errors))
+	for address := range e.Errors {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	msgs := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		msgs = append(msgs, fmt.Sprintf("[%s] %v", address, e.Errors[address]))
+	}
+	return "get address balance failed: " + strings.Join(msgs, "; ")
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+
+	"github.com/blocktree/go-owcdrivers/ontologyTransaction"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const (
+	DefaultGasLimitMargin = 20 //预执行估算gasLimit时默认增加的百分比
+
+	nativeTransferFromMethod = "transferFromV2" //V2交易提取ONG的方法，数量精度与transferV2一致
+)
+
+//gasFeeInONG gas费用换算为getbalancev2返回的ONG精度，gasPrice×gasLimit的单位是1e-9 ONG，ONG余额有18位小数
+func gasFeeInONG(gasPrice, gasLimit uint64) *big.Int {
+	fee := new(big.Int).Mul(new(big.Int).SetUint64(gasPrice), new(big.Int).SetUint64(gasLimit))
+	return fee.Mul(fee, big.NewInt(1000000000))
+}
+
+//estimateGasLimit 预执行未签名的交易估算gasLimit，配置了gasLimit时直接使用配置。
+//节点至少收取 DefaultGasLimit 的gas，消耗不超过该值时不再增加余量
+func (decoder *TransactionDecoder) estimateGasLimit(payer string, code []byte) (uint64, error) {
+	if decoder.wm.Config.GasLimit != 0 {
+		return decoder.wm.Config.GasLimit, nil
+	}
+
+	tx, err := newInvokeTransaction(0, preExecGasLimit, payer, code)
+	if err != nil {
+		return 0, err
+	}
+	ret, err := decoder.wm.RPCClient.preExecTransaction(tx.emptyTransHex())
+	if err != nil {
+		return 0, fmt.Errorf("estimate gas limit failed: %w", err)
+	}
+
+	if ret.Gas <= ontologyTransaction.DefaultGasLimit {
+		return ontologyTransaction.DefaultGasLimit, nil
+	}
+	return ret.Gas + ret.Gas*decoder.wm.Config.GasLimitMargin/100, nil
+}
+
+//estimateInvokeGas 估算调用合约交易的gasLimit，比创建时检查手续费用的gasLimit多时重新检查payer的ONG
+func (decoder *TransactionDecoder) estimateInvokeGas(tx *invokeTransaction) error {
+	payer, err := scriptHashToAddress(hex.EncodeToString(tx.Payer))
+	if err != nil {
+		return err
+	}
+
+	gasLimit, err := decoder.estimateGasLimit(payer, tx.Code)
+	if err != nil {
+		return err
+	}
+	if gasLimit > tx.GasLimit {
+		if err := decoder.checkFeeBalance(payer, gasFeeInONG(tx.GasPrice, gasLimit), big.NewInt(0)); err != nil {
+			return err
+		}
+	}
+	tx.GasLimit = gasLimit
+	return nil
+}
+
+//estimateTxStateGas 估算ontologyTransaction创建的ONT、ONG交易的gasLimit，
+//gasLimit增加时，提取ONG和sweep为true的汇总ONG的数量扣除增加的手续费，其他交易重新检查payer的ONG
+func (decoder *TransactionDecoder) estimateTxStateGas(txState *ontologyTransaction.TxStateV2, gasPrice, gasLimit uint64, sweep bool) (uint64, error) {
+	payer := txState.Payer
+	if payer == "" {
+		payer = txState.From
+	}
+
+	code, err := txStateCode(txState)
+	if err != nil {
+		return 0, err
+	}
+	estimated, err := decoder.estimateGasLimit(payer, code)
+	if err != nil {
+		return 0, err
+	}
+	if estimated <= gasLimit {
+		return estimated, nil
+	}
+
+	fee := gasFeeInONG(gasPrice, estimated)
+	switch {
+	case txState.AssetType == ontologyTransaction.AssetONGWithdraw && payer == txState.From:
+		extra := big.NewInt(int64((estimated - gasLimit) * gasPrice))
+		if txState.Amount.Cmp(extra) <= 0 {
+			return 0, fmt.Errorf("Unbound ONG is not enough to withdraw in address : " + txState.From)
+		}
+		txState.Amount = new(big.Int).Sub(txState.Amount, extra)
+	case txState.AssetType == ontologyTransaction.AssetONG && payer == txState.From && sweep:
+		extra := gasFeeInONG(gasPrice, estimated-gasLimit)
+		if txState.Amount.Cmp(extra) <= 0 {
+			return 0, openwallet.Errorf(openwallet.ErrInsufficientFees, "No enough ONG to pay the fee on address: %s", payer)
+		}
+		txState.Amount = new(big.Int).Sub(txState.Amount, extra)
+	case txState.AssetType == ontologyTransaction.AssetONG && payer == txState.From:
+		if err := decoder.checkFeeBalance(payer, fee, txState.Amount); err != nil {
+			return 0, err
+		}
+	default:
+		if err := decoder.checkFeeBalance(payer, fee, big.NewInt(0)); err != nil {
+			return 0, err
+		}
+	}
+	return estimated, nil
+}
+
+//checkFeeBalance 检查payer的ONG是否足够支付手续费和同一交易中转出的ONG，fee和spend都是ONG余额的精度
+func (decoder *TransactionDecoder) checkFeeBalance(payer string, fee, spend *big.Int) error {
+	balance, err := decoder.wm.RPCClient.getBalance(payer)
+	if err != nil {
+		return err
+	}
+	if balance.ONGBalance.Cmp(new(big.Int).Add(fee, spend)) < 0 {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "No enough ONG to pay the fee on address: %s", payer)
+	}
+	return nil
+}
+
+//txStateCode 与ontologyTransaction创建的交易等价的调用脚本，用于预执行估算gas
+func txStateCode(txState *ontologyTransaction.TxStateV2) ([]byte, error) {
+	switch txState.AssetType {
+	case ontologyTransaction.AssetONT:
+		return buildNativeTransferCode(ontologyTransaction.ONTContractAddress, nativeTransferState{From: txState.From, To: txState.To, Amount: txState.Amount})
+	case ontologyTransaction.AssetONG:
+		return buildNativeTransferCode(ontologyTransaction.ONGContractAddress, nativeTransferState{From: txState.From, To: txState.To, Amount: txState.Amount})
+	case ontologyTransaction.AssetONGWithdraw:
+		sender, err := addressToScriptHash(txState.From)
+		if err != nil {
+			return nil, err
+		}
+		ontContract, err := contractAddressBytes(ontologyTransaction.ONTContractAddress)
+		if err != nil {
+			return nil, err
+		}
+		to, err := addressToScriptHash(txState.To)
+		if err != nil {
+			return nil, err
+		}
+		return buildNativeInvokeCode(ontologyTransaction.ONGContractAddress, 0, nativeTransferFromMethod, neoVMStruct{sender, ontContract, to, txState.Amount})
+	}
+	return nil, fmt.Errorf("unknown asset type: %d", txState.AssetType)
+}
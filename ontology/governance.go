@@ -0,0 +1,164 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const (
+	GovernanceContractAddress = "0700000000000000000000000000000000000000" //治理合约地址
+
+	governanceAuthorizeForPeer   = "authorizeForPeer"
+	governanceUnAuthorizeForPeer = "unAuthorizeForPeer"
+	governanceWithdraw           = "withdraw"
+	governanceWithdrawFee        = "withdrawFee"
+)
+
+//GovernanceStake 向共识节点质押、取消质押或提取ONT的参数，PeerPubkeys与Amounts一一对应
+type GovernanceStake struct {
+	Address     string   //质押的地址，同时是交易的签名地址
+	PeerPubkeys []string //共识节点hex编码的公钥
+	Amounts     []uint64 //每个节点的ONT数量
+}
+
+//params 治理合约的参数结构：地址、节点公钥列表、数量列表
+func (stake *GovernanceStake) params() (neoVMStruct, *big.Int, error) {
+	if stake == nil || len(stake.PeerPubkeys) == 0 {
+		return nil, nil, fmt.Errorf("peer public keys of governance stake are empty")
+	}
+	if len(stake.PeerPubkeys) != len(stake.Amounts) {
+		return nil, nil, fmt.Errorf("the number of peer public keys and amounts are not equal")
+	}
+
+	address, err := addressToScriptHash(stake.Address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		peers   = make([]interface{}, 0, len(stake.PeerPubkeys))
+		amounts = make([]interface{}, 0, len(stake.Amounts))
+		total   = big.NewInt(0)
+	)
+	for i, peer := range stake.PeerPubkeys {
+		if pub, err := hex.DecodeString(peer); err != nil || len(pub) != 33 {
+			return nil, nil, fmt.Errorf("invalid peer public key: %s", peer)
+		}
+		if stake.Amounts[i] == 0 || stake.Amounts[i] > math.MaxUint32 {
+			return nil, nil, fmt.Errorf("invalid amount %d of peer: %s", stake.Amounts[i], peer)
+		}
+		//节点公钥在合约中是hex字符串
+		peers = append(peers, []byte(peer))
+		amount := new(big.Int).SetUint64(stake.Amounts[i])
+		amounts = append(amounts, amount)
+		total.Add(total, amount)
+	}
+	return neoVMStruct{address, peers, amounts}, total, nil
+}
+
+//CreateAuthorizeForPeerRawTransaction 创建向共识节点质押ONT的交易，质押地址需要有足够的ONT
+func (decoder *TransactionDecoder) CreateAuthorizeForPeerRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, stake *GovernanceStake) error {
+	params, total, err := stake.params()
+	if err != nil {
+		return err
+	}
+
+	balance, err := decoder.wm.RPCClient.getBalance(stake.Address)
+	if err != nil {
+		return err
+	}
+	//余额的精度为ONTBalanceDecimals，质押数量为整数ONT
+	need := new(big.Int).Mul(total, new(big.Int).Exp(big.NewInt(10), big.NewInt(ONTBalanceDecimals), nil))
+	if balance.ONTBalance.Cmp(need) < 0 {
+		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "No enough ONT to authorize on address: %s", stake.Address)
+	}
+
+	return decoder.createGovernanceRawTransaction(wrapper, rawTx, stake.Address, governanceAuthorizeForPeer, params, total.String())
+}
+
+//CreateUnAuthorizeForPeerRawTransaction 创建取消质押的交易，取消的ONT在解锁后通过withdraw提取
+func (decoder *TransactionDecoder) CreateUnAuthorizeForPeerRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, stake *GovernanceStake) error {
+	params, total, err := stake.params()
+	if err != nil {
+		return err
+	}
+	return decoder.createGovernanceRawTransaction(wrapper, rawTx, stake.Address, governanceUnAuthorizeForPeer, params, total.String())
+}
+
+//CreateGovernanceWithdrawRawTransaction 创建提取已解锁的质押ONT的交易
+func (decoder *TransactionDecoder) CreateGovernanceWithdrawRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, stake *GovernanceStake) error {
+	params, total, err := stake.params()
+	if err != nil {
+		return err
+	}
+	return decoder.createGovernanceRawTransaction(wrapper, rawTx, stake.Address, governanceWithdraw, params, total.String())
+}
+
+//CreateWithdrawFeeRawTransaction 创建提取质押分红ONG的交易
+func (decoder *TransactionDecoder) CreateWithdrawFeeRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, address string) error {
+	hash, err := addressToScriptHash(address)
+	if err != nil {
+		return err
+	}
+	return decoder.createGovernanceRawTransaction(wrapper, rawTx, address, governanceWithdrawFee, neoVMStruct{hash}, "0")
+}
+
+//createGovernanceRawTransaction 创建调用治理合约的交易，caller需要签名，
+//rawTx.ExtParam 没有指定手续费支付地址时由caller支付
+func (decoder *TransactionDecoder) createGovernanceRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, caller, method string, params neoVMStruct, amount string) error {
+	code, err := buildNativeInvokeCode(GovernanceContractAddress, 0, method, params)
+	if err != nil {
+		return err
+	}
+
+	gasPrice, gasLimit, err := decoder.gasSettings(rawTx)
+	if err != nil {
+		return err
+	}
+	fee := gasFeeInONG(gasPrice, gasLimit)
+
+	payer, err := decoder.feePayer(wrapper, rawTx, fee)
+	if err != nil {
+		return err
+	}
+	if payer == "" {
+		payer = caller
+		if err := decoder.checkFeeBalance(payer, fee, big.NewInt(0)); err != nil {
+			return err
+		}
+	}
+
+	tx, err := newInvokeTransaction(gasPrice, gasLimit, payer, code)
+	if err != nil {
+		return err
+	}
+
+	if err := decoder.estimateInvokeGas(tx); err != nil {
+		return err
+	}
+
+	rawTx.TxFrom = []string{caller}
+	rawTx.TxTo = []string{governanceAddress}
+	rawTx.TxAmount = amount
+
+	return decoder.buildInvokeRawTransaction(wrapper, rawTx, tx, caller)
+}
@@ -0,0 +1,319 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+)
+
+//治理合约存储的key前缀
+const (
+	governanceViewKey      = "governanceView"
+	governancePeerPoolKey  = "peerPool"
+	governanceAuthorizeKey = "voteInfoPool"
+	governanceSplitFeeKey  = "splitFeeAddress"
+)
+
+//PeerPoolItem 共识节点信息，数量为ONT
+type PeerPoolItem struct {
+	Index      uint32 `json:"index"`
+	PeerPubkey string `json:"peerPubkey"`
+	Address    string `json:"address"` //节点所有者地址
+	Status     uint8  `json:"status"`
+	InitPos    uint64 `json:"initPos"`  //节点所有者质押的ONT
+	TotalPos   uint64 `json:"totalPos"` //用户授权质押的ONT
+}
+
+//AuthorizeInfo 地址在一个共识节点的质押信息，数量为ONT
+type AuthorizeInfo struct {
+	PeerPubkey          string `json:"peerPubkey"`
+	Address             string `json:"address"`
+	ConsensusPos        uint64 `json:"consensusPos"`        //共识节点中生效的质押
+	FreezePos           uint64 `json:"freezePos"`           //候选节点中生效的质押
+	NewPos              uint64 `json:"newPos"`              //下一轮生效的质押
+	WithdrawPos         uint64 `json:"withdrawPos"`         //取消的质押，下一轮冻结
+	WithdrawFreezePos   uint64 `json:"withdrawFreezePos"`   //取消的质押，冻结中
+	WithdrawUnfreezePos uint64 `json:"withdrawUnfreezePos"` //已解冻，可以通过withdraw提取
+}
+
+//Staked 质押中的ONT
+func (info *AuthorizeInfo) Staked() uint64 {
+	return info.ConsensusPos + info.FreezePos + info.NewPos
+}
+
+//PendingWithdraw 已取消质押但还没有解冻的ONT
+func (info *AuthorizeInfo) PendingWithdraw() uint64 {
+	return info.WithdrawPos + info.WithdrawFreezePos
+}
+
+//StakePosition 地址在所有共识节点的质押汇总
+type StakePosition struct {
+	Address         string           `json:"address"`
+	Authorizes      []*AuthorizeInfo `json:"authorizes"`      //有质押记录的节点，按节点序号排列
+	Staked          uint64           `json:"staked"`          //质押中的ONT
+	PendingWithdraw uint64           `json:"pendingWithdraw"` //等待解冻的ONT
+	Withdrawable    uint64           `json:"withdrawable"`    //可以提取的ONT
+	Rewards         uint64           `json:"rewards"`         //可以通过withdrawFee提取的ONG分红，最小单位
+}
+
+//GetGovernanceView 当前的共识轮次
+func (wm *WalletManager) GetGovernanceView() (uint32, error) {
+	data, err := wm.getGovernanceStorage([]byte(governanceViewKey))
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 4 {
+		return 0, fmt.Errorf("invalid governance view: %x", data)
+	}
+	return binary.LittleEndian.Uint32(data), nil
+}
+
+//GetPeerPoolMap 当前轮次的共识节点，key为节点公钥
+func (wm *WalletManager) GetPeerPoolMap() (map[string]*PeerPoolItem, error) {
+	view, err := wm.GetGovernanceView()
+	if err != nil {
+		return nil, err
+	}
+
+	viewBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(viewBytes, view)
+	data, err := wm.getGovernanceStorage([]byte(governancePeerPoolKey), viewBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	peers, err := decodePeerPoolMap(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode peer pool of view %d failed: %w", view, err)
+	}
+	return peers, nil
+}
+
+//GetAuthorizeInfo 地址在共识节点的质押信息，没有质押时数量都为0
+func (wm *WalletManager) GetAuthorizeInfo(peerPubkey, address string) (*AuthorizeInfo, error) {
+	peer, err := hex.DecodeString(peerPubkey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer public key: %s", peerPubkey)
+	}
+	hash, err := addressToScriptHash(address)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := wm.getGovernanceStorage([]byte(governanceAuthorizeKey), peer, hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return &AuthorizeInfo{PeerPubkey: peerPubkey, Address: address}, nil
+	}
+
+	info, err := decodeAuthorizeInfo(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode authorize info of %s failed: %w", address, err)
+	}
+	return info, nil
+}
+
+//GetSplitFee 地址可以提取的ONG分红，最小单位
+func (wm *WalletManager) GetSplitFee(address string) (uint64, error) {
+	hash, err := addressToScriptHash(address)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := wm.getGovernanceStorage([]byte(governanceSplitFeeKey), hash)
+	if err != nil || len(data) == 0 {
+		return 0, err
+	}
+
+	r := bytes.NewReader(data)
+	if _, err := readStorageAddress(r); err != nil {
+		return 0, fmt.Errorf("decode split fee of %s failed: %w", address, err)
+	}
+	amount, err := readStorageInt(r)
+	if err != nil {
+		return 0, fmt.Errorf("decode split fee of %s failed: %w", address, err)
+	}
+	return amount, nil
+}
+
+//GetStakePosition 查询地址在当前所有共识节点的质押、待提取的ONT和可以提取的ONG分红
+func (wm *WalletManager) GetStakePosition(address string) (*StakePosition, error) {
+	peers, err := wm.GetPeerPoolMap()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*PeerPoolItem, 0, len(peers))
+	for _, peer := range peers {
+		list = append(list, peer)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Index < list[j].Index
+	})
+
+	position := &StakePosition{Address: address, Authorizes: make([]*AuthorizeInfo, 0)}
+	for _, peer := range list {
+		info, err := wm.GetAuthorizeInfo(peer.PeerPubkey, address)
+		if err != nil {
+			return nil, err
+		}
+		if info.Staked()+info.PendingWithdraw()+info.WithdrawUnfreezePos == 0 {
+			continue
+		}
+		position.Authorizes = append(position.Authorizes, info)
+		position.Staked += info.Staked()
+		position.PendingWithdraw += info.PendingWithdraw()
+		position.Withdrawable += info.WithdrawUnfreezePos
+	}
+
+	position.Rewards, err = wm.GetSplitFee(address)
+	if err != nil {
+		return nil, err
+	}
+	return position, nil
+}
+
+//getGovernanceStorage 按治理合约的key规则拼接key并查询存储
+func (wm *WalletManager) getGovernanceStorage(parts ...[]byte) ([]byte, error) {
+	return wm.RPCClient.getStorage(GovernanceContractAddress, hex.EncodeToString(bytes.Join(parts, nil)))
+}
+
+//decodePeerPoolMap 节点数量后按公钥顺序排列的节点信息
+func decodePeerPoolMap(data []byte) (map[string]*PeerPoolItem, error) {
+	r := bytes.NewReader(data)
+	n, err := readStorageInt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make(map[string]*PeerPoolItem)
+	for i := uint64(0); i < n; i++ {
+		item := &PeerPoolItem{}
+		index, err := readStorageInt(r)
+		if err != nil {
+			return nil, err
+		}
+		item.Index = uint32(index)
+		if item.PeerPubkey, err = readStorageString(r); err != nil {
+			return nil, err
+		}
+		if item.Address, err = readStorageAddress(r); err != nil {
+			return nil, err
+		}
+		if item.Status, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+		if item.InitPos, err = readStorageInt(r); err != nil {
+			return nil, err
+		}
+		if item.TotalPos, err = readStorageInt(r); err != nil {
+			return nil, err
+		}
+		peers[item.PeerPubkey] = item
+	}
+	return peers, nil
+}
+
+func decodeAuthorizeInfo(data []byte) (*AuthorizeInfo, error) {
+	var (
+		r    = bytes.NewReader(data)
+		info = &AuthorizeInfo{}
+		err  error
+	)
+	if info.PeerPubkey, err = readStorageString(r); err != nil {
+		return nil, err
+	}
+	if info.Address, err = readStorageAddress(r); err != nil {
+		return nil, err
+	}
+	for _, pos := range []*uint64{&info.ConsensusPos, &info.FreezePos, &info.NewPos, &info.WithdrawPos, &info.WithdrawFreezePos, &info.WithdrawUnfreezePos} {
+		if *pos, err = readStorageInt(r); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+func readStorageVarUint(r *bytes.Reader) (uint64, error) {
+	prefix, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	var size int
+	switch prefix {
+	case 0xFD:
+		size = 2
+	case 0xFE:
+		size = 4
+	case 0xFF:
+		size = 8
+	default:
+		return uint64(prefix), nil
+	}
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf[:size]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+func readStorageVarBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := readStorageVarUint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func readStorageString(r *bytes.Reader) (string, error) {
+	data, err := readStorageVarBytes(r)
+	return string(data), err
+}
+
+func readStorageAddress(r *bytes.Reader) (string, error) {
+	data, err := readStorageVarBytes(r)
+	if err != nil {
+		return "", err
+	}
+	return scriptHashToAddress(hex.EncodeToString(data))
+}
+
+//readStorageInt 治理合约的整数以变长字节的NEOVM整数保存
+func readStorageInt(r *bytes.Reader) (uint64, error) {
+	data, err := readStorageVarBytes(r)
+	if err != nil {
+		return 0, err
+	}
+	n := neoVMBytesToInt(data)
+	if n.Sign() < 0 || !n.IsUint64() {
+		return 0, fmt.Errorf("invalid unsigned integer: %x", data)
+	}
+	return n.Uint64(), nil
+}
@@ -0,0 +1,210 @@
+package ontology
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const testPeerPubkey = "02bcdd278a27e4969d48de95d6b7b086b65b8d1d4ff6509e7a9eab364a76115af7"
+
+func TestGovernanceStake_Code(t *testing.T) {
+	stake := &GovernanceStake{Address: testAddress1, PeerPubkeys: []string{testPeerPubkey}, Amounts: []uint64{500}}
+	params, total, err := stake.params()
+	if err != nil {
+		t.Fatalf("params failed unexpected error: %v", err)
+	}
+	if total.Int64() != 500 {
+		t.Errorf("total = %s, want 500", total)
+	}
+
+	code, err := buildNativeInvokeCode(GovernanceContractAddress, 0, governanceAuthorizeForPeer, params)
+	if err != nil {
+		t.Fatalf("buildNativeInvokeCode failed unexpected error: %v", err)
+	}
+	want := "00c66b" + "14" + "0000000000000000000000000000000000000001" + "6a7cc8" +
+		"42" + hex.EncodeToString([]byte(testPeerPubkey)) + "51c1" + "6a7cc8" +
+		"02f401" + "51c1" + "6a7cc8" + "6c" +
+		"10" + hex.EncodeToString([]byte("authorizeForPeer")) +
+		"14" + "0000000000000000000000000000000000000007" +
+		"00" + "68" + "16" + hex.EncodeToString([]byte("Ontology.Native.Invoke"))
+	if hex.EncodeToString(code) != want {
+		t.Errorf("authorizeForPeer code = %x, want %s", code, want)
+	}
+
+	invalid := []*GovernanceStake{
+		{Address: testAddress1},
+		{Address: testAddress1, PeerPubkeys: []string{testPeerPubkey}, Amounts: []uint64{1, 2}},
+		{Address: testAddress1, PeerPubkeys: []string{"abcd"}, Amounts: []uint64{1}},
+		{Address: testAddress1, PeerPubkeys: []string{testPeerPubkey}, Amounts: []uint64{0}},
+		{Address: "bad", PeerPubkeys: []string{testPeerPubkey}, Amounts: []uint64{1}},
+	}
+	for _, stake := range invalid {
+		if _, _, err := stake.params(); err == nil {
+			t.Errorf("params of %+v should fail", stake)
+		}
+	}
+}
+
+func TestTransactionDecoder_CreateGovernanceRawTransaction(t *testing.T) {
+	//1000 ONT
+	node := newTestNode(func(method string, params []interface{}) interface{} {
+		switch method {
+		case "getbalancev2":
+			return map[string]string{"ont": "1000000000000", "ong": "2000000000000000000"}
+		case "getunboundong":
+			return "0"
+		}
+		return nil
+	})
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.GasPriceFixed = 2500
+	wm.Config.GasLimit = 20000
+	decoder := wm.TxDecoder.(*TransactionDecoder)
+
+	wrapper := newTestWalletDAI("account", testAddress1)
+	stake := &GovernanceStake{Address: testAddress1, PeerPubkeys: []string{testPeerPubkey}, Amounts: []uint64{500}}
+	create := map[string]func(rawTx *openwallet.RawTransaction) error{
+		governanceAuthorizeForPeer: func(rawTx *openwallet.RawTransaction) error {
+			return decoder.CreateAuthorizeForPeerRawTransaction(wrapper, rawTx, stake)
+		},
+		governanceUnAuthorizeForPeer: func(rawTx *openwallet.RawTransaction) error {
+			return decoder.CreateUnAuthorizeForPeerRawTransaction(wrapper, rawTx, stake)
+		},
+		governanceWithdraw: func(rawTx *openwallet.RawTransaction) error {
+			return decoder.CreateGovernanceWithdrawRawTransaction(wrapper, rawTx, stake)
+		},
+		governanceWithdrawFee: func(rawTx *openwallet.RawTransaction) error {
+			return decoder.CreateWithdrawFeeRawTransaction(wrapper, rawTx, testAddress1)
+		},
+	}
+	for method, fn := range create {
+		rawTx := &openwallet.RawTransaction{Account: &openwallet.AssetsAccount{AccountID: "account"}}
+		if err := fn(rawTx); err != nil {
+			t.Fatalf("%s failed unexpected error: %v", method, err)
+		}
+		if !rawTx.IsBuilt || rawTx.TxFrom[0] != testAddress1 || rawTx.TxTo[0] != governanceAddress || rawTx.Fees != "0.05" {
+			t.Errorf("%s: unexpected raw transaction: %+v", method, rawTx)
+		}
+		if sigs := rawTx.Signatures["account"]; len(sigs) != 1 || sigs[0].Address.Address != testAddress1 {
+			t.Errorf("%s: unexpected signatures: %+v", method, rawTx.Signatures)
+		}
+		if !strings.Contains(rawTx.RawHex, hex.EncodeToString([]byte(method))) {
+			t.Errorf("%s: unexpected raw hex: %s", method, rawTx.RawHex)
+		}
+	}
+
+	//质押数量超过ONT余额
+	rawTx := &openwallet.RawTransaction{Account: &openwallet.AssetsAccount{AccountID: "account"}}
+	err := decoder.CreateAuthorizeForPeerRawTransaction(wrapper, rawTx, &GovernanceStake{Address: testAddress1, PeerPubkeys: []string{testPeerPubkey}, Amounts: []uint64{1001}})
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientBalanceOfAccount {
+		t.Errorf("CreateAuthorizeForPeerRawTransaction error = %v, want insufficient balance", err)
+	}
+
+	//ONG只够gasLimit×gasPrice个最小单位，不够0.05 ONG手续费
+	poor := newTestONGNode(map[string]string{testAddress1: "50000000"})
+	defer poor.Close()
+	wm.RPCClient = NewRpcClient(poor.URL)
+	wm.RPCClient.SetRetry(0, 0)
+	err = decoder.CreateWithdrawFeeRawTransaction(wrapper, &openwallet.RawTransaction{Account: &openwallet.AssetsAccount{AccountID: "account"}}, testAddress1)
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientFees {
+		t.Errorf("CreateWithdrawFeeRawTransaction error = %v, want insufficient fees", err)
+	}
+}
+
+//newTestStorageNode 按key返回治理合约的存储
+func newTestStorageNode(storage map[string][]byte) *httptest.Server {
+	return newTestNode(func(method string, params []interface{}) interface{} {
+		if method == "getstorage" && params[0] == GovernanceContractAddress {
+			if value, ok := storage[params[1].(string)]; ok {
+				return hex.EncodeToString(value)
+			}
+		}
+		return nil
+	})
+}
+
+func writeTestStorageInt(buf *bytes.Buffer, n uint64) {
+	writeVarBytes(buf, neoVMIntToBytes(new(big.Int).SetUint64(n)))
+}
+
+func TestWalletManager_GetStakePosition(t *testing.T) {
+	const otherPeerPubkey = "03aa5d9fcd7ad0ee8c27f7d1d2ad1e9d44e9c8f8c0e0fb4e5d3f24d6fa7c4e3b21"
+	address1, _ := addressToScriptHash(testAddress1)
+	address7, _ := addressToScriptHash(testAddress7)
+
+	view := &bytes.Buffer{}
+	binary.Write(view, binary.LittleEndian, uint32(5))
+	binary.Write(view, binary.LittleEndian, uint32(100))
+	view.Write(make([]byte, 32))
+
+	peerPool := &bytes.Buffer{}
+	writeTestStorageInt(peerPool, 2)
+	for i, peer := range []string{testPeerPubkey, otherPeerPubkey} {
+		writeTestStorageInt(peerPool, uint64(i+1))
+		writeVarBytes(peerPool, []byte(peer))
+		writeVarBytes(peerPool, address7)
+		peerPool.WriteByte(2)
+		writeTestStorageInt(peerPool, 10000)
+		writeTestStorageInt(peerPool, 200000)
+	}
+
+	authorize := &bytes.Buffer{}
+	writeVarBytes(authorize, []byte(testPeerPubkey))
+	writeVarBytes(authorize, address1)
+	for _, pos := range []uint64{500, 0, 100, 30, 20, 1000} {
+		writeTestStorageInt(authorize, pos)
+	}
+
+	splitFee := &bytes.Buffer{}
+	writeVarBytes(splitFee, address1)
+	writeTestStorageInt(splitFee, 123456789)
+
+	pub, _ := hex.DecodeString(testPeerPubkey)
+	node := newTestStorageNode(map[string][]byte{
+		hex.EncodeToString([]byte(governanceViewKey)):                                           view.Bytes(),
+		hex.EncodeToString(append([]byte(governancePeerPoolKey), 5, 0, 0, 0)):                   peerPool.Bytes(),
+		hex.EncodeToString(append(append([]byte(governanceAuthorizeKey), pub...), address1...)): authorize.Bytes(),
+		hex.EncodeToString(append([]byte(governanceSplitFeeKey), address1...)):                  splitFee.Bytes(),
+	})
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+
+	peers, err := wm.GetPeerPoolMap()
+	if err != nil {
+		t.Fatalf("GetPeerPoolMap failed unexpected error: %v", err)
+	}
+	peer := peers[otherPeerPubkey]
+	if len(peers) != 2 || peer == nil || peer.Index != 2 || peer.Address != testAddress7 || peer.Status != 2 || peer.InitPos != 10000 || peer.TotalPos != 200000 {
+		t.Fatalf("unexpected peer pool: %+v", peers)
+	}
+
+	position, err := wm.GetStakePosition(testAddress1)
+	if err != nil {
+		t.Fatalf("GetStakePosition failed unexpected error: %v", err)
+	}
+	if len(position.Authorizes) != 1 || position.Authorizes[0].PeerPubkey != testPeerPubkey || position.Authorizes[0].Address != testAddress1 {
+		t.Fatalf("unexpected authorizes: %+v", position.Authorizes)
+	}
+	if position.Staked != 600 || position.PendingWithdraw != 50 || position.Withdrawable != 1000 || position.Rewards != 123456789 {
+		t.Errorf("unexpected stake position: %+v", position)
+	}
+
+	//没有质押记录
+	info, err := wm.GetAuthorizeInfo(otherPeerPubkey, testAddress1)
+	if err != nil || info.Staked() != 0 || info.Address != testAddress1 {
+		t.Errorf("GetAuthorizeInfo = %+v, %v", info, err)
+	}
+	if fee, err := wm.GetSplitFee(testAddress7); err != nil || fee != 0 {
+		t.Errorf("GetSplitFee = %d, %v", fee, err)
+	}
+}
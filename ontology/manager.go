@@ -18,7 +18,6 @@ package ontology
 import (
 	"errors"
 	"path/filepath"
-	"strings"
 
 	"github.com/blocktree/openwallet/v2/hdkeystore"
 	"github.com/blocktree/openwallet/v2/log"
@@ -29,7 +28,7 @@ type WalletManager struct {
 	openwallet.AssetsAdapterBase
 
 	Storage         *hdkeystore.HDKeystore        //秘钥存取
-	RPCClient       *RpcClient                    // RPC API
+	RPCClient       NodeClient                    // RPC API
 	Config          *WalletConfig                 //钱包管理配置
 	WalletsInSum    map[string]*openwallet.Wallet //参与汇总的钱包
 	Blockscanner    *ONTBlockScanner              //区块扫描器
@@ -37,6 +36,7 @@ type WalletManager struct {
 	TxDecoder       openwallet.TransactionDecoder //交易单编码器
 	Log             *log.OWLogger                 //日志工具
 	ContractDecoder *ContractDecoder              //智能合约解析器
+	ABIRegistry     *ABIRegistry                  //合约ABI注册表
 }
 
 func NewWalletManager() *WalletManager {
@@ -51,6 +51,7 @@ func NewWalletManager() *WalletManager {
 	wm.Decoder = NewAddressDecoderV2(&wm)
 	wm.TxDecoder = NewTransactionDecoder(&wm)
 	wm.ContractDecoder = NewContractDecoder(&wm)
+	wm.ABIRegistry = NewABIRegistry()
 	wm.Log = log.NewOWLogger(Symbol)
 	//	wm.RPCClient = NewRpcClient("http://localhost:20336/")
 	return &wm
@@ -94,16 +95,5 @@ func (wm *WalletManager) GetWallets() ([]*openwallet.Wallet, error) {
 //SendRawTransaction 广播交易
 func (wm *WalletManager) SendRawTransaction(txHex string) (string, error) {
 
-	return wm.sendRawTransactionByRest(txHex)
-}
-
-func (wm *WalletManager) sendRawTransactionByRest(txHex string) (string, error) {
-	params := []interface{}{txHex}
-
-	txid, err := wm.RPCClient.sendRpcRequest("0", "sendrawtransaction", params)
-	if err != nil {
-		return "", err
-	}
-
-	return strings.Trim(string(txid), "\""), nil
+	return wm.RPCClient.sendRawTransaction(txHex)
 }
@@ -16,6 +16,7 @@
 package ontology
 
 import (
+	"encoding/hex"
 	"fmt"
 
 	"github.com/blocktree/openwallet/v2/crypto"
@@ -80,6 +81,7 @@ type Block struct {
 	Bookkeepers      []string
 	SigData          []string
 	Transactions     []string
+	TxHeaders        map[string]*Transaction //区块内交易的付款地址和gas，不含合约事件
 }
 
 type Notify struct {
@@ -91,6 +93,53 @@ type Notify struct {
 	Amount          string
 }
 
+//preExecResult 交易预执行结果，Result为合约的返回值
+type preExecResult struct {
+	State  int64
+	Gas    uint64
+	Result gjson.Result
+}
+
+func newPreExecResult(resp []byte) (*preExecResult, error) {
+	obj := gjson.ParseBytes(resp)
+	ret := &preExecResult{
+		State:  obj.Get("State").Int(),
+		Gas:    obj.Get("Gas").Uint(),
+		Result: obj.Get("Result"),
+	}
+	if ret.State != 1 {
+		return nil, fmt.Errorf("pre-execute state %d: %w", ret.State, ErrPreExecution)
+	}
+	return ret, nil
+}
+
+//decodeStorageValue 解析合约存储的hex值，不存在时节点返回null
+func decodeStorageValue(resp []byte) ([]byte, error) {
+	value := gjson.ParseBytes(resp).String()
+	if value == "" {
+		return nil, nil
+	}
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage value: %s", value)
+	}
+	return data, nil
+}
+
+//setEvent 设置交易的合约执行结果
+func (trx *Transaction) setEvent(event *smartCodeEvent) {
+	trx.Notifys = event.Notifys
+	trx.Events = event.Events
+	trx.Failed = event.State == txStateFailed
+	trx.GasConsumed = event.GasConsumed
+}
+
+//ContractEvent 合约事件，States为原始的JSON数组
+type ContractEvent struct {
+	ContractAddress string
+	States          string
+}
+
 type Transaction struct {
 	TxID        string `json:"txid"`
 	Version     uint64 `json:"version"`
@@ -100,6 +149,9 @@ type Transaction struct {
 	Payer       string `json:"payer"`
 	TxType      uint64 `json:"txtype"`
 	Notifys     []Notify
+	Events      []ContractEvent
+	Failed      bool   //合约执行失败，只扣除了手续费
+	GasConsumed uint64 //实际消耗的ONG，最小单位为1e-9
 	BlockHeight uint64 `json:"blockheight"`
 	BlockHash   string `json:"blockhash"`
 }
@@ -130,9 +182,15 @@ func NewBlock(json *gjson.Result) *Block {
 
 	txs := gjson.Get(json.Raw, "Transactions").Array()
 
+	obj.TxHeaders = make(map[string]*Transaction)
 	for _, tx := range txs {
 		if gjson.Get(tx.Raw, "TxType").Uint() == 209 {
 			obj.Transactions = append(obj.Transactions, gjson.Get(tx.Raw, "Hash").String())
+
+			header := newTransaction([]byte(tx.Raw))
+			header.BlockHeight = obj.Height
+			header.BlockHash = obj.Hash
+			obj.TxHeaders[header.TxID] = header
 		}
 	}
 
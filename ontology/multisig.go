@@ -0,0 +1,176 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/blocktree/go-owcdrivers/addressEncoder"
+	owcrypt "github.com/blocktree/go-owcrypt"
+	"github.com/tidwall/gjson"
+)
+
+//multiSigMaxPublicKeys 多重签名最多支持的公钥数量
+const multiSigMaxPublicKeys = 16
+
+//signatureSchemeSHA256withECDSA Ontology 签名数据的算法标识
+const signatureSchemeSHA256withECDSA = 0x01
+
+//MultiSig M-of-N 多重签名，PublicKeys为hex编码的压缩公钥，Required为需要的签名数。
+//创建交易时通过 rawTx.ExtParam 的 multiSig 字段传入
+type MultiSig struct {
+	PublicKeys []string `json:"publicKeys"`
+	Required   uint64   `json:"required"`
+}
+
+//multiSigFromExtParam 读取 rawTx.ExtParam 中的多重签名，没有时返回nil
+func multiSigFromExtParam(extParam string) (*MultiSig, error) {
+	if extParam == "" {
+		return nil, nil
+	}
+	result := gjson.Get(extParam, "multiSig")
+	if !result.Exists() {
+		return nil, nil
+	}
+
+	multiSig := &MultiSig{}
+	if err := json.Unmarshal([]byte(result.Raw), multiSig); err != nil {
+		return nil, fmt.Errorf("invalid multiSig of extParam: %w", err)
+	}
+	if _, err := multiSig.Program(); err != nil {
+		return nil, err
+	}
+	return multiSig, nil
+}
+
+//sortedPublicKeys 解析公钥并按Ontology的规则排序：先比较X坐标，相同时比较Y坐标
+func (ms *MultiSig) sortedPublicKeys() ([][]byte, error) {
+	pubs := make([][]byte, 0, len(ms.PublicKeys))
+	exist := make(map[string]bool)
+	for _, pubHex := range ms.PublicKeys {
+		pub, err := hex.DecodeString(pubHex)
+		if err != nil || len(pub) != 33 {
+			return nil, fmt.Errorf("invalid compressed public key: %s", pubHex)
+		}
+		if exist[string(pub)] {
+			return nil, fmt.Errorf("duplicate public key: %s", pubHex)
+		}
+		exist[string(pub)] = true
+		pubs = append(pubs, pub)
+	}
+
+	sort.SliceStable(pubs, func(i, j int) bool {
+		if c := bytes.Compare(pubs[i][1:], pubs[j][1:]); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(decompressPublicKey(pubs[i]), decompressPublicKey(pubs[j])) < 0
+	})
+	return pubs, nil
+}
+
+//Program 多重签名的验证脚本：PUSH M、按顺序压入公钥、PUSH N、CHECKMULTISIG
+func (ms *MultiSig) Program() ([]byte, error) {
+	n := len(ms.PublicKeys)
+	if n < 2 || n > multiSigMaxPublicKeys {
+		return nil, fmt.Errorf("the number of multisig public keys must be between 2 and %d", multiSigMaxPublicKeys)
+	}
+	if ms.Required < 1 || ms.Required > uint64(n) {
+		return nil, fmt.Errorf("the required signatures must be between 1 and %d", n)
+	}
+
+	pubs, err := ms.sortedPublicKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &neoVMScriptBuilder{}
+	b.emitPushInteger(new(big.Int).SetUint64(ms.Required))
+	for _, pub := range pubs {
+		b.emitPushBytes(pub)
+	}
+	b.emitPushInteger(big.NewInt(int64(n)))
+	b.emitOpCode(opCheckMultiSig)
+	return b.bytes(), nil
+}
+
+//Address 多重签名地址
+func (ms *MultiSig) Address() (string, error) {
+	program, err := ms.Program()
+	if err != nil {
+		return "", err
+	}
+	return programAddress(program), nil
+}
+
+//publicKeyProgram 单个公钥的验证脚本
+func publicKeyProgram(pub []byte) []byte {
+	b := &neoVMScriptBuilder{}
+	b.emitPushBytes(pub)
+	b.emitOpCode(opCheckSig)
+	return b.bytes()
+}
+
+//programAddress 验证脚本的地址
+func programAddress(program []byte) string {
+	hash := owcrypt.Hash(program, 0, owcrypt.HASH_ALG_HASH160)
+	return addressEncoder.AddressEncode(hash, addressEncoder.ONT_Address)
+}
+
+//decompressPublicKey 压缩公钥转为64字节的X、Y坐标
+func decompressPublicKey(pub []byte) []byte {
+	point := owcrypt.PointDecompress(pub, owcrypt.ECC_CURVE_SECP256R1)
+	if len(point) == 65 {
+		point = point[1:]
+	}
+	return point
+}
+
+//serializeSignature 签名数据加上算法标识
+func serializeSignature(sig []byte) []byte {
+	if len(sig) == 64 {
+		return append([]byte{signatureSchemeSHA256withECDSA}, sig...)
+	}
+	return sig
+}
+
+//verifySignature 验证交易哈希的签名，Ontology 对交易哈希再做一次sha256后签名
+func verifySignature(pub, txHash, sig []byte) bool {
+	if len(sig) == 65 && sig[0] == signatureSchemeSHA256withECDSA {
+		sig = sig[1:]
+	}
+	point := decompressPublicKey(pub)
+	if len(point) != 64 || len(sig) != 64 {
+		return false
+	}
+	digest := sha256.Sum256(txHash)
+	return owcrypt.Verify(point, nil, digest[:], sig, owcrypt.ECC_CURVE_SECP256R1) == owcrypt.SUCCESS
+}
+
+//writeSigEntry 写入交易的一个签名项，调用脚本按顺序压入签名，验证脚本为公钥或多重签名脚本
+func writeSigEntry(buf *bytes.Buffer, sigs [][]byte, program []byte) {
+	b := &neoVMScriptBuilder{}
+	for _, sig := range sigs {
+		b.emitPushBytes(serializeSignature(sig))
+	}
+	writeVarBytes(buf, b.bytes())
+	writeVarBytes(buf, program)
+}
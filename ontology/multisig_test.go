@@ -0,0 +1,217 @@
+package ontology
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//newTestKeys 生成 secp256r1 私钥和对应的hex压缩公钥
+func newTestKeys(t *testing.T, n int) ([]*ecdsa.PrivateKey, []string) {
+	keys := make([]*ecdsa.PrivateKey, 0, n)
+	pubs := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey failed unexpected error: %v", err)
+		}
+		keys = append(keys, key)
+		pubs = append(pubs, hex.EncodeToString(testCompressPublicKey(key.X, key.Y)))
+	}
+	return keys, pubs
+}
+
+//testCompressPublicKey 压缩公钥，go 1.13 的 elliptic 没有 MarshalCompressed
+func testCompressPublicKey(x, y *big.Int) []byte {
+	pub := make([]byte, 33)
+	pub[0] = byte(2 + y.Bit(0))
+	copy(pub[33-len(x.Bytes()):], x.Bytes())
+	return pub
+}
+
+//testSign 按Ontology的规则签名交易哈希，返回r||s
+func testSign(t *testing.T, key *ecdsa.PrivateKey, message string) string {
+	hash, _ := hex.DecodeString(message)
+	digest := sha256.Sum256(hash)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Sign failed unexpected error: %v", err)
+	}
+	sig := make([]byte, 64)
+	copy(sig[32-len(r.Bytes()):32], r.Bytes())
+	copy(sig[64-len(s.Bytes()):], s.Bytes())
+	return hex.EncodeToString(sig)
+}
+
+func TestMultiSig_KnownAddress(t *testing.T) {
+	//secp256r1 的 G、2G、3G
+	multiSig := &MultiSig{
+		PublicKeys: []string{
+			"036b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c296",
+			"037cf27b188d034f7e8a52380304b51ac3c08969e277f21b35a60b48fc47669978",
+			"025ecbe4d1a6330a44c8f7ef951d4bf165e6c6b721efada985fb41661bc6e7fd6c",
+		},
+		Required: 2,
+	}
+	program, err := multiSig.Program()
+	if err != nil {
+		t.Fatalf("Program failed unexpected error: %v", err)
+	}
+	wantProgram := "52" +
+		"21" + "025ecbe4d1a6330a44c8f7ef951d4bf165e6c6b721efada985fb41661bc6e7fd6c" +
+		"21" + "036b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c296" +
+		"21" + "037cf27b188d034f7e8a52380304b51ac3c08969e277f21b35a60b48fc47669978" +
+		"53" + "ae"
+	if hex.EncodeToString(program) != wantProgram {
+		t.Errorf("program = %x, want %s", program, wantProgram)
+	}
+	if address, err := multiSig.Address(); err != nil || address != "APkrHZ7uBfMcvBbRZvWTvLMPvGjKGjHxN3" {
+		t.Errorf("Address = %s, %v, want APkrHZ7uBfMcvBbRZvWTvLMPvGjKGjHxN3", address, err)
+	}
+}
+
+func TestMultiSig_Program(t *testing.T) {
+	_, pubs := newTestKeys(t, 3)
+
+	multiSig := &MultiSig{PublicKeys: pubs, Required: 2}
+	program, err := multiSig.Program()
+	if err != nil {
+		t.Fatalf("Program failed unexpected error: %v", err)
+	}
+
+	sorted, _ := multiSig.sortedPublicKeys()
+	want := "52"
+	for i, pub := range sorted {
+		if i > 0 && bytes.Compare(sorted[i-1][1:], pub[1:]) >= 0 {
+			t.Errorf("public keys are not sorted by X")
+		}
+		want += "21" + hex.EncodeToString(pub)
+	}
+	want += "53" + "ae"
+	if hex.EncodeToString(program) != want {
+		t.Errorf("program = %x, want %s", program, want)
+	}
+
+	//地址与公钥顺序无关
+	address, _ := multiSig.Address()
+	reversed := &MultiSig{PublicKeys: []string{pubs[2], pubs[1], pubs[0]}, Required: 2}
+	if other, _ := reversed.Address(); other != address {
+		t.Errorf("address depends on public key order: %s != %s", other, address)
+	}
+	pubBytes := make([][]byte, 0, len(pubs))
+	for _, pub := range pubs {
+		b, _ := hex.DecodeString(pub)
+		pubBytes = append(pubBytes, b)
+	}
+	if other, err := NewAddressDecoderV2(nil).RedeemScriptToAddress(pubBytes, 2, false); err != nil || other != address {
+		t.Errorf("RedeemScriptToAddress = %s, %v, want %s", other, err, address)
+	}
+	if other, _ := (&MultiSig{PublicKeys: pubs, Required: 3}).Address(); other == address {
+		t.Errorf("address should depend on required signatures")
+	}
+
+	invalid := []*MultiSig{
+		{PublicKeys: pubs, Required: 0},
+		{PublicKeys: pubs, Required: 4},
+		{PublicKeys: pubs[:1], Required: 1},
+		{PublicKeys: []string{pubs[0], pubs[0]}, Required: 1},
+		{PublicKeys: []string{pubs[0], "abcd"}, Required: 1},
+	}
+	for _, ms := range invalid {
+		if _, err := ms.Program(); err == nil {
+			t.Errorf("Program of %+v should fail", ms)
+		}
+	}
+}
+
+func TestTransactionDecoder_MultiSig(t *testing.T) {
+	node := newTestBalanceNode()
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.GasPriceFixed = 2500
+	wm.Config.GasLimit = 20000
+	decoder := wm.TxDecoder.(*TransactionDecoder)
+
+	keys, pubs := newTestKeys(t, 3)
+	multiSig := &MultiSig{PublicKeys: pubs, Required: 2}
+	multiSigAddress, _ := multiSig.Address()
+
+	//本钱包只有第一个签名者
+	pub0, _ := hex.DecodeString(pubs[0])
+	cosigner := programAddress(publicKeyProgram(pub0))
+	wrapper := newTestWalletDAI("account", cosigner)
+	wrapper.addresses[0].PublicKey = pubs[0]
+	wrapper.addresses[0].HDPath = "m/44'/1024'/0'/0/0"
+
+	rawTx := &openwallet.RawTransaction{
+		Coin:     openwallet.Coin{Symbol: "ONT", IsContract: true, Contract: openwallet.SmartContract{Address: "0100000000000000000000000000000000000000", Token: "ONT"}},
+		Account:  &openwallet.AssetsAccount{AccountID: "account"},
+		To:       map[string]string{testAddress7: "10"},
+		ExtParam: fmt.Sprintf(`{"multiSig":{"publicKeys":["%s"],"required":2}}`, strings.Join(pubs, `","`)),
+	}
+	if err := decoder.CreateONTRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateONTRawTransaction failed unexpected error: %v", err)
+	}
+	if rawTx.Required != 2 || len(rawTx.TxFrom) != 1 || rawTx.TxFrom[0] != multiSigAddress {
+		t.Fatalf("unexpected raw transaction: %+v", rawTx)
+	}
+	if len(rawTx.Signatures) != 3 || len(rawTx.Signatures["account"]) != 1 || rawTx.Signatures["account"][0].Address.HDPath == "" {
+		t.Fatalf("unexpected signatures: %+v", rawTx.Signatures)
+	}
+
+	keyByPub := make(map[string]*ecdsa.PrivateKey)
+	for i, pub := range pubs {
+		keyByPub[pub] = keys[i]
+	}
+	sign := func(pub string) {
+		for _, keySignatures := range rawTx.Signatures {
+			for _, keySignature := range keySignatures {
+				if keySignature.Address.PublicKey == pub {
+					keySignature.Signature = testSign(t, keyByPub[pub], keySignature.Message)
+				}
+			}
+		}
+	}
+
+	//签名数量不足
+	emptyTrans := rawTx.RawHex
+	sign(pubs[0])
+	if err := decoder.VerifyONTRawTransaction(wrapper, rawTx); err != nil || rawTx.IsCompleted {
+		t.Fatalf("VerifyONTRawTransaction = %v, completed: %v, want not completed", err, rawTx.IsCompleted)
+	}
+
+	//错误的签名不计入
+	for _, keySignature := range rawTx.Signatures[programAddress(publicKeyProgram(mustDecodeHex(pubs[1])))] {
+		keySignature.Signature = testSign(t, keys[2], keySignature.Message)
+	}
+	if err := decoder.VerifyONTRawTransaction(wrapper, rawTx); err != nil || rawTx.IsCompleted {
+		t.Fatalf("VerifyONTRawTransaction = %v, completed: %v, want not completed", err, rawTx.IsCompleted)
+	}
+
+	sign(pubs[2])
+	if err := decoder.VerifyONTRawTransaction(wrapper, rawTx); err != nil || !rawTx.IsCompleted {
+		t.Fatalf("VerifyONTRawTransaction = %v, completed: %v, want completed", err, rawTx.IsCompleted)
+	}
+
+	program, _ := multiSig.Program()
+	buf := &bytes.Buffer{}
+	writeVarBytes(buf, program)
+	if !strings.HasPrefix(rawTx.RawHex, emptyTrans[:len(emptyTrans)-2]+"01"+"84") || !strings.HasSuffix(rawTx.RawHex, hex.EncodeToString(buf.Bytes())) {
+		t.Errorf("unexpected signed transaction: %s", rawTx.RawHex)
+	}
+}
+
+func mustDecodeHex(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
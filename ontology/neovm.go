@@ -0,0 +1,413 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+//NEOVM 操作码
+const (
+	opPush0           = 0x00
+	opPushBytes       = 0x4B //0x01-0x4B 直接压入对应长度的字节
+	opPushData1       = 0x4C
+	opPushData2       = 0x4D
+	opPushData4       = 0x4E
+	opPushM1          = 0x4F
+	opPush1           = 0x51
+	opPushTrue        = 0x51
+	opPushFalse       = 0x00
+	opAppCall         = 0x67
+	opSysCall         = 0x68
+	opDupFromAltStack = 0x6A
+	opToAltStack      = 0x6B
+	opFromAltStack    = 0x6C
+	opSwap            = 0x7C
+	opCheckSig        = 0xAC
+	opCheckMultiSig   = 0xAE
+	opPack            = 0xC1
+	opNewStruct       = 0xC6
+	opAppend          = 0xC8
+)
+
+//nativeInvokeName 调用原生合约的系统调用
+const nativeInvokeName = "Ontology.Native.Invoke"
+
+//nativeTransferMethod 原生合约ONT、ONG的转账方法，与ontologyTransaction创建的V2交易一致
+const nativeTransferMethod = "transferV2"
+
+//NEOVM 合约参数类型
+const (
+	NeoVMParamAddress = "address" //base58地址，按20字节脚本哈希压栈
+	NeoVMParamInteger = "integer" //十进制整数
+	NeoVMParamBytes   = "bytes"   //hex编码的字节数组
+	NeoVMParamString  = "string"
+	NeoVMParamBool    = "bool"
+	NeoVMParamArray   = "array" //元素在Array中
+)
+
+//NeoVMParam NEOVM合约调用参数，数组类型使用Array，其他类型使用Value
+type NeoVMParam struct {
+	Type  string       `json:"type"`
+	Value string       `json:"value,omitempty"`
+	Array []NeoVMParam `json:"array,omitempty"`
+}
+
+//neoVMValue 转为脚本构造器支持的值
+func (p NeoVMParam) neoVMValue() (interface{}, error) {
+	switch p.Type {
+	case NeoVMParamAddress:
+		return addressToScriptHash(p.Value)
+	case NeoVMParamInteger:
+		n, ok := new(big.Int).SetString(p.Value, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid integer param: %s", p.Value)
+		}
+		return n, nil
+	case NeoVMParamBytes:
+		data, err := hex.DecodeString(p.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bytes param: %s", p.Value)
+		}
+		return data, nil
+	case NeoVMParamString:
+		return []byte(p.Value), nil
+	case NeoVMParamBool:
+		b, err := strconv.ParseBool(p.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool param: %s", p.Value)
+		}
+		return b, nil
+	case NeoVMParamArray:
+		list := make([]interface{}, 0, len(p.Array))
+		for _, item := range p.Array {
+			v, err := item.neoVMValue()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("unsupported neovm param type: %s", p.Type)
+}
+
+//Ontology 交易类型
+const (
+	txVersion    = 0x00
+	txTypeInvoke = 0xd1
+)
+
+//neoVMScriptBuilder NEOVM脚本构造器
+type neoVMScriptBuilder struct {
+	buf bytes.Buffer
+}
+
+func (b *neoVMScriptBuilder) emitOpCode(op byte) {
+	b.buf.WriteByte(op)
+}
+
+//emitPushBytes 压入字节数组
+func (b *neoVMScriptBuilder) emitPushBytes(data []byte) {
+	l := len(data)
+	switch {
+	case l <= opPushBytes:
+		b.buf.WriteByte(byte(l))
+	case l <= 0xFF:
+		b.buf.WriteByte(opPushData1)
+		b.buf.WriteByte(byte(l))
+	case l <= 0xFFFF:
+		b.buf.WriteByte(opPushData2)
+		binary.Write(&b.buf, binary.LittleEndian, uint16(l))
+	default:
+		b.buf.WriteByte(opPushData4)
+		binary.Write(&b.buf, binary.LittleEndian, uint32(l))
+	}
+	b.buf.Write(data)
+}
+
+//emitPushInteger 压入整数，-1到16使用对应的操作码
+func (b *neoVMScriptBuilder) emitPushInteger(n *big.Int) {
+	switch {
+	case n.Sign() == 0:
+		b.emitOpCode(opPush0)
+	case n.Cmp(big.NewInt(-1)) == 0:
+		b.emitOpCode(opPushM1)
+	case n.Sign() > 0 && n.Cmp(big.NewInt(16)) <= 0:
+		b.emitOpCode(opPush1 - 1 + byte(n.Int64()))
+	default:
+		b.emitPushBytes(neoVMIntToBytes(n))
+	}
+}
+
+//neoVMStruct 原生合约参数中的结构体，字段按顺序压栈
+type neoVMStruct []interface{}
+
+//emitParam 压入参数，数组元素逆序压栈后打包
+func (b *neoVMScriptBuilder) emitParam(param interface{}) error {
+	switch v := param.(type) {
+	case []byte:
+		b.emitPushBytes(v)
+	case *big.Int:
+		b.emitPushInteger(v)
+	case bool:
+		if v {
+			b.emitOpCode(opPushTrue)
+		} else {
+			b.emitOpCode(opPushFalse)
+		}
+	case []interface{}:
+		for i := len(v) - 1; i >= 0; i-- {
+			if err := b.emitParam(v[i]); err != nil {
+				return err
+			}
+		}
+		b.emitPushInteger(big.NewInt(int64(len(v))))
+		b.emitOpCode(opPack)
+	case neoVMStruct:
+		b.emitPushInteger(big.NewInt(0))
+		b.emitOpCode(opNewStruct)
+		b.emitOpCode(opToAltStack)
+		for _, field := range v {
+			if err := b.emitParam(field); err != nil {
+				return err
+			}
+			b.emitOpCode(opDupFromAltStack)
+			b.emitOpCode(opSwap)
+			b.emitOpCode(opAppend)
+		}
+		b.emitOpCode(opFromAltStack)
+	default:
+		return fmt.Errorf("unsupported neovm param type: %T", v)
+	}
+	return nil
+}
+
+//emitAppCall 调用NEOVM合约，contract为小端序的合约地址
+func (b *neoVMScriptBuilder) emitAppCall(contract []byte) {
+	b.emitOpCode(opAppCall)
+	b.buf.Write(contract)
+}
+
+func (b *neoVMScriptBuilder) bytes() []byte {
+	return b.buf.Bytes()
+}
+
+//neoVMIntToBytes 整数转为小端序补码
+func neoVMIntToBytes(n *big.Int) []byte {
+	if n.Sign() == 0 {
+		return []byte{}
+	}
+
+	var be []byte
+	if n.Sign() > 0 {
+		be = n.Bytes()
+		if be[0]&0x80 != 0 {
+			be = append([]byte{0}, be...)
+		}
+	} else {
+		//负数取补码
+		l := len(n.Bytes())
+		mod := new(big.Int).Lsh(big.NewInt(1), uint(l*8))
+		c := new(big.Int).Add(mod, n)
+		be = c.Bytes()
+		for len(be) < l {
+			be = append([]byte{0}, be...)
+		}
+		if be[0]&0x80 == 0 {
+			be = append([]byte{0xFF}, be...)
+		}
+	}
+
+	data := make([]byte, len(be))
+	for i, x := range be {
+		data[len(be)-1-i] = x
+	}
+	return data
+}
+
+//contractAddressBytes 合约地址hex转为交易中使用的小端序字节
+func contractAddressBytes(contractAddress string) ([]byte, error) {
+	data, err := hex.DecodeString(contractAddress)
+	if err != nil || len(data) != 20 {
+		return nil, fmt.Errorf("invalid contract address: %s", contractAddress)
+	}
+	for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+		data[i], data[j] = data[j], data[i]
+	}
+	return data, nil
+}
+
+//buildNeoVMInvokeCode 构造调用NEOVM合约方法的脚本，参数支持[]byte、*big.Int、bool和[]interface{}
+func buildNeoVMInvokeCode(contractAddress string, method string, params ...interface{}) ([]byte, error) {
+	contract, err := contractAddressBytes(contractAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &neoVMScriptBuilder{}
+	if err := b.emitParam(params); err != nil {
+		return nil, err
+	}
+	b.emitPushBytes([]byte(method))
+	b.emitAppCall(contract)
+
+	return b.bytes(), nil
+}
+
+//buildNativeInvokeCode 构造调用原生合约方法的脚本，参数逆序压栈，不再打包为数组
+func buildNativeInvokeCode(contractAddress string, version byte, method string, params ...interface{}) ([]byte, error) {
+	contract, err := contractAddressBytes(contractAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &neoVMScriptBuilder{}
+	for i := len(params) - 1; i >= 0; i-- {
+		if err := b.emitParam(params[i]); err != nil {
+			return nil, err
+		}
+	}
+	b.emitPushBytes([]byte(method))
+	b.emitPushBytes(contract)
+	b.emitPushInteger(big.NewInt(int64(version)))
+	b.emitOpCode(opSysCall)
+	b.emitPushBytes([]byte(nativeInvokeName))
+
+	return b.bytes(), nil
+}
+
+//nativeTransferState 原生合约转账中的一笔转账
+type nativeTransferState struct {
+	From   string
+	To     string
+	Amount *big.Int
+}
+
+//buildNativeTransferCode 构造ONT、ONG转账的调用脚本，一笔交易可包含多笔转账
+func buildNativeTransferCode(contractAddress string, states ...nativeTransferState) ([]byte, error) {
+	list := make([]interface{}, 0, len(states))
+	for _, state := range states {
+		from, err := addressToScriptHash(state.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := addressToScriptHash(state.To)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, neoVMStruct{from, to, state.Amount})
+	}
+	return buildNativeInvokeCode(contractAddress, 0, nativeTransferMethod, list)
+}
+
+//BuildNeoVMInvokeCode 构造调用NEOVM合约方法的脚本
+func BuildNeoVMInvokeCode(contractAddress string, method string, params ...NeoVMParam) ([]byte, error) {
+	values := make([]interface{}, 0, len(params))
+	for _, p := range params {
+		v, err := p.neoVMValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return buildNeoVMInvokeCode(contractAddress, method, values...)
+}
+
+//invokeTransaction 调用合约的交易
+type invokeTransaction struct {
+	Nonce    uint32
+	GasPrice uint64
+	GasLimit uint64
+	Payer    []byte
+	Code     []byte
+}
+
+//newInvokeTransaction 创建调用合约的交易，payer为支付手续费的地址
+func newInvokeTransaction(gasPrice, gasLimit uint64, payer string, code []byte) (*invokeTransaction, error) {
+	payerHash, err := addressToScriptHash(payer)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 4)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return &invokeTransaction{
+		Nonce:    binary.LittleEndian.Uint32(nonce),
+		GasPrice: gasPrice,
+		GasLimit: gasLimit,
+		Payer:    payerHash,
+		Code:     code,
+	}, nil
+}
+
+//serializeUnsigned 序列化不含签名的交易，交易哈希基于此计算
+func (tx *invokeTransaction) serializeUnsigned() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(txVersion)
+	buf.WriteByte(txTypeInvoke)
+	binary.Write(buf, binary.LittleEndian, tx.Nonce)
+	binary.Write(buf, binary.LittleEndian, tx.GasPrice)
+	binary.Write(buf, binary.LittleEndian, tx.GasLimit)
+	buf.Write(tx.Payer)
+	writeVarBytes(buf, tx.Code)
+	//交易属性
+	writeVarUint(buf, 0)
+	return buf.Bytes()
+}
+
+//hash 交易哈希，签名时使用
+func (tx *invokeTransaction) hash() []byte {
+	h := sha256.Sum256(tx.serializeUnsigned())
+	h = sha256.Sum256(h[:])
+	return h[:]
+}
+
+//emptyTransHex 没有签名的交易hex，与ontologyTransaction创建的交易格式一致
+func (tx *invokeTransaction) emptyTransHex() string {
+	return hex.EncodeToString(append(tx.serializeUnsigned(), 0x00))
+}
+
+func writeVarUint(buf *bytes.Buffer, n uint64) {
+	switch {
+	case n < 0xFD:
+		buf.WriteByte(byte(n))
+	case n <= 0xFFFF:
+		buf.WriteByte(0xFD)
+		binary.Write(buf, binary.LittleEndian, uint16(n))
+	case n <= 0xFFFFFFFF:
+		buf.WriteByte(0xFE)
+		binary.Write(buf, binary.LittleEndian, uint32(n))
+	default:
+		buf.WriteByte(0xFF)
+		binary.Write(buf, binary.LittleEndian, n)
+	}
+}
+
+func writeVarBytes(buf *bytes.Buffer, data []byte) {
+	writeVarUint(buf, uint64(len(data)))
+	buf.Write(data)
+}
@@ -0,0 +1,149 @@
+package ontology
+
+import (
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+const (
+	testOEP4Contract = "a1b2c3d4e5f60718293a4b5c6d7e8f9011223344"
+	testAddress1     = "AFmseVrdL9f9oyCzZefL9tG6UbvhUMqNMV" //0000000000000000000000000000000000000001
+	testAddress7     = "AFmseVrdL9f9oyCzZefL9tG6UbviEH9ugK" //0000000000000000000000000000000000000007
+)
+
+func TestNeoVMIntToBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, ""},
+		{100, "64"},
+		{128, "8000"},
+		{255, "ff00"},
+		{1000000, "40420f"},
+		{-1, "ff"},
+		{-128, "80"},
+		{-129, "7fff"},
+	}
+	for _, test := range tests {
+		got := neoVMIntToBytes(big.NewInt(test.n))
+		if hex.EncodeToString(got) != test.want {
+			t.Errorf("neoVMIntToBytes(%d) = %x, want %s", test.n, got, test.want)
+		}
+		if back := neoVMBytesToInt(got); back.Int64() != test.n {
+			t.Errorf("neoVMBytesToInt(%x) = %s, want %d", got, back, test.n)
+		}
+	}
+}
+
+func TestBuildOEP4TransferCode(t *testing.T) {
+	code, err := buildOEP4TransferCode(testOEP4Contract, testAddress1, testAddress7, big.NewInt(100))
+	if err != nil {
+		t.Fatalf("buildOEP4TransferCode failed unexpected error: %v", err)
+	}
+	want := "0164" +
+		"14" + "0000000000000000000000000000000000000007" +
+		"14" + "0000000000000000000000000000000000000001" +
+		"53c1" + "087472616e73666572" +
+		"67" + "44332211908f7e6d5c4b3a291807f6e5d4c3b2a1"
+	if hex.EncodeToString(code) != want {
+		t.Errorf("transfer code = %x, want %s", code, want)
+	}
+
+	tx, err := newInvokeTransaction(500, 20000, testAddress1, code)
+	if err != nil {
+		t.Fatalf("newInvokeTransaction failed unexpected error: %v", err)
+	}
+	tx.Nonce = 1
+	raw := tx.emptyTransHex()
+	wantRaw := "00d1" + "01000000" + "f401000000000000" + "204e000000000000" +
+		"0000000000000000000000000000000000000001" +
+		hex.EncodeToString([]byte{byte(len(code))}) + want + "00" + "00"
+	if raw != wantRaw {
+		t.Errorf("empty transaction = %s, want %s", raw, wantRaw)
+	}
+	if len(tx.hash()) != 32 {
+		t.Errorf("transaction hash length = %d", len(tx.hash()))
+	}
+}
+
+//testTransferCode 主网单笔ONT转账的调用脚本：state结构体、转账列表PUSH1 PACK，
+//之后直接是方法名，列表外没有再打包一层参数数组
+const testTransferCode = "00c66b14e98f4998d837fcdd44a50561f7f32140c7c6c2606a7cc814fe5bbcbf9ad2cd7d1d8e5bfbdb1d6ddba6cd8e4d6a7cc801646a7cc86c51c1087472616e736665721400000000000000000000000000000000000000010068164f6e746f6c6f67792e4e61746976652e496e766f6b65"
+
+func TestBuildNativeTransferCode(t *testing.T) {
+	from, _ := scriptHashToAddress("e98f4998d837fcdd44a50561f7f32140c7c6c260")
+	to, _ := scriptHashToAddress("fe5bbcbf9ad2cd7d1d8e5bfbdb1d6ddba6cd8e4d")
+	fromHash, _ := addressToScriptHash(from)
+	toHash, _ := addressToScriptHash(to)
+
+	code, err := buildNativeInvokeCode("0100000000000000000000000000000000000000", 0, "transfer",
+		[]interface{}{neoVMStruct{fromHash, toHash, big.NewInt(100)}})
+	if err != nil {
+		t.Fatalf("buildNativeInvokeCode failed unexpected error: %v", err)
+	}
+	if hex.EncodeToString(code) != testTransferCode {
+		t.Errorf("native invoke code = %x, want %s", code, testTransferCode)
+	}
+
+	//transferV2与transfer的参数相同
+	code, err = buildNativeTransferCode("0100000000000000000000000000000000000000", nativeTransferState{From: from, To: to, Amount: big.NewInt(100)})
+	if err != nil {
+		t.Fatalf("buildNativeTransferCode failed unexpected error: %v", err)
+	}
+	want := strings.Replace(testTransferCode, "08"+hex.EncodeToString([]byte("transfer")), "0a"+hex.EncodeToString([]byte("transferV2")), 1)
+	if hex.EncodeToString(code) != want {
+		t.Errorf("native transfer code = %x, want %s", code, want)
+	}
+
+	code, err = buildNativeTransferCode("0100000000000000000000000000000000000000",
+		nativeTransferState{From: testAddress1, To: testAddress7, Amount: big.NewInt(100)},
+		nativeTransferState{From: testAddress7, To: testAddress1, Amount: big.NewInt(2)},
+	)
+	if err != nil {
+		t.Fatalf("buildNativeTransferCode failed unexpected error: %v", err)
+	}
+	//数组元素逆序压栈
+	want = "00c66b" + "14" + "0000000000000000000000000000000000000007" + "6a7cc8" +
+		"14" + "0000000000000000000000000000000000000001" + "6a7cc8" + "52" + "6a7cc8" + "6c" +
+		"00c66b" + "14" + "0000000000000000000000000000000000000001" + "6a7cc8" +
+		"14" + "0000000000000000000000000000000000000007" + "6a7cc8" + "0164" + "6a7cc8" + "6c" +
+		"52c1" +
+		"0a" + hex.EncodeToString([]byte("transferV2")) +
+		"14" + "0000000000000000000000000000000000000001" +
+		"00" + "68" + "16" + hex.EncodeToString([]byte("Ontology.Native.Invoke"))
+	if hex.EncodeToString(code) != want {
+		t.Errorf("native transfer code = %x, want %s", code, want)
+	}
+
+	if _, err := buildNativeTransferCode("0100000000000000000000000000000000000000", nativeTransferState{From: "bad", To: testAddress1, Amount: big.NewInt(1)}); err == nil {
+		t.Errorf("invalid address should fail")
+	}
+}
+
+func TestWalletManager_getOEP4Balances(t *testing.T) {
+	node := newTestNode(func(method string, params []interface{}) interface{} {
+		if method != "sendrawtransaction" || len(params) != 2 {
+			return testRpcError(42002)
+		}
+		//balanceOf(0x..01) 返回 1000000，其余地址返回空
+		result := ""
+		if strings.Contains(params[0].(string), "0000000000000000000000000000000000000001") {
+			result = "40420f"
+		}
+		return testPreExecResult(20000, result)
+	})
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+
+	balances, err := wm.getOEP4Balances(testOEP4Contract, testAddress1, testAddress7)
+	if err != nil {
+		t.Fatalf("getOEP4Balances failed unexpected error: %v", err)
+	}
+	if balances[0].String() != "1000000" || balances[1].Sign() != 0 {
+		t.Errorf("unexpected balances: %v", balances)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"time"
+)
+
+//NodeClient 节点访问接口，由JSON-RPC客户端或Restful客户端实现
+type NodeClient interface {
+	//SetRetry 设置请求失败的重试次数和首次重试间隔
+	SetRetry(maxRetries int, backoff time.Duration)
+	//SetMaxHeightLag 设置节点允许落后的最大区块数
+	SetMaxHeightLag(lag uint64)
+	//CheckNodes 对所有节点进行健康检查
+	CheckNodes()
+	//NodeStatus 获取所有节点的健康状态
+	NodeStatus() []NodeStatus
+	//StartHealthCheck 启动定时健康检查
+	StartHealthCheck(interval time.Duration)
+	//StopHealthCheck 停止定时健康检查
+	StopHealthCheck()
+
+	getBlockHeight() (uint64, error)
+	getBlockHash(height uint64) (string, error)
+	getBlock(hash string) (*Block, error)
+	getBlockByHeight(height uint64) (*Block, error)
+	getBlockHeightFromTxID(txid string) (uint64, error)
+	getTransaction(txid string) (*Transaction, error)
+	getTxDetail(txid string) (*smartCodeEvent, error)
+	getBlockEvents(height uint64) (map[string]*smartCodeEvent, error)
+	getONTBalance(address string) (*AddrBalance, error)
+	getONGBalance(address string) (*AddrBalance, error)
+	getBalance(address string) (*AddrBalance, error)
+	getBalances(addresses ...string) ([]*AddrBalance, error)
+	getGasPrice() (uint64, error)
+	sendRawTransaction(txHex string) (string, error)
+	preExecTransaction(txHex string) (*preExecResult, error)
+	getStorage(contractAddress, key string) ([]byte, error)
+}
+
+//节点接口类型，由配置 nodeAPIType 选择，与旧的 rpcServerType 无关
+const (
+	NodeAPIJsonRpc = 0 //节点 JSON-RPC API，默认
+	NodeAPIRest    = 1 //节点 Restful API /api/v1
+)
+
+//NewNodeClient 按节点接口类型创建客户端，NodeAPIRest 以外都使用 JSON-RPC
+func NewNodeClient(apiType int, addrs ...string) NodeClient {
+	if apiType == NodeAPIRest {
+		return NewRestClient(addrs...)
+	}
+	return NewRpcClient(addrs...)
+}
@@ -0,0 +1,304 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+
+	"github.com/blocktree/go-owcdrivers/addressEncoder"
+	"github.com/blocktree/go-owcdrivers/ontologyTransaction"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	OEP4Protocol      = "oep4"
+	oep4TransferEvent = "transfer"
+	oep4BalanceOf     = "balanceOf"
+	oep4Name          = "name"
+	oep4Symbol        = "symbol"
+	oep4Decimals      = "decimals"
+	oep4TotalSupply   = "totalSupply"
+
+	preExecGasLimit = 20000000 //预执行交易的gasLimit，预执行不消耗gas
+)
+
+//parseOEP4Transfer 解析NEOVM合约的OEP-4 transfer事件
+//States依次为hex编码的事件名、转出和转入地址的脚本哈希、小端序的金额
+func parseOEP4Transfer(contractAddress string, states []gjson.Result) (*Notify, bool) {
+	if len(states) != 4 {
+		return nil, false
+	}
+
+	method, err := hex.DecodeString(states[0].String())
+	if err != nil || string(method) != oep4TransferEvent {
+		return nil, false
+	}
+
+	from, err := scriptHashToAddress(states[1].String())
+	if err != nil {
+		return nil, false
+	}
+	to, err := scriptHashToAddress(states[2].String())
+	if err != nil {
+		return nil, false
+	}
+
+	amount, err := parseNeoVMInteger(states[3])
+	if err != nil || amount.Sign() < 0 {
+		return nil, false
+	}
+
+	return &Notify{
+		ContractAddress: contractAddress,
+		Method:          oep4TransferEvent,
+		From:            from,
+		To:              to,
+		Amount:          amount.String(),
+	}, true
+}
+
+//scriptHashToAddress 20字节脚本哈希的hex转为base58地址
+func scriptHashToAddress(scriptHash string) (string, error) {
+	hash, err := hex.DecodeString(scriptHash)
+	if err != nil {
+		return "", err
+	}
+	if len(hash) != 20 {
+		return "", fmt.Errorf("invalid script hash: %s", scriptHash)
+	}
+	return addressEncoder.AddressEncode(hash, addressEncoder.ONT_Address), nil
+}
+
+//addressToScriptHash base58地址转为20字节脚本哈希
+func addressToScriptHash(address string) ([]byte, error) {
+	hash, err := addressEncoder.AddressDecode(address, addressEncoder.ONT_Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	if len(hash) != 20 {
+		return nil, fmt.Errorf("invalid address: %s", address)
+	}
+	return hash, nil
+}
+
+//buildOEP4TransferCode 构造OEP-4合约transfer(from, to, amount)的调用脚本
+func buildOEP4TransferCode(contractAddress, from, to string, amount *big.Int) ([]byte, error) {
+	fromHash, err := addressToScriptHash(from)
+	if err != nil {
+		return nil, err
+	}
+	toHash, err := addressToScriptHash(to)
+	if err != nil {
+		return nil, err
+	}
+	return buildNeoVMInvokeCode(contractAddress, oep4TransferEvent, fromHash, toHash, amount)
+}
+
+//preExecInvoke 预执行NEOVM合约方法，payer为空时使用合约地址对应的账户，预执行不校验签名和手续费
+func (wm *WalletManager) preExecInvoke(payer, contractAddress, method string, params ...interface{}) (*preExecResult, error) {
+	code, err := buildNeoVMInvokeCode(contractAddress, method, params...)
+	if err != nil {
+		return nil, err
+	}
+	if payer == "" {
+		payer, err = scriptHashToAddress(contractAddress)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tx, err := newInvokeTransaction(0, preExecGasLimit, payer, code)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := wm.RPCClient.preExecTransaction(tx.emptyTransHex())
+	if err != nil {
+		return nil, fmt.Errorf("pre-execute %s of contract %s failed: %w", method, contractAddress, err)
+	}
+	return ret, nil
+}
+
+//getOEP4Balance 通过预执行balanceOf查询OEP-4代币余额，返回最小单位的整数
+func (wm *WalletManager) getOEP4Balance(contractAddress, address string) (*big.Int, error) {
+	hash, err := addressToScriptHash(address)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := wm.preExecInvoke(address, contractAddress, oep4BalanceOf, hash)
+	if err != nil {
+		return nil, fmt.Errorf("get OEP-4 balance of [%s] failed: %w", address, err)
+	}
+	return parseNeoVMInteger(ret.Result)
+}
+
+//getOEP4Balances 并发查询多个地址的OEP-4代币余额，结果与传入地址顺序一致
+func (wm *WalletManager) getOEP4Balances(contractAddress string, addresses ...string) ([]*big.Int, error) {
+	list := make([]*big.Int, len(addresses))
+	errs := make([]error, len(addresses))
+	parallel(len(addresses), maxRpcFanOutConcurrency, func(i int) {
+		list[i], errs[i] = wm.getOEP4Balance(contractAddress, addresses[i])
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+//OEP4TokenInfo OEP-4代币的元数据，TotalSupply为最小单位的整数
+type OEP4TokenInfo struct {
+	Address     string
+	Name        string
+	Symbol      string
+	Decimals    uint64
+	TotalSupply string
+}
+
+//GetOEP4TokenInfo 通过预执行查询OEP-4代币的name、symbol、decimals和totalSupply
+func (wm *WalletManager) GetOEP4TokenInfo(contractAddress string) (*OEP4TokenInfo, error) {
+	results := make(map[string]gjson.Result)
+	for _, method := range []string{oep4Name, oep4Symbol, oep4Decimals, oep4TotalSupply} {
+		ret, err := wm.preExecInvoke("", contractAddress, method)
+		if err != nil {
+			return nil, err
+		}
+		results[method] = ret.Result
+	}
+
+	name, err := parseNeoVMString(results[oep4Name])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token name: %w", err)
+	}
+	symbol, err := parseNeoVMString(results[oep4Symbol])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token symbol: %w", err)
+	}
+	decimals, err := parseNeoVMInteger(results[oep4Decimals])
+	if err != nil || decimals.Sign() < 0 || !decimals.IsUint64() {
+		return nil, fmt.Errorf("invalid token decimals: %s", results[oep4Decimals].Raw)
+	}
+	totalSupply, err := parseNeoVMInteger(results[oep4TotalSupply])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token total supply: %w", err)
+	}
+
+	return &OEP4TokenInfo{
+		Address:     contractAddress,
+		Name:        name,
+		Symbol:      symbol,
+		Decimals:    decimals.Uint64(),
+		TotalSupply: totalSupply.String(),
+	}, nil
+}
+
+//parseNeoVMString 解析NEOVM返回的bytearray字符串
+func parseNeoVMString(value gjson.Result) (string, error) {
+	data, err := hex.DecodeString(value.String())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+//parseNeoVMInteger 解析NEOVM整数，bytearray为小端序补码
+func parseNeoVMInteger(value gjson.Result) (*big.Int, error) {
+	if value.Type == gjson.Number {
+		n, ok := new(big.Int).SetString(value.Raw, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid integer: %s", value.Raw)
+		}
+		return n, nil
+	}
+
+	data, err := hex.DecodeString(value.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid integer: %s", value.String())
+	}
+	return neoVMBytesToInt(data), nil
+}
+
+//neoVMBytesToInt 小端序补码转为整数
+func neoVMBytesToInt(data []byte) *big.Int {
+	if len(data) == 0 {
+		return new(big.Int)
+	}
+
+	be := make([]byte, len(data))
+	for i, b := range data {
+		be[len(data)-1-i] = b
+	}
+	n := new(big.Int).SetBytes(be)
+	if be[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(be)*8)))
+	}
+	return n
+}
+
+//notifyCoin 获取合约事件的资产，ONT和ONG以外的合约需要是关注的代币
+func (bs *ONTBlockScanner) notifyCoin(contractAddress string, scanTargetFunc openwallet.BlockScanTargetFuncV2) (openwallet.Coin, bool) {
+
+	contract := openwallet.SmartContract{
+		ContractID: openwallet.GenContractID(bs.wm.Symbol(), contractAddress),
+		Symbol:     bs.wm.Symbol(),
+		Address:    contractAddress,
+		Name:       bs.wm.FullName(),
+	}
+
+	switch contractAddress {
+	case ontologyTransaction.ONTContractAddress:
+		contract.Token = "ONT"
+		contract.Decimals = 0
+	case ontologyTransaction.ONGContractAddress:
+		contract.Token = "ONG"
+		contract.Decimals = 9
+	default:
+		targetResult := scanTargetFunc(openwallet.ScanTargetParam{
+			ScanTarget:     contractAddress,
+			Symbol:         bs.wm.Symbol(),
+			ScanTargetType: openwallet.ScanTargetTypeContractAddress,
+		})
+		if !targetResult.Exist {
+			return openwallet.Coin{}, false
+		}
+
+		switch info := targetResult.TargetInfo.(type) {
+		case *openwallet.SmartContract:
+			contract = *info
+		case openwallet.SmartContract:
+			contract = info
+		default:
+			contract.Protocol = OEP4Protocol
+		}
+		if len(contract.ContractID) == 0 {
+			contract.ContractID = openwallet.GenContractID(bs.wm.Symbol(), contractAddress)
+		}
+		contract.Symbol = bs.wm.Symbol()
+		contract.Address = contractAddress
+	}
+
+	return openwallet.Coin{
+		Symbol:     bs.wm.Symbol(),
+		IsContract: true,
+		ContractID: contract.ContractID,
+		Contract:   contract,
+	}, true
+}
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"path/filepath"
+	"time"
 
 	"github.com/astaxie/beego/config"
 	"github.com/blocktree/openwallet/v2/log"
@@ -614,20 +615,88 @@ func (wm *WalletManager) GetAddressWithBalance(address ...*openwallet.Address) e
 //LoadAssetsConfig 加载外部配置
 func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 
-	wm.Config.RestfulServerAPI = c.String("restfulServerAPI")
+	wm.Config.RestfulServerAPIs = parseNodeAddrs(c.Strings("restfulServerAPI")...)
+	if len(wm.Config.RestfulServerAPIs) > 0 {
+		wm.Config.RestfulServerAPI = wm.Config.RestfulServerAPIs[0]
+	}
+	nodeCheckInterval, _ := c.Int64("nodeCheckInterval")
+	if nodeCheckInterval > 0 {
+		wm.Config.NodeCheckInterval = time.Duration(nodeCheckInterval) * time.Second
+	}
+	nodeMaxHeightLag, err := c.Int64("nodeMaxHeightLag")
+	if err == nil && nodeMaxHeightLag >= 0 {
+		wm.Config.NodeMaxHeightLag = uint64(nodeMaxHeightLag)
+	}
 	gaslimit, _ := c.Int64("gasLimit")
 	wm.Config.GasLimit = uint64(gaslimit)
+	gasLimitMargin, err := c.Int64("gasLimitMargin")
+	if err == nil && gasLimitMargin >= 0 {
+		wm.Config.GasLimitMargin = uint64(gasLimitMargin)
+	}
 
 	gasPriceType, _ := c.Int64("gasPriceType")
 	wm.Config.GasPriceType = uint64(gasPriceType)
 	gasPriceFixed, _ := c.Int64("gasPriceFixed")
 	wm.Config.GasPriceFixed = uint64(gasPriceFixed)
 
-	wm.RPCClient = NewRpcClient(wm.Config.RestfulServerAPI)
+	rpcMaxRetries, err := c.Int64("rpcMaxRetries")
+	if err == nil && rpcMaxRetries >= 0 {
+		wm.Config.RpcMaxRetries = int(rpcMaxRetries)
+	}
+	rpcRetryBackoff, _ := c.Int64("rpcRetryBackoff")
+	if rpcRetryBackoff > 0 {
+		wm.Config.RpcRetryBackoff = time.Duration(rpcRetryBackoff) * time.Millisecond
+	}
+
+	blockPrefetchSize, _ := c.Int64("blockPrefetchSize")
+	if blockPrefetchSize > 0 {
+		wm.Config.BlockPrefetchSize = uint64(blockPrefetchSize)
+	}
+
+	maxReorgDepth, _ := c.Int64("maxReorgDepth")
+	if maxReorgDepth > 0 {
+		wm.Config.MaxReorgDepth = uint64(maxReorgDepth)
+	}
+
+	wm.Config.WebSocketAPI = c.String("webSocketAPI")
+	wsReconnectInterval, _ := c.Int64("webSocketReconnectInterval")
+	if wsReconnectInterval > 0 {
+		wm.Config.WebSocketReconnectInterval = time.Duration(wsReconnectInterval) * time.Second
+	}
+
+	if wm.RPCClient != nil {
+		wm.RPCClient.StopHealthCheck()
+	}
+	rpcServerType, _ := c.Int("rpcServerType")
+	wm.Blockscanner.RPCServer = rpcServerType
+	nodeAPIType, _ := c.Int("nodeAPIType")
+	wm.Config.NodeAPIType = nodeAPIType
+
+	wm.RPCClient = NewNodeClient(wm.Config.NodeAPIType, wm.Config.RestfulServerAPIs...)
+	wm.RPCClient.SetMaxHeightLag(wm.Config.NodeMaxHeightLag)
+	wm.RPCClient.SetRetry(wm.Config.RpcMaxRetries, wm.Config.RpcRetryBackoff)
+	if len(wm.Config.RestfulServerAPIs) > 1 {
+		wm.RPCClient.StartHealthCheck(wm.Config.NodeCheckInterval)
+	}
 	wm.Config.DataDir = c.String("dataDir")
 
 	//数据文件夹
 	wm.Config.makeDataDir()
+
+	//没有外部设置的区块链数据访问接口时使用本地数据文件
+	if err := wm.Blockscanner.useLocalBlockchainDAI(); err != nil {
+		return err
+	}
+	wm.Blockscanner.resetAddressTxIndex()
+
+	wm.Config.ABIDir = c.String("abiDir")
+	wm.ABIRegistry = NewABIRegistry()
+	if len(wm.Config.ABIDir) > 0 {
+		if err := wm.ABIRegistry.LoadDir(wm.Config.ABIDir); err != nil {
+			return err
+		}
+		log.Std.Info("loaded %d contract abi from %s", wm.ABIRegistry.Len(), wm.Config.ABIDir)
+	}
 	return nil
 }
 
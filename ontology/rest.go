@@ -0,0 +1,337 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+//Ontology Restful API 路径
+const (
+	restBlockHeight         = "/api/v1/block/height"
+	restBlockHash           = "/api/v1/block/hash/"
+	restBlockByHash         = "/api/v1/block/details/hash/"
+	restBlockByHeight       = "/api/v1/block/details/height/"
+	restBlockHeightByTxHash = "/api/v1/block/height/txhash/"
+	restTransaction         = "/api/v1/transaction"
+	restSmartCodeEvent      = "/api/v1/smartcode/event/txhash/"
+	restBlockEvents         = "/api/v1/smartcode/event/transactions/"
+	restBalance             = "/api/v1/balancev2/"
+	restUnboundOng          = "/api/v1/unboundong/"
+	restGasPrice            = "/api/v1/gasprice"
+	restStorage             = "/api/v1/storage/"
+	restVersion             = "/api/v1/version"
+	restApiVersion          = "1.0.0"
+)
+
+//restResponse Restful 和 WebSocket 接口的响应
+type restResponse struct {
+	Action  string
+	Desc    string
+	Error   int64
+	Result  json.RawMessage
+	Version string
+}
+
+//restRequest Restful POST 请求
+type restRequest struct {
+	Action  string
+	Version string
+	Data    string
+}
+
+//RestClient 节点 Restful API 客户端，节点切换、重试和健康检查与RpcClient一致
+type RestClient struct {
+	*RpcClient
+}
+
+//NewRestClient 创建Restful客户端，可传入多个节点地址，请求失败时自动切换到下一个可用节点
+func NewRestClient(addrs ...string) *RestClient {
+	client := &RestClient{
+		RpcClient: NewRpcClient(addrs...),
+	}
+	client.probe = client.probeRestNode
+	return client
+}
+
+func (rest *RestClient) sendRestRequest(path string, req *restRequest) ([]byte, error) {
+	return rest.sendRestRequestWithContext(context.Background(), path, req)
+}
+
+//sendRestRequestWithContext 发送请求，req为空时使用GET，节点不可用时切换节点，所有节点都失败后按退避间隔重试
+func (rest *RestClient) sendRestRequestWithContext(ctx context.Context, path string, req *restRequest) ([]byte, error) {
+	var data []byte
+	if req != nil {
+		var err error
+		data, err = json.Marshal(req)
+		if err != nil {
+			return nil, fmt.Errorf("restRequest json.Marsha error:%w", err)
+		}
+	}
+
+	return rest.withRetry(ctx, func() ([]byte, error) {
+		return rest.postToNodes(ctx, func(node *rpcNode) ([]byte, error) {
+			return rest.callRestNode(ctx, node, path, data)
+		})
+	})
+}
+
+//callRestNode 向指定节点发送请求并解析restResponse
+func (rest *RestClient) callRestNode(ctx context.Context, node *rpcNode, path string, data []byte) ([]byte, error) {
+	method := http.MethodGet
+	if data != nil {
+		method = http.MethodPost
+	}
+
+	body, err := rest.doRaw(ctx, node, method, strings.TrimRight(node.addr, "/")+path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	restRsp := &restResponse{}
+	err = json.Unmarshal(body, restRsp)
+	if err != nil {
+		return nil, &NodeError{Addr: node.addr, Err: fmt.Errorf("json.Unmarshal restResponse:%s error:%w", body, err)}
+	}
+	if restRsp.Error != 0 {
+		return nil, &RpcError{
+			Method: path,
+			Code:   restRsp.Error,
+			Desc:   restRsp.Desc,
+			Result: string(restRsp.Result),
+		}
+	}
+	return restRsp.Result, nil
+}
+
+//probeRestNode 通过Restful接口探测节点的高度和版本
+func (rest *RestClient) probeRestNode(ctx context.Context, node *rpcNode) (uint64, string, error) {
+	resp, err := rest.callRestNode(ctx, node, restBlockHeight, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	height, err := parseRestBlockCount(resp)
+	if err != nil {
+		return 0, "", err
+	}
+	version, err := rest.callRestNode(ctx, node, restVersion, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	return height, strings.Trim(string(version), "\""), nil
+}
+
+//parseRestBlockCount Restful接口返回当前高度，转为与getblockcount一致的区块数
+func parseRestBlockCount(resp []byte) (uint64, error) {
+	height, err := strconv.ParseUint(string(resp), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid block height: %s", resp)
+	}
+	return height + 1, nil
+}
+
+func (rest *RestClient) getBlockHeight() (uint64, error) {
+	resp, err := rest.sendRestRequest(restBlockHeight, nil)
+	if err != nil {
+		return 0, err
+	}
+	return parseRestBlockCount(resp)
+}
+
+func (rest *RestClient) getBlockHash(height uint64) (string, error) {
+	resp, err := rest.sendRestRequest(restBlockHash+strconv.FormatUint(height, 10), nil)
+	if err != nil {
+		return "", err
+	}
+
+	hash := ""
+	if err := json.Unmarshal(resp, &hash); err != nil || len(hash) == 0 {
+		return "", fmt.Errorf("invalid block hash: %s", resp)
+	}
+	return hash, nil
+}
+
+func (rest *RestClient) getBlock(hash string) (*Block, error) {
+	resp, err := rest.sendRestRequest(restBlockByHash+hash, nil)
+	if err != nil {
+		return nil, err
+	}
+	json := gjson.ParseBytes(resp)
+
+	return NewBlock(&json), nil
+}
+
+func (rest *RestClient) getBlockByHeight(height uint64) (*Block, error) {
+	resp, err := rest.sendRestRequest(restBlockByHeight+strconv.FormatUint(height, 10), nil)
+	if err != nil {
+		return nil, err
+	}
+	json := gjson.ParseBytes(resp)
+
+	return NewBlock(&json), nil
+}
+
+func (rest *RestClient) getBlockHeightFromTxID(txid string) (uint64, error) {
+	resp, err := rest.sendRestRequest(restBlockHeightByTxHash+txid, nil)
+	if err != nil {
+		return 0, err
+	}
+	height, _ := strconv.Atoi(string(resp))
+	return uint64(height), nil
+}
+
+func (rest *RestClient) getTransaction(txid string) (*Transaction, error) {
+	resp, err := rest.sendRestRequest(restTransaction+"/"+txid, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	trx := newTransaction(resp)
+	trx.BlockHash, err = rest.getBlockHash(trx.BlockHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := rest.getTxDetail(trx.TxID)
+	if err != nil {
+		return nil, err
+	}
+	trx.setEvent(event)
+	return trx, nil
+}
+
+func (rest *RestClient) getTxDetail(txid string) (*smartCodeEvent, error) {
+	resp, err := rest.sendRestRequest(restSmartCodeEvent+txid, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Get transaction result failed: %w", err)
+	}
+
+	return parseSmartCodeEvent(resp)
+}
+
+func (rest *RestClient) getBlockEvents(height uint64) (map[string]*smartCodeEvent, error) {
+	resp, err := rest.sendRestRequest(restBlockEvents+strconv.FormatUint(height, 10), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Get block events failed: %w", err)
+	}
+
+	return parseBlockEvents(resp)
+}
+
+func (rest *RestClient) getONTBalance(address string) (*AddrBalance, error) {
+	balance, err := rest.sendRestRequest(restBalance+address, nil)
+	if err != nil {
+		return nil, fmt.Errorf("get ONT balance failed: %w", err)
+	}
+	ret := newONTBalance(string(balance))
+	ret.Address = address
+
+	return ret, nil
+}
+
+func (rest *RestClient) getONGBalance(address string) (*AddrBalance, error) {
+	return rest.getBalance(address)
+}
+
+func (rest *RestClient) getBalance(address string) (*AddrBalance, error) {
+	balance, err := rest.sendRestRequest(restBalance+address, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Get address balance failed: %w", err)
+	}
+
+	unboundong, err := rest.sendRestRequest(restUnboundOng+address, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Get address unbound ONG failed: %w", err)
+	}
+
+	ret := newAddrBalance([]string{string(balance), string(unboundong)})
+
+	if ret == nil {
+		return nil, errors.New("Get address balance failed!")
+	}
+
+	ret.Address = address
+
+	return ret, nil
+}
+
+//getBalances Restful接口不支持批量请求，有限并发地逐个查询，结果与传入地址顺序一致
+func (rest *RestClient) getBalances(addresses ...string) ([]*AddrBalance, error) {
+	list := make([]*AddrBalance, len(addresses))
+	errs := make([]error, len(addresses))
+	parallel(len(addresses), maxRpcFanOutConcurrency, func(i int) {
+		list[i], errs[i] = rest.getBalance(addresses[i])
+	})
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("Get address [%s] balance failed: %w", addresses[i], err)
+		}
+	}
+	return list, nil
+}
+
+func (rest *RestClient) getGasPrice() (uint64, error) {
+	resp, err := rest.sendRestRequest(restGasPrice, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return gjson.GetBytes(resp, "gasprice").Uint(), nil
+}
+
+func (rest *RestClient) sendRawTransaction(txHex string) (string, error) {
+	resp, err := rest.sendRestRequest(restTransaction, &restRequest{
+		Action:  "sendrawtransaction",
+		Version: restApiVersion,
+		Data:    txHex,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Trim(string(resp), "\""), nil
+}
+
+func (rest *RestClient) getStorage(contractAddress, key string) ([]byte, error) {
+	resp, err := rest.sendRestRequest(restStorage+contractAddress+"/"+key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("get storage of contract %s failed: %w", contractAddress, err)
+	}
+
+	return decodeStorageValue(resp)
+}
+
+func (rest *RestClient) preExecTransaction(txHex string) (*preExecResult, error) {
+	resp, err := rest.sendRestRequest(restTransaction+"?preExec=1", &restRequest{
+		Action:  "sendrawtransaction",
+		Version: restApiVersion,
+		Data:    txHex,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newPreExecResult(resp)
+}
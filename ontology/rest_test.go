@@ -0,0 +1,103 @@
+package ontology
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestRestNode(height uint64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reply := func(errCode int64, result interface{}) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"Action": "", "Desc": "", "Error": errCode, "Result": result, "Version": "1.0.0"})
+		}
+		switch {
+		case r.URL.Path == restBlockHeight:
+			reply(0, height)
+		case r.URL.Path == restVersion:
+			reply(0, "v1.8.0")
+		case strings.HasPrefix(r.URL.Path, restBlockHash):
+			reply(0, "hash"+strings.TrimPrefix(r.URL.Path, restBlockHash))
+		case r.URL.Path == restTransaction && r.Method == http.MethodPost:
+			req := restRequest{}
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Action != "sendrawtransaction" || req.Data != "00d1" {
+				reply(ErrCodeInvalidParams, "")
+				return
+			}
+			reply(0, "txid")
+		case strings.HasPrefix(r.URL.Path, restTransaction+"/"):
+			reply(ErrCodeUnknownTransaction, "")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestRestClient(t *testing.T) {
+	node := newTestRestNode(99)
+	defer node.Close()
+
+	var client NodeClient = NewNodeClient(NodeAPIRest, node.URL)
+
+	height, err := client.getBlockHeight()
+	if err != nil {
+		t.Fatalf("getBlockHeight failed unexpected error: %v", err)
+	}
+	if height != 100 {
+		t.Errorf("height = %d, want block count 100", height)
+	}
+
+	hash, err := client.getBlockHash(10)
+	if err != nil || hash != "hash10" {
+		t.Errorf("getBlockHash = %s, %v, want hash10", hash, err)
+	}
+
+	txid, err := client.sendRawTransaction("00d1")
+	if err != nil || txid != "txid" {
+		t.Errorf("sendRawTransaction = %s, %v, want txid", txid, err)
+	}
+
+	_, err = client.getTransaction("unknown")
+	if !errors.Is(err, ErrUnknownTransaction) {
+		t.Errorf("getTransaction error = %v, want ErrUnknownTransaction", err)
+	}
+}
+
+func TestRestClient_Failover(t *testing.T) {
+	down := newTestRestNode(99)
+	down.Close()
+	lagging := newTestRestNode(10)
+	defer lagging.Close()
+	up := newTestRestNode(99)
+	defer up.Close()
+
+	client := NewRestClient(down.URL, lagging.URL, up.URL)
+	client.CheckNodes()
+
+	status := client.NodeStatus()
+	if status[0].Healthy || status[1].Healthy || !status[2].Healthy {
+		t.Fatalf("unexpected node status: %+v", status)
+	}
+	if status[2].Height != 100 || status[2].Version != "v1.8.0" {
+		t.Errorf("unexpected probe result: %+v", status[2])
+	}
+
+	height, err := client.getBlockHeight()
+	if err != nil || height != 100 {
+		t.Errorf("getBlockHeight = %d, %v, want 100", height, err)
+	}
+}
+
+func TestNewNodeClient(t *testing.T) {
+	if _, ok := NewNodeClient(NodeAPIRest, "http://127.0.0.1:20334").(*RestClient); !ok {
+		t.Errorf("NodeAPIRest should create rest client")
+	}
+	//旧配置的 rpcServerType 不影响节点接口类型，默认使用 JSON-RPC
+	if _, ok := NewNodeClient(NewConfig(Symbol, MasterKey).NodeAPIType, "http://127.0.0.1:20336").(*RpcClient); !ok {
+		t.Errorf("default node api type should create json rpc client")
+	}
+}
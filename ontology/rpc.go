@@ -2,6 +2,7 @@ package ontology
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,19 +10,38 @@ import (
 	"math/big"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/blocktree/go-owcdrivers/ontologyTransaction"
 	"github.com/tidwall/gjson"
 )
 
+const (
+	DefaultRpcMaxRetries   = 3                      //请求失败的默认重试次数
+	DefaultRpcRetryBackoff = 500 * time.Millisecond //首次重试的默认间隔
+	maxRpcRetryBackoff     = 10 * time.Second       //重试间隔上限
+	nodeProbeTimeout       = 10 * time.Second       //健康检查的请求超时
+)
+
 type RpcClient struct {
-	addr       string
-	httpClient *http.Client
+	httpClient   *http.Client
+	nodes        []*rpcNode
+	maxHeightLag uint64
+	maxRetries   int
+	retryBackoff time.Duration
+	stopCheck    chan struct{}
+	//健康检查时探测节点的高度和版本
+	probe func(ctx context.Context, node *rpcNode) (uint64, string, error)
+	//节点不支持JSON-RPC批量请求
+	batchUnsupported bool
+	mu               sync.RWMutex
 }
 
-func NewRpcClient(addr string) *RpcClient {
-	return &RpcClient{
-		addr: addr,
+//NewRpcClient 创建RPC客户端，可传入多个节点地址，请求失败时自动切换到下一个可用节点
+func NewRpcClient(addrs ...string) *RpcClient {
+	client := &RpcClient{
 		httpClient: &http.Client{
 			Transport: &http.Transport{
 				MaxIdleConnsPerHost:   5,
@@ -31,7 +51,28 @@ func NewRpcClient(addr string) *RpcClient {
 			},
 			Timeout: 300000000000,
 		},
+		maxHeightLag: DefaultNodeMaxHeightLag,
+		maxRetries:   DefaultRpcMaxRetries,
+		retryBackoff: DefaultRpcRetryBackoff,
+	}
+
+	client.probe = client.probeRpcNode
+
+	for _, addr := range parseNodeAddrs(addrs...) {
+		client.nodes = append(client.nodes, &rpcNode{addr: addr, healthy: true})
 	}
+	return client
+}
+
+//SetRetry 设置请求失败的重试次数和首次重试间隔，之后每次重试间隔翻倍
+func (rpc *RpcClient) SetRetry(maxRetries int, backoff time.Duration) {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	rpc.maxRetries = maxRetries
+	rpc.retryBackoff = backoff
 }
 
 type JsonRpcRequest struct {
@@ -48,7 +89,17 @@ type JsonRpcResponse struct {
 	Result json.RawMessage `json:"result"`
 }
 
+//Call 调用节点JSON-RPC接口，ctx用于控制本次调用的超时和取消
+func (rpc *RpcClient) Call(ctx context.Context, method string, params []interface{}) ([]byte, error) {
+	return rpc.sendRpcRequestWithContext(ctx, "0", method, params)
+}
+
 func (this *RpcClient) sendRpcRequest(qid, method string, params []interface{}) ([]byte, error) {
+	return this.sendRpcRequestWithContext(context.Background(), qid, method, params)
+}
+
+//sendRpcRequestWithContext 发送请求，节点不可用时切换节点，所有节点都失败后按退避间隔重试
+func (this *RpcClient) sendRpcRequestWithContext(ctx context.Context, qid, method string, params []interface{}) ([]byte, error) {
 	rpcReq := &JsonRpcRequest{
 		Version: "2.0",
 		Id:      qid,
@@ -58,31 +109,193 @@ func (this *RpcClient) sendRpcRequest(qid, method string, params []interface{})
 
 	data, err := json.Marshal(rpcReq)
 	if err != nil {
-		return nil, fmt.Errorf("JsonRpcRequest json.Marsha error:%s", err)
+		return nil, fmt.Errorf("JsonRpcRequest json.Marsha error:%w", err)
 	}
-	// resp, err := this.httpClient.Post(this.addr, "application/json", bytes.NewReader(data))
-	resp, err := http.Post(this.addr, "application/json", bytes.NewReader(data))
+
+	return this.withRetry(ctx, func() ([]byte, error) {
+		return this.postToNodes(ctx, func(node *rpcNode) ([]byte, error) {
+			return this.post(ctx, node, method, data)
+		})
+	})
+}
+
+//withRetry 执行请求，遇到可重试的错误时按退避间隔重试
+func (this *RpcClient) withRetry(ctx context.Context, send func() ([]byte, error)) ([]byte, error) {
+
+	this.mu.RLock()
+	maxRetries := this.maxRetries
+	backoff := this.retryBackoff
+	this.mu.RUnlock()
+
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			wait := backoff << uint(attempt-1)
+			if wait > maxRpcRetryBackoff {
+				wait = maxRpcRetryBackoff
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, fmt.Errorf("%v, retry canceled: %w", lastErr, ctx.Err())
+			case <-timer.C:
+			}
+		}
+
+		result, err := send()
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+
+		if ctx.Err() != nil || !isTemporaryError(err) {
+			return nil, err
+		}
+	}
+	return nil, lastErr
+}
+
+//postToNodes 按优先级依次向节点发送请求，直到有节点正常响应
+func (this *RpcClient) postToNodes(ctx context.Context, send func(node *rpcNode) ([]byte, error)) ([]byte, error) {
+	nodes := this.candidates()
+	if len(nodes) == 0 {
+		return nil, errNoNodes
+	}
+
+	var lastErr error
+	for _, node := range nodes {
+		result, err := send(node)
+		if err == nil {
+			this.markNode(node, nil)
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		//节点返回的错误结果，不需要切换节点
+		var nodeErr *NodeError
+		if !errors.As(err, &nodeErr) {
+			return nil, err
+		}
+		this.markNode(node, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+//callNode 向指定节点发送请求，不切换节点也不重试
+func (this *RpcClient) callNode(ctx context.Context, node *rpcNode, qid, method string, params []interface{}) ([]byte, error) {
+	rpcReq := &JsonRpcRequest{
+		Version: "2.0",
+		Id:      qid,
+		Method:  method,
+		Params:  params,
+	}
+
+	data, err := json.Marshal(rpcReq)
 	if err != nil {
-		return nil, fmt.Errorf("http post request:%s error:%s", data, err)
+		return nil, fmt.Errorf("JsonRpcRequest json.Marsha error:%w", err)
 	}
-	defer resp.Body.Close()
+	return this.post(ctx, node, method, data)
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
+//post 发送HTTP请求并解析JsonRpcResponse
+func (this *RpcClient) post(ctx context.Context, node *rpcNode, method string, data []byte) ([]byte, error) {
+	body, err := this.postRaw(ctx, node, data)
 	if err != nil {
-		return nil, fmt.Errorf("read rpc response body error:%s", err)
+		return nil, err
 	}
 
 	rpcRsp := &JsonRpcResponse{}
 	err = json.Unmarshal(body, rpcRsp)
 	if err != nil {
-		return nil, fmt.Errorf("json.Unmarshal JsonRpcResponse:%s error:%s", body, err)
+		return nil, &NodeError{Addr: node.addr, Err: fmt.Errorf("json.Unmarshal JsonRpcResponse:%s error:%w", body, err)}
 	}
 	if rpcRsp.Error != 0 {
-		return nil, fmt.Errorf("JsonRpcResponse error code:%d desc:%s result:%s", rpcRsp.Error, rpcRsp.Desc, rpcRsp.Result)
+		return nil, newRpcError(method, rpcRsp)
 	}
 	return rpcRsp.Result, nil
 }
 
+//postRaw 发送HTTP请求，返回响应内容
+func (this *RpcClient) postRaw(ctx context.Context, node *rpcNode, data []byte) ([]byte, error) {
+	return this.doRaw(ctx, node, http.MethodPost, node.addr, data)
+}
+
+//doRaw 向节点发送HTTP请求，网络错误和非2xx响应作为NodeError返回
+func (this *RpcClient) doRaw(ctx context.Context, node *rpcNode, method, url string, data []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, &NodeError{Addr: node.addr, Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := this.httpClient.Do(req)
+	if err != nil {
+		return nil, &NodeError{Addr: node.addr, Err: fmt.Errorf("http %s request:%s %s error:%w", method, url, data, err)}
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &NodeError{Addr: node.addr, Err: fmt.Errorf("read rpc response body error:%w", err)}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &NodeError{Addr: node.addr, Err: fmt.Errorf("http response status:%s body:%s", resp.Status, body)}
+	}
+	return body, nil
+}
+
+//newRpcError 根据节点响应创建错误
+func newRpcError(method string, rpcRsp *JsonRpcResponse) *RpcError {
+	return &RpcError{
+		Method: method,
+		Code:   rpcRsp.Error,
+		Desc:   rpcRsp.Desc,
+		Result: string(rpcRsp.Result),
+	}
+}
+
+//isTemporaryError 是否为可重试的错误
+func isTemporaryError(err error) bool {
+	if errors.Is(err, ErrNodeUnavailable) {
+		return true
+	}
+	var rpcErr *RpcError
+	if errors.As(err, &rpcErr) {
+		return rpcErr.temporary()
+	}
+	return false
+}
+
+//probeRpcNode 通过JSON-RPC探测节点的高度和版本
+func (rpc *RpcClient) probeRpcNode(ctx context.Context, node *rpcNode) (uint64, string, error) {
+	height, err := rpc.getBlockHeightFromNode(ctx, node)
+	if err != nil {
+		return 0, "", err
+	}
+	version, err := rpc.callNode(ctx, node, "0", "getversion", []interface{}{})
+	if err != nil {
+		return 0, "", err
+	}
+	return height, strings.Trim(string(version), "\""), nil
+}
+
+//getBlockHeightFromNode 获取指定节点的区块高度
+func (rpc *RpcClient) getBlockHeightFromNode(ctx context.Context, node *rpcNode) (uint64, error) {
+	resp, err := rpc.callNode(ctx, node, "0", "getblockcount", []interface{}{})
+	if err != nil {
+		return 0, err
+	}
+	height, err := strconv.ParseUint(string(resp), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid block count: %s", resp)
+	}
+	return height, nil
+}
+
 func (rpc *RpcClient) getBlockHeightFromTxID(txid string) (uint64, error) {
 	param := []interface{}{txid}
 
@@ -127,6 +340,22 @@ func (rpc *RpcClient) getTransaction(txid string) (*Transaction, error) {
 		return nil, err
 	}
 
+	trx := newTransaction(resp)
+	trx.BlockHash, err = rpc.getBlockHash(trx.BlockHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := rpc.getTxDetail(trx.TxID)
+	if err != nil {
+		return nil, err
+	}
+	trx.setEvent(event)
+	return trx, nil
+}
+
+//newTransaction 解析节点返回的交易，JSON-RPC和Restful接口的格式一致
+func newTransaction(resp []byte) *Transaction {
 	trx := Transaction{}
 	trx.TxID = gjson.Get(string(resp), "Hash").String()
 	trx.Version = gjson.Get(string(resp), "Version").Uint()
@@ -136,17 +365,7 @@ func (rpc *RpcClient) getTransaction(txid string) (*Transaction, error) {
 	trx.Payer = gjson.Get(string(resp), "Payer").String()
 	trx.TxType = gjson.Get(string(resp), "TxType").Uint()
 	trx.BlockHeight = gjson.Get(string(resp), "Height").Uint()
-	trx.BlockHash, err = rpc.getBlockHash(trx.BlockHeight)
-	if err != nil {
-		return nil, err
-	}
-
-	trx.Notifys, err = rpc.getTxDetail(trx.TxID)
-
-	if err != nil {
-		return nil, err
-	}
-	return &trx, nil
+	return &trx
 }
 
 func (rpc *RpcClient) getBlockHeight() (uint64, error) {
@@ -186,7 +405,7 @@ func (rpc *RpcClient) getONTBalance(address string) (*AddrBalance, error) {
 
 	balance, err := rpc.sendRpcRequest("0", "getbalancev2", params)
 	if err != nil {
-		return nil, errors.New("get ONT balance failed!")
+		return nil, fmt.Errorf("get ONT balance failed: %w", err)
 	}
 	ret := newONTBalance(string(balance))
 	ret.Address = address
@@ -204,10 +423,13 @@ func (rpc *RpcClient) getBalance(address string) (*AddrBalance, error) {
 
 	balance, err := rpc.sendRpcRequest("0", "getbalancev2", params)
 	if err != nil {
-		return nil, errors.New("Get address balance failed")
+		return nil, fmt.Errorf("Get address balance failed: %w", err)
 	}
 
 	unboundong, err := rpc.sendRpcRequest("0", "getunboundong", params)
+	if err != nil {
+		return nil, fmt.Errorf("Get address unbound ONG failed: %w", err)
+	}
 
 	ret := newAddrBalance([]string{string(balance), string(unboundong)})
 
@@ -233,26 +455,105 @@ func calculateAmount(arg3, arg4 string) string {
 }
 
 // from,to,amount,contract,method,error
-func (rpc *RpcClient) getTxDetail(txid string) ([]Notify, error) {
+func (rpc *RpcClient) getTxDetail(txid string) (*smartCodeEvent, error) {
 	params := []interface{}{txid}
 
 	resp, err := rpc.sendRpcRequest("0", "getsmartcodeevent", params)
 	if err != nil {
-		return nil, errors.New("Get transaction result failed")
+		return nil, fmt.Errorf("Get transaction result failed: %w", err)
 	}
+
+	return parseSmartCodeEvent(resp)
+}
+
+//getBlockEvents 获取区块内所有交易的合约事件
+func (rpc *RpcClient) getBlockEvents(height uint64) (map[string]*smartCodeEvent, error) {
+	params := []interface{}{uint32(height)}
+
+	resp, err := rpc.sendRpcRequest("0", "getsmartcodeevent", params)
 	if err != nil {
-		return nil, errors.New("Get transaction result failed")
+		return nil, fmt.Errorf("Get block events failed: %w", err)
 	}
 
+	return parseBlockEvents(resp)
+}
+
+//合约执行状态
+const (
+	txStateFailed  = 0
+	txStateSuccess = 1
+)
+
+//governanceAddress 手续费收取地址
+const governanceAddress = "AFmseVrdL9f9oyCzZefL9tG6UbviEH9ugK"
+
+//smartCodeEvent 交易的合约事件，Notifys为解析出的转账，Events为全部合约事件
+type smartCodeEvent struct {
+	TxHash      string
+	State       int64
+	GasConsumed uint64
+	Notifys     []Notify        `json:"-"`
+	Events      []ContractEvent `json:"-"`
+}
+
+//parseSmartCodeEvent 解析交易的合约事件
+func parseSmartCodeEvent(resp []byte) (*smartCodeEvent, error) {
+	notifys, err := parseNotifys(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &smartCodeEvent{
+		TxHash:      gjson.GetBytes(resp, "TxHash").String(),
+		State:       txStateSuccess,
+		GasConsumed: gjson.GetBytes(resp, "GasConsumed").Uint(),
+		Notifys:     notifys,
+	}
+	if state := gjson.GetBytes(resp, "State"); state.Exists() {
+		event.State = state.Int()
+	}
+	for _, notify := range gjson.GetBytes(resp, "Notify").Array() {
+		event.Events = append(event.Events, ContractEvent{
+			ContractAddress: notify.Get("ContractAddress").String(),
+			States:          notify.Get("States").Raw,
+		})
+	}
+	return event, nil
+}
+
+//parseBlockEvents 解析区块内所有交易的合约事件，无法解析的交易不返回
+func parseBlockEvents(resp []byte) (map[string]*smartCodeEvent, error) {
+	list := make([]json.RawMessage, 0)
+	if err := json.Unmarshal(resp, &list); err != nil {
+		return nil, fmt.Errorf("invalid block events: %s", resp)
+	}
+
+	events := make(map[string]*smartCodeEvent, len(list))
+	for _, raw := range list {
+		event, err := parseSmartCodeEvent(raw)
+		if err != nil || len(event.TxHash) == 0 {
+			continue
+		}
+		events[event.TxHash] = event
+	}
+	return events, nil
+}
+
+//parseNotifys 解析合约事件中的ONT、ONG转账
+func parseNotifys(resp []byte) ([]Notify, error) {
 	notifys := gjson.Get(string(resp), "Notify").Array()
 	var ret []Notify
 	if len(notifys) >= 1 {
 		for _, notify := range notifys {
 			contractAddress := notify.Get("ContractAddress").String()
+			states := notify.Get("States").Array()
 			if contractAddress != ontologyTransaction.ONGContractAddress && contractAddress != ontologyTransaction.ONTContractAddress {
+				//其他合约只解析OEP-4转账事件
+				if transfer, ok := parseOEP4Transfer(contractAddress, states); ok {
+					ret = append(ret, *transfer)
+				}
 				continue
 			}
-			states := notify.Get("States").Array()
 
 			if len(states) != 4 && len(states) != 5 {
 				return nil, errors.New("Get transaction result failed")
@@ -263,7 +564,7 @@ func (rpc *RpcClient) getTxDetail(txid string) ([]Notify, error) {
 			} else {
 				amount = calculateAmount(states[3].String(), "")
 			}
-			if states[2].String() != "AFmseVrdL9f9oyCzZefL9tG6UbviEH9ugK" {
+			if states[2].String() != governanceAddress {
 				ret = append(ret, Notify{
 					ContractAddress: contractAddress,
 					Method:          states[0].String(),
@@ -297,3 +598,38 @@ func (rpc *RpcClient) getGasPrice() (uint64, error) {
 
 	return gjson.Get(string(resp), "gasprice").Uint(), nil
 }
+
+func (rpc *RpcClient) sendRawTransaction(txHex string) (string, error) {
+	params := []interface{}{txHex}
+
+	txid, err := rpc.sendRpcRequest("0", "sendrawtransaction", params)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Trim(string(txid), "\""), nil
+}
+
+//getStorage 查询合约存储，key为hex编码，不存在时返回空
+func (rpc *RpcClient) getStorage(contractAddress, key string) ([]byte, error) {
+	params := []interface{}{contractAddress, key}
+
+	resp, err := rpc.sendRpcRequest("0", "getstorage", params)
+	if err != nil {
+		return nil, fmt.Errorf("get storage of contract %s failed: %w", contractAddress, err)
+	}
+
+	return decodeStorageValue(resp)
+}
+
+//preExecTransaction 预执行交易，不上链，用于查询合约状态和估算gas
+func (rpc *RpcClient) preExecTransaction(txHex string) (*preExecResult, error) {
+	params := []interface{}{txHex, 1}
+
+	resp, err := rpc.sendRpcRequest("0", "sendrawtransaction", params)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPreExecResult(resp)
+}
@@ -0,0 +1,204 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/blocktree/openwallet/v2/log"
+)
+
+const (
+	maxRpcBatchSize         = 100 //单次批量请求的最大调用数
+	maxRpcFanOutConcurrency = 10  //节点不支持批量请求时的并发请求数
+)
+
+//errBatchUnsupported 节点不支持批量请求
+var errBatchUnsupported = errors.New("rpc node does not support batch request")
+
+//rpcCall 批量请求中的单个调用
+type rpcCall struct {
+	Method string
+	Params []interface{}
+	Result []byte
+	Err    error
+}
+
+//sendBatchRequest 批量发送请求，结果写入每个调用的Result和Err
+//节点不支持批量请求时，改为有限并发的单个请求
+func (rpc *RpcClient) sendBatchRequest(ctx context.Context, calls []*rpcCall) error {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	rpc.mu.RLock()
+	batchUnsupported := rpc.batchUnsupported
+	rpc.mu.RUnlock()
+
+	if batchUnsupported {
+		return rpc.fanOutRequest(ctx, calls)
+	}
+
+	for start := 0; start < len(calls); start += maxRpcBatchSize {
+		end := start + maxRpcBatchSize
+		if end > len(calls) {
+			end = len(calls)
+		}
+
+		err := rpc.postBatch(ctx, calls[start:end])
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, errBatchUnsupported) {
+			return err
+		}
+
+		log.Std.Info("rpc node rejects batch request, fallback to concurrent requests; %v", err)
+
+		rpc.mu.Lock()
+		rpc.batchUnsupported = true
+		rpc.mu.Unlock()
+
+		return rpc.fanOutRequest(ctx, calls[start:])
+	}
+
+	return nil
+}
+
+//postBatch 发送一次JSON-RPC批量请求
+func (rpc *RpcClient) postBatch(ctx context.Context, calls []*rpcCall) error {
+	reqs := make([]*JsonRpcRequest, 0, len(calls))
+	for i, c := range calls {
+		reqs = append(reqs, &JsonRpcRequest{
+			Version: "2.0",
+			Id:      strconv.Itoa(i),
+			Method:  c.Method,
+			Params:  c.Params,
+		})
+	}
+
+	data, err := json.Marshal(reqs)
+	if err != nil {
+		return fmt.Errorf("JsonRpcRequest json.Marsha error:%w", err)
+	}
+
+	body, err := rpc.withRetry(ctx, func() ([]byte, error) {
+		return rpc.postToNodes(ctx, func(node *rpcNode) ([]byte, error) {
+			return rpc.postRaw(ctx, node, data)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	//不支持批量请求的节点只返回一个错误响应
+	rsps := make([]*JsonRpcResponse, 0, len(calls))
+	if err := json.Unmarshal(body, &rsps); err != nil || len(rsps) == 0 {
+		return fmt.Errorf("%w, response: %s", errBatchUnsupported, body)
+	}
+
+	for _, rsp := range rsps {
+		i, err := strconv.Atoi(rsp.Id)
+		if err != nil || i < 0 || i >= len(calls) {
+			continue
+		}
+		if rsp.Error != 0 {
+			calls[i].Err = newRpcError(calls[i].Method, rsp)
+		} else {
+			calls[i].Result = rsp.Result
+		}
+	}
+
+	for _, c := range calls {
+		if c.Result == nil && c.Err == nil {
+			c.Err = fmt.Errorf("batch response of method %s is missing", c.Method)
+		}
+	}
+
+	return nil
+}
+
+//fanOutRequest 有限并发地逐个发送请求
+func (rpc *RpcClient) fanOutRequest(ctx context.Context, calls []*rpcCall) error {
+	parallel(len(calls), maxRpcFanOutConcurrency, func(i int) {
+		c := calls[i]
+		c.Result, c.Err = rpc.sendRpcRequestWithContext(ctx, "0", c.Method, c.Params)
+	})
+	return ctx.Err()
+}
+
+//parallel 以有限的并发数执行n个任务，全部完成后返回
+func parallel(n, limit int, fn func(i int)) {
+	var (
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, limit)
+	)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+//getBalances 批量获取地址的ONT、ONG余额和未解绑的ONG，结果与传入地址顺序一致
+func (rpc *RpcClient) getBalances(addresses ...string) ([]*AddrBalance, error) {
+
+	calls := make([]*rpcCall, 0, len(addresses)*2)
+	for _, address := range addresses {
+		params := []interface{}{address}
+		calls = append(calls,
+			&rpcCall{Method: "getbalancev2", Params: params},
+			&rpcCall{Method: "getunboundong", Params: params})
+	}
+
+	err := rpc.sendBatchRequest(context.Background(), calls)
+	if err != nil {
+		return nil, fmt.Errorf("Get address balance failed: %w", err)
+	}
+
+	list := make([]*AddrBalance, 0, len(addresses))
+	for i, address := range addresses {
+		balance, unboundong := calls[2*i], calls[2*i+1]
+		if balance.Err != nil {
+			return nil, fmt.Errorf("Get address [%s] balance failed: %w", address, balance.Err)
+		}
+		if unboundong.Err != nil {
+			return nil, fmt.Errorf("Get address [%s] unbound ONG failed: %w", address, unboundong.Err)
+		}
+
+		ret := newAddrBalance([]string{string(balance.Result), string(unboundong.Result)})
+		if ret == nil {
+			return nil, fmt.Errorf("Get address [%s] balance failed!", address)
+		}
+		ret.Address = address
+		list = append(list, ret)
+	}
+
+	return list, nil
+}
@@ -0,0 +1,244 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+)
+
+const (
+	DefaultNodeCheckInterval = 30 * time.Second //节点健康检查间隔
+	DefaultNodeMaxHeightLag  = 3                //节点落后最高高度的最大区块数
+)
+
+//rpcNode 节点状态
+type rpcNode struct {
+	addr      string
+	height    uint64
+	version   string
+	healthy   bool
+	lastError error
+	lastCheck time.Time
+}
+
+//NodeStatus 节点健康状态
+type NodeStatus struct {
+	Addr      string
+	Height    uint64
+	Version   string
+	Healthy   bool
+	LastError string
+	LastCheck time.Time
+}
+
+//parseNodeAddrs 整理节点地址列表，去掉空值和重复值
+func parseNodeAddrs(addrs ...string) []string {
+	list := make([]string, 0, len(addrs))
+	exist := make(map[string]bool)
+	for _, a := range addrs {
+		for _, s := range strings.Split(a, ",") {
+			s = strings.TrimSpace(s)
+			if len(s) == 0 || exist[s] {
+				continue
+			}
+			exist[s] = true
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+//candidates 按优先级返回可用节点，健康节点在前，不健康节点作为最后的尝试
+func (rpc *RpcClient) candidates() []*rpcNode {
+	rpc.mu.RLock()
+	defer rpc.mu.RUnlock()
+
+	healthy := make([]*rpcNode, 0, len(rpc.nodes))
+	unhealthy := make([]*rpcNode, 0)
+	for _, n := range rpc.nodes {
+		if n.healthy {
+			healthy = append(healthy, n)
+		} else {
+			unhealthy = append(unhealthy, n)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+//markNode 记录节点请求结果
+func (rpc *RpcClient) markNode(node *rpcNode, err error) {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+
+	if err != nil {
+		if node.healthy {
+			log.Std.Warning("rpc node %s is unavailable, failover to next node; unexpected error: %v", node.addr, err)
+		}
+		node.healthy = false
+		node.lastError = err
+		return
+	}
+	//探测之外的请求成功，只恢复未被判定为落后的节点
+	if node.lastError != nil {
+		node.healthy = true
+		node.lastError = nil
+	}
+}
+
+//probeNode 探测单个节点的高度和版本
+func (rpc *RpcClient) probeNode(node *rpcNode) (uint64, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), nodeProbeTimeout)
+	defer cancel()
+
+	return rpc.probe(ctx, node)
+}
+
+//CheckNodes 对所有节点进行健康检查，落后最高高度超过NodeMaxHeightLag的节点不再接收请求
+func (rpc *RpcClient) CheckNodes() {
+
+	type probeResult struct {
+		height  uint64
+		version string
+		err     error
+	}
+
+	rpc.mu.RLock()
+	nodes := make([]*rpcNode, len(rpc.nodes))
+	copy(nodes, rpc.nodes)
+	rpc.mu.RUnlock()
+
+	results := make([]probeResult, len(nodes))
+
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n *rpcNode) {
+			defer wg.Done()
+			h, v, err := rpc.probeNode(n)
+			results[i] = probeResult{height: h, version: v, err: err}
+		}(i, n)
+	}
+	wg.Wait()
+
+	bestHeight := uint64(0)
+	for _, r := range results {
+		if r.err == nil && r.height > bestHeight {
+			bestHeight = r.height
+		}
+	}
+
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+
+	now := time.Now()
+	for i, n := range nodes {
+		r := results[i]
+		n.lastCheck = now
+		if r.err != nil {
+			n.healthy = false
+			n.lastError = r.err
+			log.Std.Warning("rpc node %s health check failed; unexpected error: %v", n.addr, r.err)
+			continue
+		}
+		n.height = r.height
+		n.version = r.version
+		n.lastError = nil
+		if r.height+rpc.maxHeightLag < bestHeight {
+			n.healthy = false
+			log.Std.Warning("rpc node %s is lagging, height: %d, best height: %d", n.addr, r.height, bestHeight)
+			continue
+		}
+		n.healthy = true
+	}
+}
+
+//NodeStatus 获取所有节点的健康状态
+func (rpc *RpcClient) NodeStatus() []NodeStatus {
+	rpc.mu.RLock()
+	defer rpc.mu.RUnlock()
+
+	list := make([]NodeStatus, 0, len(rpc.nodes))
+	for _, n := range rpc.nodes {
+		s := NodeStatus{
+			Addr:      n.addr,
+			Height:    n.height,
+			Version:   n.version,
+			Healthy:   n.healthy,
+			LastCheck: n.lastCheck,
+		}
+		if n.lastError != nil {
+			s.LastError = n.lastError.Error()
+		}
+		list = append(list, s)
+	}
+	return list
+}
+
+//SetMaxHeightLag 设置节点允许落后的最大区块数
+func (rpc *RpcClient) SetMaxHeightLag(lag uint64) {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+	rpc.maxHeightLag = lag
+}
+
+//StartHealthCheck 启动定时健康检查
+func (rpc *RpcClient) StartHealthCheck(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultNodeCheckInterval
+	}
+
+	rpc.mu.Lock()
+	if rpc.stopCheck != nil {
+		rpc.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	rpc.stopCheck = stop
+	rpc.mu.Unlock()
+
+	go func() {
+		rpc.CheckNodes()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rpc.CheckNodes()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+//StopHealthCheck 停止定时健康检查
+func (rpc *RpcClient) StopHealthCheck() {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+	if rpc.stopCheck != nil {
+		close(rpc.stopCheck)
+		rpc.stopCheck = nil
+	}
+}
+
+//errNoNodes 没有配置节点
+var errNoNodes = fmt.Errorf("rpc client has no node endpoints")
@@ -1,8 +1,16 @@
 package ontology
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func Test_getBalanceByRest(t *testing.T) {
@@ -79,3 +87,368 @@ func Test_getTransaction(t *testing.T) {
 	fmt.Println(err)
 	fmt.Println(trx)
 }
+
+//testRpcError 测试节点返回的错误码
+type testRpcError int64
+
+//newTestNode 模拟JSON-RPC节点，handler按方法和参数返回result，返回testRpcError时响应该错误码
+func newTestNode(handler func(method string, params []interface{}) interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := JsonRpcRequest{}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		result := handler(req.Method, req.Params)
+		if code, ok := result.(testRpcError); ok {
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": req.Id, "error": int64(code), "desc": "ERROR", "result": ""})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": req.Id, "error": 0, "desc": "SUCCESS", "result": result})
+	}))
+}
+
+//newTestWalletManager 连接测试节点的钱包管理，请求失败不重试
+func newTestWalletManager(url string) *WalletManager {
+	wm := NewWalletManager()
+	wm.RPCClient = NewRpcClient(url)
+	wm.RPCClient.SetRetry(0, 0)
+	return wm
+}
+
+//testPreExecResult 预执行成功的结果
+func testPreExecResult(gas uint64, result interface{}) map[string]interface{} {
+	return map[string]interface{}{"State": 1, "Gas": gas, "Result": result, "Notify": []interface{}{}}
+}
+
+func newTestRpcNode(height uint64) *httptest.Server {
+	return newTestNode(func(method string, params []interface{}) interface{} {
+		switch method {
+		case "getblockcount":
+			return height
+		case "getversion":
+			return "v1.8.0"
+		}
+		return testRpcError(42002)
+	})
+}
+
+func TestRpcClient_Failover(t *testing.T) {
+	down := newTestRpcNode(100)
+	down.Close()
+	up := newTestRpcNode(100)
+	defer up.Close()
+
+	client := NewRpcClient(down.URL, up.URL)
+
+	height, err := client.getBlockHeight()
+	if err != nil {
+		t.Fatalf("getBlockHeight failed unexpected error: %v", err)
+	}
+	if height != 100 {
+		t.Errorf("height = %d, want 100", height)
+	}
+
+	status := client.NodeStatus()
+	if status[0].Healthy {
+		t.Errorf("node %s should be marked unhealthy", status[0].Addr)
+	}
+	if !status[1].Healthy {
+		t.Errorf("node %s should be healthy", status[1].Addr)
+	}
+
+	//节点返回的错误不切换节点
+	_, err = client.sendRpcRequest("0", "getunknown", []interface{}{})
+	if err == nil {
+		t.Errorf("getunknown should fail")
+	}
+	if !client.NodeStatus()[1].Healthy {
+		t.Errorf("node should stay healthy after a node error response")
+	}
+}
+
+func TestRpcClient_CheckNodes(t *testing.T) {
+	best := newTestRpcNode(100)
+	defer best.Close()
+	lagging := newTestRpcNode(90)
+	defer lagging.Close()
+
+	client := NewRpcClient(lagging.URL, best.URL)
+	client.SetMaxHeightLag(3)
+	client.CheckNodes()
+
+	status := client.NodeStatus()
+	if status[0].Healthy {
+		t.Errorf("lagging node %s should be dropped", status[0].Addr)
+	}
+	if !status[1].Healthy || status[1].Height != 100 || status[1].Version != "v1.8.0" {
+		t.Errorf("unexpected best node status: %+v", status[1])
+	}
+
+	nodes := client.candidates()
+	if nodes[0].addr != best.URL {
+		t.Errorf("first candidate = %s, want %s", nodes[0].addr, best.URL)
+	}
+}
+
+func TestRpcClient_Retry(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": "0", "error": 0, "desc": "SUCCESS", "result": 100})
+	}))
+	defer server.Close()
+
+	client := NewRpcClient(server.URL)
+	client.SetRetry(3, time.Millisecond)
+
+	height, err := client.getBlockHeight()
+	if err != nil {
+		t.Fatalf("getBlockHeight failed unexpected error: %v", err)
+	}
+	if height != 100 || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("height = %d, calls = %d", height, calls)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	client.SetRetry(1, time.Millisecond)
+	_, err = client.getBlockHeight()
+	if !errors.Is(err, ErrNodeUnavailable) {
+		t.Errorf("err = %v, want ErrNodeUnavailable", err)
+	}
+}
+
+func TestRpcClient_TypedErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := JsonRpcRequest{}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch req.Method {
+		case "sendrawtransaction":
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": req.Id, "error": 43001, "desc": "INVALID TRANSACTION", "result": "duplicated transaction detected"})
+		case "getrawtransaction":
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": req.Id, "error": 44001, "desc": "UNKNOWN TRANSACTION", "result": ""})
+		}
+	}))
+	defer server.Close()
+
+	client := NewRpcClient(server.URL)
+
+	_, err := client.sendRpcRequest("0", "sendrawtransaction", []interface{}{"00"})
+	if !errors.Is(err, ErrDuplicateTransaction) || !errors.Is(err, ErrInvalidTransaction) {
+		t.Errorf("err = %v, want ErrDuplicateTransaction", err)
+	}
+	var rpcErr *RpcError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != ErrCodeInvalidTransaction {
+		t.Errorf("err = %v, want RpcError with code %d", err, ErrCodeInvalidTransaction)
+	}
+
+	_, err = client.getTransaction("0000")
+	if !errors.Is(err, ErrUnknownTransaction) {
+		t.Errorf("err = %v, want ErrUnknownTransaction", err)
+	}
+	if errors.Is(err, ErrDuplicateTransaction) {
+		t.Errorf("err = %v should not be ErrDuplicateTransaction", err)
+	}
+}
+
+func TestRpcError_Is(t *testing.T) {
+	invalid := func(result string) *RpcError {
+		return &RpcError{Code: ErrCodeInvalidTransaction, Desc: "INVALID TRANSACTION", Result: result}
+	}
+
+	tests := []struct {
+		name   string
+		err    *RpcError
+		target error
+		want   bool
+	}{
+		{"duplicated tx", invalid(`"duplicated transaction detected"`), ErrDuplicateTransaction, true},
+		{"duplicated input", invalid(`"duplicated transaction input detected"`), ErrDuplicateTransaction, true},
+		{"in tx pool", invalid(`"transaction ` + strings.Repeat("ab", 32) + ` is already in the tx pool"`), ErrDuplicateTransaction, true},
+		{"low gas", invalid(`"Please input gasLimit >= 20000 and gasPrice >= 2500"`), ErrInsufficientGas, true},
+		{"rest duplicated code", &RpcError{Code: ErrCodeTxPoolDuplicateInput}, ErrDuplicateTransaction, true},
+		{"rest gas price code", &RpcError{Code: ErrCodeTxPoolGasPrice}, ErrInsufficientGas, true},
+		{"duplicate key in contract", invalid(`"[NeoVmService] service system call error: duplicate key"`), ErrDuplicateTransaction, false},
+		{"gas balance query", invalid(`"get gas balance failed"`), ErrInsufficientGas, false},
+		{"gas too low in desc", &RpcError{Code: ErrCodeInternalError, Desc: "gas price too low", Result: `""`}, ErrInsufficientGas, false},
+		{"duplicated reason with other code", &RpcError{Code: ErrCodeInternalError, Result: `"duplicated transaction detected"`}, ErrDuplicateTransaction, false},
+		{"low gas is not duplicate", invalid(`"Please input gasLimit >= 20000 and gasPrice >= 2500"`), ErrDuplicateTransaction, false},
+	}
+	for _, test := range tests {
+		if got := errors.Is(test.err, test.target); got != test.want {
+			t.Errorf("%s: errors.Is(%v, %v) = %v, want %v", test.name, test.err, test.target, got, test.want)
+		}
+	}
+}
+
+func TestRpcClient_ContextCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	client := NewRpcClient(server.URL)
+	client.SetRetry(10, time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.Call(ctx, "getblockcount", []interface{}{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("call should stop retrying when the context is done")
+	}
+}
+
+func newTestBatchRpcNode(batch bool, requests *int32) *httptest.Server {
+	answer := func(req JsonRpcRequest) map[string]interface{} {
+		if req.Method != "getblockhash" {
+			return map[string]interface{}{"id": req.Id, "error": 42002, "desc": "INVALID PARAMS", "result": ""}
+		}
+		return map[string]interface{}{"id": req.Id, "error": 0, "desc": "SUCCESS", "result": fmt.Sprintf("hash%v", req.Params[0])}
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		raw := json.RawMessage{}
+		json.NewDecoder(r.Body).Decode(&raw)
+
+		reqs := make([]JsonRpcRequest, 0)
+		if err := json.Unmarshal(raw, &reqs); err == nil {
+			if !batch {
+				json.NewEncoder(w).Encode(map[string]interface{}{"id": "", "error": 41003, "desc": "ILLEGAL DATAFORMAT", "result": ""})
+				return
+			}
+			rsps := make([]map[string]interface{}, 0, len(reqs))
+			for _, req := range reqs {
+				rsps = append(rsps, answer(req))
+			}
+			json.NewEncoder(w).Encode(rsps)
+			return
+		}
+
+		req := JsonRpcRequest{}
+		json.Unmarshal(raw, &req)
+		json.NewEncoder(w).Encode(answer(req))
+	}))
+}
+
+func testBatchCalls() []*rpcCall {
+	return []*rpcCall{
+		{Method: "getblockhash", Params: []interface{}{1}},
+		{Method: "getblockhash", Params: []interface{}{2}},
+		{Method: "getbalancev2", Params: []interface{}{"bad"}},
+	}
+}
+
+func checkBatchCalls(t *testing.T, calls []*rpcCall) {
+	for i, c := range calls[:2] {
+		want := fmt.Sprintf("\"hash%d\"", i+1)
+		if c.Err != nil || string(c.Result) != want {
+			t.Errorf("call %d = %s, %v, want %s", i, c.Result, c.Err, want)
+		}
+	}
+	if !errors.Is(calls[2].Err, ErrInvalidParams) {
+		t.Errorf("call 2 error = %v, want ErrInvalidParams", calls[2].Err)
+	}
+}
+
+func TestRpcClient_BatchRequest(t *testing.T) {
+	var requests int32
+	node := newTestBatchRpcNode(true, &requests)
+	defer node.Close()
+
+	client := NewRpcClient(node.URL)
+	calls := testBatchCalls()
+	if err := client.sendBatchRequest(context.Background(), calls); err != nil {
+		t.Fatalf("sendBatchRequest failed unexpected error: %v", err)
+	}
+	checkBatchCalls(t, calls)
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
+
+func TestRpcClient_BatchFallback(t *testing.T) {
+	var requests int32
+	node := newTestBatchRpcNode(false, &requests)
+	defer node.Close()
+
+	client := NewRpcClient(node.URL)
+	calls := testBatchCalls()
+	if err := client.sendBatchRequest(context.Background(), calls); err != nil {
+		t.Fatalf("sendBatchRequest failed unexpected error: %v", err)
+	}
+	checkBatchCalls(t, calls)
+	if n := atomic.LoadInt32(&requests); n != 4 {
+		t.Errorf("requests = %d, want 4", n)
+	}
+
+	//节点不支持批量请求后不再尝试
+	atomic.StoreInt32(&requests, 0)
+	calls = testBatchCalls()
+	if err := client.sendBatchRequest(context.Background(), calls); err != nil {
+		t.Fatalf("sendBatchRequest failed unexpected error: %v", err)
+	}
+	checkBatchCalls(t, calls)
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+}
+
+func TestParseBlockEvents(t *testing.T) {
+	resp := []byte(`[{"TxHash":"tx1","State":1,"GasConsumed":0,"Notify":[]},{"State":1},{"TxHash":"tx2","State":1,"GasConsumed":0,"Notify":[]}]`)
+	events, err := parseBlockEvents(resp)
+	if err != nil {
+		t.Fatalf("parseBlockEvents failed unexpected error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("events = %v, want tx1 and tx2", events)
+	}
+	for _, txid := range []string{"tx1", "tx2"} {
+		if _, ok := events[txid]; !ok {
+			t.Errorf("events of %s not found", txid)
+		}
+	}
+
+	events, err = parseBlockEvents([]byte("null"))
+	if err != nil || len(events) != 0 {
+		t.Errorf("parseBlockEvents(null) = %v, %v, want empty", events, err)
+	}
+}
+
+func TestParseNotifys_OEP4(t *testing.T) {
+	governance := "0000000000000000000000000000000000000007"
+	ont := "0000000000000000000000000000000000000001"
+	resp := []byte(`{"TxHash":"tx1","State":1,"Notify":[
+		{"ContractAddress":"a1b2c3d4e5f60718293a4b5c6d7e8f9011223344","States":["7472616e73666572","` + governance + `","` + ont + `","00e1f505"]},
+		{"ContractAddress":"a1b2c3d4e5f60718293a4b5c6d7e8f9011223344","States":["617070726f7665","` + governance + `","` + ont + `","01"]},
+		{"ContractAddress":"a1b2c3d4e5f60718293a4b5c6d7e8f9011223344","States":["7472616e73666572","` + governance + `","` + ont + `","ff"]},
+		{"ContractAddress":"0200000000000000000000000000000000000000","States":["transfer","AFmseVrdL9f9oyCzZefL9tG6UbvhUMqNMV","AFmseVrdL9f9oyCzZefL9tG6UbviEH9ugK",10000000]}
+	]}`)
+
+	notifys, err := parseNotifys(resp)
+	if err != nil {
+		t.Fatalf("parseNotifys failed unexpected error: %v", err)
+	}
+	if len(notifys) != 2 {
+		t.Fatalf("notifys = %+v, want OEP-4 transfer and ONG fee", notifys)
+	}
+
+	transfer := notifys[0]
+	if transfer.ContractAddress != "a1b2c3d4e5f60718293a4b5c6d7e8f9011223344" || transfer.Method != "transfer" {
+		t.Errorf("unexpected OEP-4 notify: %+v", transfer)
+	}
+	if transfer.From != "AFmseVrdL9f9oyCzZefL9tG6UbviEH9ugK" || transfer.To != "AFmseVrdL9f9oyCzZefL9tG6UbvhUMqNMV" {
+		t.Errorf("OEP-4 transfer from %s to %s", transfer.From, transfer.To)
+	}
+	if transfer.Amount != "100000000" {
+		t.Errorf("OEP-4 transfer amount = %s, want 100000000", transfer.Amount)
+	}
+	if !notifys[1].IsFee {
+		t.Errorf("native ONG notify should be fee: %+v", notifys[1])
+	}
+}
@@ -16,18 +16,21 @@
 package ontology
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"math/big"
 	"sort"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/blocktree/go-owcdrivers/ontologyTransaction"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
 )
 
 type TransactionDecoder struct {
@@ -97,10 +100,18 @@ func (decoder *TransactionDecoder) CreateONTRawTransaction(wrapper openwallet.Wa
 
 	var (
 		txState  ontologyTransaction.TxStateV2
-		gasPrice = ontologyTransaction.DefaultGasPrice
-		gasLimit = ontologyTransaction.DefaultGasLimit
+		gasPrice uint64
+		gasLimit uint64
 	)
 
+	multiSig, err := multiSigFromExtParam(rawTx.ExtParam)
+	if err != nil {
+		return err
+	}
+	if multiSig != nil {
+		return decoder.createMultiSigRawTransaction(wrapper, rawTx, multiSig)
+	}
+
 	addresses, err := wrapper.GetAddressList(0, 2000, "AccountID", rawTx.Account.AccountID)
 
 	if err != nil {
@@ -111,14 +122,18 @@ func (decoder *TransactionDecoder) CreateONTRawTransaction(wrapper openwallet.Wa
 		return openwallet.Errorf(openwallet.ErrAccountNotAddress, "[%s] have not addresses", rawTx.Account.AccountID)
 	}
 
-	addressesBalanceList := make([]AddrBalance, 0, len(addresses))
+	searchAddrs := make([]string, 0, len(addresses))
+	for _, addr := range addresses {
+		searchAddrs = append(searchAddrs, addr.Address)
+	}
 
-	for i, addr := range addresses {
-		balance, err := decoder.wm.RPCClient.getBalance(addr.Address)
+	balances, err := decoder.wm.RPCClient.getBalances(searchAddrs...)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			return err
-		}
+	addressesBalanceList := make([]AddrBalance, 0, len(addresses))
+	for i, balance := range balances {
 		balance.index = i
 		addressesBalanceList = append(addressesBalanceList, *balance)
 	}
@@ -127,28 +142,23 @@ func (decoder *TransactionDecoder) CreateONTRawTransaction(wrapper openwallet.Wa
 		return addressesBalanceList[i].ONTBalance.Cmp(addressesBalanceList[j].ONTBalance) >= 0
 	})
 
-	if rawTx.FeeRate != "" {
-		feeprice, err := convertIntStringToBigInt(rawTx.FeeRate)
-		if err != nil {
-			return errors.New("fee rate passed through error")
-		}
-		gasPrice = feeprice.Uint64()
-	} else {
-		if decoder.wm.Config.GasPriceType == 0 {
-			gasPrice = decoder.wm.Config.GasPriceFixed
-		} else {
-			gasPrice, err = decoder.wm.RPCClient.getGasPrice()
-			if err != nil {
-				return err
-			}
-		}
+	gasPrice, gasLimit, err = decoder.gasSettings(rawTx)
+	if err != nil {
+		return err
 	}
 
-	if decoder.wm.Config.GasLimit != 0 {
-		gasLimit = decoder.wm.Config.GasLimit
+	fee := big.NewInt(int64(gasLimit * gasPrice))
+	feeInONG := gasFeeInONG(gasPrice, gasLimit)
+
+	payer, err := decoder.feePayer(wrapper, rawTx, feeInONG)
+	if err != nil {
+		return err
 	}
 
-	fee := big.NewInt(int64(gasLimit * gasPrice))
+	//多个接收地址的ONT、ONG转账合并为一笔交易
+	if len(rawTx.To) > 1 && (rawTx.Coin.Contract.Address == ontologyTransaction.ONTContractAddress || rawTx.Coin.Contract.Address == ontologyTransaction.ONGContractAddress) {
+		return decoder.createNativeTransferRawTransaction(wrapper, rawTx, addressesBalanceList, gasPrice, gasLimit, payer)
+	}
 
 	var amountStr, to string
 	for k, v := range rawTx.To {
@@ -169,19 +179,26 @@ func (decoder *TransactionDecoder) CreateONTRawTransaction(wrapper openwallet.Wa
 			txState.From = to
 			txState.To = to
 
+			found := false
 			for i, a := range addressesBalanceList {
 				if a.Address != to {
 					continue
 				}
+				found = true
 				if a.ONGUnbound.Cmp(big.NewInt(0)) == 0 {
 					return fmt.Errorf("No unbound ONG to withdraw in address : " + to)
 				}
 
-				if a.ONGUnbound.Cmp(fee) <= 0 {
-					return fmt.Errorf("Unbound ONG is not enough to withdraw in address : " + to)
+				//第三方支付手续费时提取全部未解绑的ONG
+				if payer != "" && payer != to {
+					txState.Payer = payer
+					txState.Amount = new(big.Int).Set(a.ONGUnbound)
+				} else {
+					if a.ONGUnbound.Cmp(fee) <= 0 {
+						return fmt.Errorf("Unbound ONG is not enough to withdraw in address : " + to)
+					}
+					txState.Amount = amount.Sub(a.ONGUnbound, fee)
 				}
-
-				txState.Amount = amount.Sub(a.ONGUnbound, fee)
 				keySignList = append(keySignList, &openwallet.KeySignature{
 					Address: &openwallet.Address{
 						AccountID:   addresses[addressesBalanceList[i].index].AccountID,
@@ -202,7 +219,7 @@ func (decoder *TransactionDecoder) CreateONTRawTransaction(wrapper openwallet.Wa
 				break
 			}
 
-			if amount.Cmp(big.NewInt(0)) == 0 {
+			if !found {
 				return fmt.Errorf("Address : " + to + " not found!")
 			}
 
@@ -210,21 +227,9 @@ func (decoder *TransactionDecoder) CreateONTRawTransaction(wrapper openwallet.Wa
 			txState.AssetType = ontologyTransaction.AssetONG
 			txState.Amount = amount
 			txState.To = to
-			count := big.NewInt(0)
-			countList := []uint64{}
+			txState.Payer = payer
 			for _, a := range addressesBalanceList {
 				if a.ONGBalance.Cmp(amount) < 0 {
-					count.Add(count, a.ONGBalance)
-					if count.Cmp(amount) >= 0 {
-						countList = append(countList, a.ONGBalance.Sub(a.ONGBalance, count.Sub(count, amount)).Uint64())
-						return fmt.Errorf("The ONG of the account is enough," +
-							" but cannot be sent in just one transaction!\n" +
-							"the amount can be sent in " + fmt.Sprint(len(countList)) +
-							"times with amounts :\n" + strings.Replace(strings.Trim(fmt.Sprint(countList), "[]"), " ", ",", -1))
-
-					} else {
-						countList = append(countList, a.ONGBalance.Uint64())
-					}
 					continue
 				}
 				txState.From = a.Address
@@ -232,7 +237,8 @@ func (decoder *TransactionDecoder) CreateONTRawTransaction(wrapper openwallet.Wa
 			}
 
 			if txState.From == "" {
-				return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "the balance: %s is not enough", amountStr)
+				//没有单个地址余额足够，由多个地址共同转出
+				return decoder.createNativeTransferRawTransaction(wrapper, rawTx, addressesBalanceList, gasPrice, gasLimit, payer)
 			}
 		}
 	} else if rawTx.Coin.Contract.Address == ontologyTransaction.ONTContractAddress { // ONT transaction
@@ -243,39 +249,35 @@ func (decoder *TransactionDecoder) CreateONTRawTransaction(wrapper openwallet.Wa
 		txState.AssetType = ontologyTransaction.AssetONT
 		txState.Amount = amount
 		txState.To = to
-		count := big.NewInt(0)
-		countList := []uint64{}
+		txState.Payer = payer
 		for _, a := range addressesBalanceList {
 			if a.ONTBalance.Cmp(amount) < 0 {
-				count.Add(count, a.ONTBalance)
-				if count.Cmp(amount) >= 0 {
-					countList = append(countList, a.ONTBalance.Sub(a.ONTBalance, count.Sub(count, amount)).Uint64())
-					return fmt.Errorf("The ONT of the account is enough," +
-						" but cannot be sent in just one transaction!\n" +
-						"the amount can be sent in " + fmt.Sprint(len(countList)) +
-						"times with amounts :\n" + strings.Replace(strings.Trim(fmt.Sprint(countList), "[]"), " ", ",", -1))
-				} else {
-					countList = append(countList, a.ONTBalance.Uint64())
-				}
 				continue
 			}
 			txState.From = a.Address
-			if a.ONGBalance.Cmp(fee) < 0 {
+			//没有第三方支付手续费时，转出地址需要有足够的ONG
+			if payer == "" && a.ONGBalance.Cmp(feeInONG) < 0 {
 				return fmt.Errorf("No enough ONG to send ONT on address :" + a.Address)
 			}
 			break
 		}
 
 		if txState.From == "" {
-			return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "the balance: %s is not enough", amountStr)
-
+			//没有单个地址余额足够，由多个地址共同转出
+			return decoder.createNativeTransferRawTransaction(wrapper, rawTx, addressesBalanceList, gasPrice, gasLimit, payer)
 		}
-	} else { // other contract
-		return fmt.Errorf("Contract " + rawTx.Coin.Contract.Address + " is not supported yet!")
+	} else { // OEP-4 token
+		return decoder.createOEP4RawTransaction(wrapper, rawTx, addressesBalanceList, gasPrice, gasLimit, to, amountStr, payer)
 	}
 
-	feeInONG, _ := convertBigIntToFloatDecimal(fee.String())
-	rawTx.Fees = feeInONG.String()
+	gasLimit, err = decoder.estimateTxStateGas(&txState, gasPrice, gasLimit, false)
+	if err != nil {
+		return err
+	}
+	fee = big.NewInt(int64(gasLimit * gasPrice))
+
+	fees, _ := convertBigIntToFloatDecimal(fee.String())
+	rawTx.Fees = fees.String()
 	rawTx.TxFrom = []string{txState.From}
 	rawTx.TxTo = []string{txState.To}
 	if txState.AssetType == ontologyTransaction.AssetONT {
@@ -298,8 +300,7 @@ func (decoder *TransactionDecoder) CreateONTRawTransaction(wrapper openwallet.Wa
 		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
 	}
 
-	keySigs := make([]*openwallet.KeySignature, 0)
-
+	//手续费支付地址可能属于其他账户，按地址所属账户装配签名
 	for _, address := range transHash.Addresses {
 		addr, err := wrapper.GetAddress(address)
 		if err != nil {
@@ -312,11 +313,9 @@ func (decoder *TransactionDecoder) CreateONTRawTransaction(wrapper openwallet.Wa
 			Message: transHash.GetTxHashHex(),
 		}
 
-		keySigs = append(keySigs, &signature)
+		rawTx.Signatures[addr.AccountID] = append(rawTx.Signatures[addr.AccountID], &signature)
 	}
 
-	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
-
 	rawTx.FeeRate = big.NewInt(int64(gasPrice)).String()
 
 	rawTx.IsBuilt = true
@@ -339,6 +338,15 @@ func (decoder *TransactionDecoder) SignONTRawTransaction(wrapper openwallet.Wall
 		if keySignatures != nil {
 			for _, keySignature := range keySignatures {
 
+				//多重签名中其他钱包的签名者，没有本钱包的衍生路径
+				if keySignature.Address.HDPath == "" {
+					addr, err := wrapper.GetAddress(keySignature.Address.Address)
+					if err != nil || addr.HDPath == "" {
+						continue
+					}
+					keySignature.Address.HDPath = addr.HDPath
+				}
+
 				childKey, err := key.DerivedKeyWithPath(keySignature.Address.HDPath, keySignature.EccType)
 				keyBytes, err := childKey.GetPrivateKeyBytes()
 				if err != nil {
@@ -385,6 +393,14 @@ func (decoder *TransactionDecoder) VerifyONTRawTransaction(wrapper openwallet.Wa
 		sigPubs    = make([]ontologyTransaction.SigPub, 0)
 	)
 
+	multiSig, err := multiSigFromExtParam(rawTx.ExtParam)
+	if err != nil {
+		return err
+	}
+	if multiSig != nil {
+		return decoder.verifyMultiSigRawTransaction(rawTx, multiSig)
+	}
+
 	for accountID, keySignatures := range rawTx.Signatures {
 		log.Debug("accountID Signatures:", accountID)
 
@@ -422,6 +438,89 @@ func (decoder *TransactionDecoder) VerifyONTRawTransaction(wrapper openwallet.Wa
 	return nil
 }
 
+//verifyMultiSigRawTransaction 验证多重签名交易，至少有M个有效签名时合并为一个多重签名项，
+//其他地址（如单独的手续费支付地址）的签名作为单签名项
+func (decoder *TransactionDecoder) verifyMultiSigRawTransaction(rawTx *openwallet.RawTransaction, multiSig *MultiSig) error {
+	emptyTrans, err := hex.DecodeString(rawTx.RawHex)
+	if err != nil || len(emptyTrans) < 2 || emptyTrans[len(emptyTrans)-1] != 0 {
+		return fmt.Errorf("invalid empty transaction hex")
+	}
+	unsigned := emptyTrans[:len(emptyTrans)-1]
+	hash := sha256.Sum256(unsigned)
+	hash = sha256.Sum256(hash[:])
+
+	pubs, err := multiSig.sortedPublicKeys()
+	if err != nil {
+		return err
+	}
+	program, _ := multiSig.Program()
+
+	//按公钥收集签名
+	signatures := make(map[string][]byte)
+	singles := make([]string, 0)
+	for accountID, keySignatures := range rawTx.Signatures {
+		log.Debug("accountID Signatures:", accountID)
+		for _, keySignature := range keySignatures {
+			if keySignature.Signature == "" {
+				continue
+			}
+			signature, _ := hex.DecodeString(keySignature.Signature)
+			pubkey, _ := hex.DecodeString(keySignature.Address.PublicKey)
+			if _, ok := signatures[string(pubkey)]; !ok {
+				singles = append(singles, string(pubkey))
+			}
+			signatures[string(pubkey)] = signature
+		}
+	}
+
+	multiSigs := make([][]byte, 0, multiSig.Required)
+	for _, pub := range pubs {
+		signature, ok := signatures[string(pub)]
+		delete(signatures, string(pub))
+		if !ok || uint64(len(multiSigs)) == multiSig.Required {
+			continue
+		}
+		if !verifySignature(pub, hash[:], signature) {
+			log.Debug("invalid signature of public key:", hex.EncodeToString(pub))
+			continue
+		}
+		multiSigs = append(multiSigs, signature)
+	}
+	if uint64(len(multiSigs)) < multiSig.Required {
+		log.Debug("transaction verify failed, valid signatures:", len(multiSigs), "required:", multiSig.Required)
+		rawTx.IsCompleted = false
+		return nil
+	}
+
+	buf := bytes.NewBuffer(unsigned)
+	entries := 1
+	for _, pub := range singles {
+		if _, ok := signatures[pub]; ok {
+			entries++
+		}
+	}
+	writeVarUint(buf, uint64(entries))
+	for _, pub := range singles {
+		signature, ok := signatures[pub]
+		if !ok {
+			continue
+		}
+		if !verifySignature([]byte(pub), hash[:], signature) {
+			log.Debug("transaction verify failed, invalid signature of public key:", hex.EncodeToString([]byte(pub)))
+			rawTx.IsCompleted = false
+			return nil
+		}
+		writeSigEntry(buf, [][]byte{signature}, publicKeyProgram([]byte(pub)))
+	}
+	writeSigEntry(buf, multiSigs, program)
+
+	log.Debug("transaction verify passed")
+	rawTx.IsCompleted = true
+	rawTx.RawHex = hex.EncodeToString(buf.Bytes())
+
+	return nil
+}
+
 //CreateSummaryRawTransactionWithError 创建汇总交易，返回能原始交易单数组（包含带错误的原始交易单）
 func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
 	raTxWithErr := make([]*openwallet.RawTransactionWithError, 0)
@@ -529,7 +628,25 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransaction(wrapper openwalle
 		}
 
 	} else {
-		//
+		//OEP-4代币的最低转账和保留余额按代币精度换算为最小单位
+		decimals := int(sumRawTx.Coin.Contract.Decimals)
+		if sumRawTx.MinTransfer != "" {
+			minTransfer, err = convertFloatStringToBigInt(sumRawTx.MinTransfer, decimals)
+			if err != nil {
+				return nil, fmt.Errorf("invalid min transfer %s of token %s: %w", sumRawTx.MinTransfer, sumRawTx.Coin.Contract.Token, err)
+			}
+		}
+		if sumRawTx.RetainedBalance != "" {
+			retainedBalance, err = convertFloatStringToBigInt(sumRawTx.RetainedBalance, decimals)
+			if err != nil {
+				return nil, fmt.Errorf("invalid retained balance %s of token %s: %w", sumRawTx.RetainedBalance, sumRawTx.Coin.Contract.Token, err)
+			}
+		}
+	}
+
+	//汇总交易创建时按预执行估算gasLimit，这里使用配置或最低的gasLimit筛选手续费足够的地址
+	if decoder.wm.Config.GasLimit != 0 {
+		gasLimit = decoder.wm.Config.GasLimit
 	}
 
 	fee := big.NewInt(int64(gasLimit * gasPrice))
@@ -581,15 +698,20 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransaction(wrapper openwalle
 			return nil, fmt.Errorf("No addresses found in fee support account!")
 		}
 
-		ongContains := big.NewInt(0)
+		feeSupportAddrs := make([]string, 0, len(feeSupportAddresses))
 		for _, addr := range feeSupportAddresses {
-			balance, err := decoder.wm.RPCClient.getBalance(addr.Address)
+			feeSupportAddrs = append(feeSupportAddrs, addr.Address)
+		}
 
-			if err != nil {
-				return nil, err
-			}
+		balances, err := decoder.wm.RPCClient.getBalances(feeSupportAddrs...)
+		if err != nil {
+			return nil, err
+		}
+
+		ongContains := big.NewInt(0)
+		for _, balance := range balances {
 			ongContains.Add(ongContains, balance.ONGBalance)
-			feeSupports.fs = append(feeSupports.fs, feeSupport{address: addr.Address, amount: balance.ONGBalance})
+			feeSupports.fs = append(feeSupports.fs, feeSupport{address: balance.Address, amount: balance.ONGBalance})
 		}
 
 		feeInOng := big.NewInt(extraFee * int64(gasLimit*gasPrice))
@@ -738,6 +860,47 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransaction(wrapper openwalle
 				rawTxArray = append(rawTxArray, rawTx)
 			}
 
+		} else {
+			//OEP-4代币，手续费由转出地址或手续费账户的ONG支付
+			addrBalance_BI, ok := new(big.Int).SetString(addrBalance.Balance, 10)
+			if !ok || addrBalance_BI.Cmp(minTransfer) < 0 {
+				continue
+			}
+			//计算汇总数量 = 余额 - 保留余额
+			sumAmount_BI := new(big.Int).Sub(addrBalance_BI, retainedBalance)
+			if sumAmount_BI.Sign() <= 0 {
+				continue
+			}
+			sumAmountDecimal, _ := convertBigIntToFloatViaDecimal(sumAmount_BI.String(), int(sumRawTx.Coin.Contract.Decimals))
+
+			log.Debugf("sumAmount: %v", sumAmountDecimal.String())
+
+			payer := ""
+			if !feeEnough[i] {
+				feeExtra = feeExtra.Add(feeExtra, fee)
+				payer = feeSupports.getFeeAddress(feeExtra)
+			}
+
+			//创建一笔交易单
+			rawTx := &openwallet.RawTransaction{
+				Coin:    sumRawTx.Coin,
+				Account: sumRawTx.Account,
+				To: map[string]string{
+					sumRawTx.SummaryAddress: sumAmountDecimal.String(),
+				},
+				Required: 1,
+			}
+			createErr := decoder.createRawTransaction(
+				wrapper,
+				rawTx,
+				addrBalance,
+				payer)
+			if createErr != nil {
+				return nil, createErr
+			}
+
+			//创建成功，添加到队列
+			rawTxArray = append(rawTxArray, rawTx)
 		}
 
 	}
@@ -747,30 +910,14 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransaction(wrapper openwalle
 func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, addrBalance *openwallet.Balance, payer string) error {
 	var (
 		txState  ontologyTransaction.TxStateV2
-		gasPrice = ontologyTransaction.DefaultGasPrice
-		gasLimit = ontologyTransaction.DefaultGasLimit
+		gasPrice uint64
+		gasLimit uint64
 		err      error
 	)
 
-	if rawTx.FeeRate != "" {
-		feeprice, err := convertIntStringToBigInt(rawTx.FeeRate)
-		if err != nil {
-			return errors.New("fee rate passed through error")
-		}
-		gasPrice = feeprice.Uint64()
-	} else {
-		if decoder.wm.Config.GasPriceType == 0 {
-			gasPrice = decoder.wm.Config.GasPriceFixed
-		} else {
-			gasPrice, err = decoder.wm.RPCClient.getGasPrice()
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	if decoder.wm.Config.GasLimit != 0 {
-		gasLimit = decoder.wm.Config.GasLimit
+	gasPrice, gasLimit, err = decoder.gasSettings(rawTx)
+	if err != nil {
+		return err
 	}
 
 	fee := big.NewInt(int64(gasLimit * gasPrice))
@@ -805,10 +952,21 @@ func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.Walle
 		txState.Payer = payer
 		txState.From = addrBalance.Address
 
-	} else { // other contract
-		return fmt.Errorf("Contract " + rawTx.Coin.Contract.Address + " is not supported yet!")
+	} else { // OEP-4 token
+		amount, err := convertFloatStringToBigInt(amountStr, int(rawTx.Coin.Contract.Decimals))
+		if err != nil {
+			return fmt.Errorf("invalid amount %s of token %s: %w", amountStr, rawTx.Coin.Contract.Token, err)
+		}
+		return decoder.buildOEP4RawTransaction(wrapper, rawTx, addrBalance.Address, to, payer, amount, amountStr, gasPrice, gasLimit)
 	}
 
+	//汇总的ONG已经扣除创建时的手续费，估算的手续费更多时从汇总数量中扣除增加的部分
+	gasLimit, err = decoder.estimateTxStateGas(&txState, gasPrice, gasLimit, true)
+	if err != nil {
+		return err
+	}
+	fee = big.NewInt(int64(gasLimit * gasPrice))
+
 	feeInONG, _ := convertBigIntToFloatDecimal(fee.String())
 	rawTx.Fees = feeInONG.String()
 	rawTx.TxFrom = []string{txState.From}
@@ -816,6 +974,9 @@ func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.Walle
 	if txState.AssetType == ontologyTransaction.AssetONT {
 		rawTx.TxAmount = amountStr
 	} else if txState.AssetType == ontologyTransaction.AssetONG {
+		sumAmount, _ := convertBigIntToFloatViaDecimal(txState.Amount.String(), int(rawTx.Coin.Contract.Decimals))
+		amountStr = sumAmount.String()
+		rawTx.To = map[string]string{to: amountStr}
 		rawTx.TxAmount = amountStr
 
 	} else {
@@ -865,11 +1026,510 @@ func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.Walle
 	return nil
 }
 
+//createOEP4RawTransaction 选择代币余额足够且有ONG支付手续费的地址，创建OEP-4代币转账交易
+func (decoder *TransactionDecoder) createOEP4RawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, addressesBalanceList []AddrBalance, gasPrice, gasLimit uint64, to, amountStr, payer string) error {
+	contract := rawTx.Coin.Contract
+	fee := gasFeeInONG(gasPrice, gasLimit)
+
+	amount, err := convertFloatStringToBigInt(amountStr, int(contract.Decimals))
+	if err != nil {
+		return fmt.Errorf("invalid amount %s of token %s: %w", amountStr, contract.Token, err)
+	}
+	if amount.Sign() <= 0 {
+		return fmt.Errorf("invalid amount %s of token %s", amountStr, contract.Token)
+	}
+
+	searchAddrs := make([]string, 0, len(addressesBalanceList))
+	for _, a := range addressesBalanceList {
+		searchAddrs = append(searchAddrs, a.Address)
+	}
+
+	tokenBalances, err := decoder.wm.getOEP4Balances(contract.Address, searchAddrs...)
+	if err != nil {
+		return err
+	}
+
+	//代币余额从大到小选择转出地址
+	candidates := make([]int, len(addressesBalanceList))
+	for i := range candidates {
+		candidates[i] = i
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return tokenBalances[candidates[i]].Cmp(tokenBalances[candidates[j]]) > 0
+	})
+
+	from, lackOfFee := "", ""
+	for _, i := range candidates {
+		a := addressesBalanceList[i]
+		if tokenBalances[i].Cmp(amount) < 0 {
+			break
+		}
+		if payer == "" && a.ONGBalance.Cmp(fee) < 0 {
+			lackOfFee = a.Address
+			continue
+		}
+		from = a.Address
+		break
+	}
+
+	if from == "" {
+		if lackOfFee != "" {
+			return openwallet.Errorf(openwallet.ErrInsufficientFees, "No enough ONG to send %s on address: %s", contract.Token, lackOfFee)
+		}
+		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "the balance: %s is not enough", amountStr)
+	}
+
+	return decoder.buildOEP4RawTransaction(wrapper, rawTx, from, to, payer, amount, amountStr, gasPrice, gasLimit)
+}
+
+//buildOEP4RawTransaction 创建调用OEP-4合约transfer的交易，payer为空时由转出地址支付手续费
+func (decoder *TransactionDecoder) buildOEP4RawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, from, to, payer string, amount *big.Int, amountStr string, gasPrice, gasLimit uint64) error {
+	code, err := buildOEP4TransferCode(rawTx.Coin.Contract.Address, from, to, amount)
+	if err != nil {
+		return err
+	}
+
+	if payer == "" {
+		payer = from
+	}
+
+	tx, err := newInvokeTransaction(gasPrice, gasLimit, payer, code)
+	if err != nil {
+		return err
+	}
+
+	if err := decoder.estimateInvokeGas(tx); err != nil {
+		return err
+	}
+
+	rawTx.TxFrom = []string{from}
+	rawTx.TxTo = []string{to}
+	rawTx.TxAmount = amountStr
+
+	return decoder.buildInvokeRawTransaction(wrapper, rawTx, tx, from)
+}
+
+//feePayer 读取 rawTx.ExtParam 中指定的手续费支付地址 feePayer 或账户 feePayerAccount，并检查ONG是否足够支付fee，fee为ONG余额的精度。
+//指定账户时使用账户中ONG余额最多的地址，都没有指定时返回空，由转出地址支付
+func (decoder *TransactionDecoder) feePayer(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, fee *big.Int) (string, error) {
+	var (
+		payerAddrs []string
+		extParam   = gjson.Parse(rawTx.ExtParam)
+	)
+
+	if address := extParam.Get("feePayer").String(); address != "" {
+		payerAddrs = []string{address}
+	} else if accountID := extParam.Get("feePayerAccount").String(); accountID != "" {
+		addresses, err := wrapper.GetAddressList(0, 2000, "AccountID", accountID)
+		if err != nil {
+			return "", err
+		}
+		if len(addresses) == 0 {
+			return "", openwallet.Errorf(openwallet.ErrAccountNotAddress, "fee payer account [%s] have not addresses", accountID)
+		}
+		for _, addr := range addresses {
+			payerAddrs = append(payerAddrs, addr.Address)
+		}
+	} else {
+		return "", nil
+	}
+
+	balances, err := decoder.wm.RPCClient.getBalances(payerAddrs...)
+	if err != nil {
+		return "", err
+	}
+
+	var payer *AddrBalance
+	for _, balance := range balances {
+		if payer == nil || balance.ONGBalance.Cmp(payer.ONGBalance) > 0 {
+			payer = balance
+		}
+	}
+	if payer == nil || payer.ONGBalance.Cmp(fee) < 0 {
+		return "", openwallet.Errorf(openwallet.ErrInsufficientFees, "No enough ONG to pay the fee on fee payer")
+	}
+	return payer.Address, nil
+}
+
+//createNativeTransferRawTransaction 创建多个接收地址，或需要多个地址共同转出的ONT、ONG转账交易。
+//没有指定payer时ONG余额最多的地址支付手续费，转出地址按余额从多到少依次使用，每个转出地址都需要签名
+func (decoder *TransactionDecoder) createNativeTransferRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, addressesBalanceList []AddrBalance, gasPrice, gasLimit uint64, payer string) error {
+	var (
+		fee         = gasFeeInONG(gasPrice, gasLimit)
+		contract    = rawTx.Coin.Contract.Address
+		isONG       = contract == ontologyTransaction.ONGContractAddress
+		recipients  = make([]string, 0, len(rawTx.To))
+		amounts     = make(map[string]*big.Int, len(rawTx.To))
+		totalAmount = decimal.Zero
+	)
+
+	//按地址排序，保证转账顺序稳定
+	for to := range rawTx.To {
+		recipients = append(recipients, to)
+	}
+	sort.Strings(recipients)
+
+	for _, to := range recipients {
+		amountStr := rawTx.To[to]
+		amount, err := convertFloatStringToBigInt(amountStr, int(rawTx.Coin.Contract.Decimals))
+		if err != nil || amount.Sign() <= 0 {
+			return fmt.Errorf("invalid amount %s to address: %s", amountStr, to)
+		}
+		d, _ := decimal.NewFromString(amountStr)
+		totalAmount = totalAmount.Add(d)
+		amounts[to] = amount
+	}
+
+	if payer == "" {
+		payerBalance := big.NewInt(0)
+		for _, a := range addressesBalanceList {
+			if a.ONGBalance.Cmp(payerBalance) > 0 {
+				payer, payerBalance = a.Address, a.ONGBalance
+			}
+		}
+		if payer == "" || payerBalance.Cmp(fee) < 0 {
+			return openwallet.Errorf(openwallet.ErrInsufficientFees, "No enough ONG to pay the fee of transaction")
+		}
+	}
+
+	states, from, ok := splitNativeTransfer(addressesBalanceList, isONG, payer, fee, recipients, amounts)
+	if !ok {
+		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "the balance: %s is not enough", totalAmount.String())
+	}
+	code, err := buildNativeTransferCode(contract, states...)
+	if err != nil {
+		return err
+	}
+
+	//估算的手续费更多时，支付手续费的地址按估算的手续费重新预留ONG
+	estimated, err := decoder.estimateGasLimit(payer, code)
+	if err != nil {
+		return err
+	}
+	if estimated > gasLimit {
+		fee = gasFeeInONG(gasPrice, estimated)
+		spend := big.NewInt(0)
+		if isONG {
+			states, from, ok = splitNativeTransfer(addressesBalanceList, isONG, payer, fee, recipients, amounts)
+			if !ok {
+				return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "the balance: %s is not enough", totalAmount.String())
+			}
+			code, err = buildNativeTransferCode(contract, states...)
+			if err != nil {
+				return err
+			}
+			for _, state := range states {
+				if state.From == payer {
+					spend.Add(spend, state.Amount)
+				}
+			}
+		}
+		if err := decoder.checkFeeBalance(payer, fee, spend); err != nil {
+			return err
+		}
+	}
+
+	tx, err := newInvokeTransaction(gasPrice, estimated, payer, code)
+	if err != nil {
+		return err
+	}
+
+	rawTx.TxFrom = from
+	rawTx.TxTo = recipients
+	rawTx.TxAmount = totalAmount.String()
+
+	return decoder.buildInvokeRawTransaction(wrapper, rawTx, tx, from...)
+}
+
+//splitNativeTransfer 转出地址按可用余额从多到少依次分配给每个接收地址，转出ONG时支付手续费的地址预留fee，
+//余额不够时返回false
+func splitNativeTransfer(addressesBalanceList []AddrBalance, isONG bool, payer string, fee *big.Int, recipients []string, amounts map[string]*big.Int) ([]nativeTransferState, []string, bool) {
+	//转出地址按可用余额从多到少排序，支付手续费的地址转出ONG时需要预留手续费
+	senders := make([]string, 0, len(addressesBalanceList))
+	available := make(map[string]*big.Int, len(addressesBalanceList))
+	sum, total := big.NewInt(0), big.NewInt(0)
+	for _, a := range addressesBalanceList {
+		balance := new(big.Int).Set(a.ONTBalance)
+		if isONG {
+			balance.Set(a.ONGBalance)
+			if a.Address == payer {
+				balance.Sub(balance, fee)
+			}
+		}
+		if balance.Sign() <= 0 {
+			continue
+		}
+		available[a.Address] = balance
+		senders = append(senders, a.Address)
+		sum.Add(sum, balance)
+	}
+	for _, to := range recipients {
+		total.Add(total, amounts[to])
+	}
+	if sum.Cmp(total) < 0 {
+		return nil, nil, false
+	}
+	sort.SliceStable(senders, func(i, j int) bool {
+		return available[senders[i]].Cmp(available[senders[j]]) > 0
+	})
+
+	var (
+		states []nativeTransferState
+		from   []string
+		i      int
+	)
+	for _, to := range recipients {
+		need := new(big.Int).Set(amounts[to])
+		for need.Sign() > 0 {
+			sender := senders[i]
+			remain := available[sender]
+			if remain.Sign() == 0 {
+				i++
+				continue
+			}
+			amount := new(big.Int).Set(need)
+			if remain.Cmp(need) < 0 {
+				amount.Set(remain)
+			}
+			if len(from) == 0 || from[len(from)-1] != sender {
+				from = append(from, sender)
+			}
+			states = append(states, nativeTransferState{From: sender, To: to, Amount: amount})
+			remain.Sub(remain, amount)
+			need.Sub(need, amount)
+		}
+	}
+	return states, from, true
+}
+
+//createMultiSigRawTransaction 创建多重签名地址转出的交易，没有指定手续费支付地址时由多重签名地址支付
+func (decoder *TransactionDecoder) createMultiSigRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, multiSig *MultiSig) error {
+	from, err := multiSig.Address()
+	if err != nil {
+		return err
+	}
+
+	balance, err := decoder.wm.RPCClient.getBalance(from)
+	if err != nil {
+		return err
+	}
+
+	gasPrice, gasLimit, err := decoder.gasSettings(rawTx)
+	if err != nil {
+		return err
+	}
+	payer, err := decoder.feePayer(wrapper, rawTx, gasFeeInONG(gasPrice, gasLimit))
+	if err != nil {
+		return err
+	}
+
+	switch rawTx.Coin.Contract.Address {
+	case ontologyTransaction.ONTContractAddress, ontologyTransaction.ONGContractAddress:
+		return decoder.createNativeTransferRawTransaction(wrapper, rawTx, []AddrBalance{*balance}, gasPrice, gasLimit, payer)
+	default:
+		if len(rawTx.To) != 1 {
+			return fmt.Errorf("OEP-4 transaction only supports one recipient")
+		}
+		var amountStr, to string
+		for k, v := range rawTx.To {
+			to = k
+			amountStr = v
+		}
+		return decoder.createOEP4RawTransaction(wrapper, rawTx, []AddrBalance{*balance}, gasPrice, gasLimit, to, amountStr, payer)
+	}
+}
+
+//addMultiSigSignatures 多重签名地址需要每个签名者签名，本钱包没有的公钥以其地址为键，由持有私钥的钱包签名
+func (decoder *TransactionDecoder) addMultiSigSignatures(wrapper openwallet.WalletDAI, signatures map[string][]*openwallet.KeySignature, multiSig *MultiSig, txHash string) error {
+	pubs, err := multiSig.sortedPublicKeys()
+	if err != nil {
+		return err
+	}
+
+	for _, pub := range pubs {
+		address := programAddress(publicKeyProgram(pub))
+		key := address
+		addr, err := wrapper.GetAddress(address)
+		if err != nil {
+			addr = &openwallet.Address{Address: address, PublicKey: hex.EncodeToString(pub)}
+		} else {
+			key = addr.AccountID
+		}
+		if addr.PublicKey == "" {
+			addr.PublicKey = hex.EncodeToString(pub)
+		}
+
+		signatures[key] = append(signatures[key], &openwallet.KeySignature{
+			EccType: decoder.wm.Config.CurveType,
+			Nonce:   "",
+			Address: addr,
+			Message: txHash,
+		})
+	}
+	return nil
+}
+
+//buildInvokeRawTransaction 填充调用合约交易的手续费、交易hex和待签名列表，payer总是需要签名，
+//签名地址是 rawTx.ExtParam 中的多重签名地址时，由每个签名者签名。tx的gasLimit由调用方估算
+func (decoder *TransactionDecoder) buildInvokeRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, tx *invokeTransaction, signers ...string) error {
+	payer, err := scriptHashToAddress(hex.EncodeToString(tx.Payer))
+	if err != nil {
+		return err
+	}
+
+	signerList := make([]string, 0, len(signers)+1)
+	exist := make(map[string]bool)
+	for _, address := range append([]string{payer}, signers...) {
+		if !exist[address] {
+			exist[address] = true
+			signerList = append(signerList, address)
+		}
+	}
+
+	fee := big.NewInt(int64(tx.GasLimit * tx.GasPrice))
+	feeInONG, _ := convertBigIntToFloatDecimal(fee.String())
+	rawTx.Fees = feeInONG.String()
+	rawTx.RawHex = tx.emptyTransHex()
+
+	signatures := rawTx.Signatures
+	if signatures == nil {
+		signatures = make(map[string][]*openwallet.KeySignature)
+	}
+
+	multiSig, err := multiSigFromExtParam(rawTx.ExtParam)
+	if err != nil {
+		return err
+	}
+	multiSigAddress := ""
+	if multiSig != nil {
+		multiSigAddress, _ = multiSig.Address()
+	}
+
+	txHash := hex.EncodeToString(tx.hash())
+	for _, address := range signerList {
+		if address == multiSigAddress {
+			if err := decoder.addMultiSigSignatures(wrapper, signatures, multiSig, txHash); err != nil {
+				return err
+			}
+			rawTx.Required = multiSig.Required
+			continue
+		}
+
+		addr, err := wrapper.GetAddress(address)
+		if err != nil {
+			return err
+		}
+		signature := &openwallet.KeySignature{
+			EccType: decoder.wm.Config.CurveType,
+			Nonce:   "",
+			Address: addr,
+			Message: txHash,
+		}
+
+		//装配签名
+		signatures[addr.AccountID] = append(signatures[addr.AccountID], signature)
+	}
+
+	rawTx.Signatures = signatures
+
+	rawTx.FeeRate = big.NewInt(int64(tx.GasPrice)).String()
+
+	rawTx.IsBuilt = true
+
+	return nil
+}
+
+//NeoVMInvoke 调用NEOVM合约方法
+type NeoVMInvoke struct {
+	Contract string       //合约地址hex
+	Method   string       //合约方法
+	Params   []NeoVMParam //方法参数
+	Payer    string       //支付手续费的地址
+	Signers  []string     //合约中需要CheckWitness的地址，payer默认签名
+}
+
+//CreateNeoVMInvokeRawTransaction 创建调用NEOVM合约方法的交易，创建后按正常流程签名、验证和广播
+func (decoder *TransactionDecoder) CreateNeoVMInvokeRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, invoke *NeoVMInvoke) error {
+	if invoke == nil || invoke.Payer == "" {
+		return fmt.Errorf("payer of the invoke transaction is empty")
+	}
+
+	code, err := BuildNeoVMInvokeCode(invoke.Contract, invoke.Method, invoke.Params...)
+	if err != nil {
+		return err
+	}
+
+	gasPrice, gasLimit, err := decoder.gasSettings(rawTx)
+	if err != nil {
+		return err
+	}
+
+	balance, err := decoder.wm.RPCClient.getBalance(invoke.Payer)
+	if err != nil {
+		return err
+	}
+	if balance.ONGBalance.Cmp(gasFeeInONG(gasPrice, gasLimit)) < 0 {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "No enough ONG to invoke contract on address: %s", invoke.Payer)
+	}
+
+	tx, err := newInvokeTransaction(gasPrice, gasLimit, invoke.Payer, code)
+	if err != nil {
+		return err
+	}
+
+	if err := decoder.estimateInvokeGas(tx); err != nil {
+		return err
+	}
+
+	rawTx.TxFrom = []string{invoke.Payer}
+	rawTx.TxTo = []string{invoke.Contract}
+	rawTx.TxAmount = "0"
+
+	return decoder.buildInvokeRawTransaction(wrapper, rawTx, tx, invoke.Signers...)
+}
+
+//gasSettings 交易的gasPrice和gasLimit，优先使用rawTx.FeeRate，否则按配置使用固定值或节点的gasPrice。
+//没有配置gasLimit时返回最低的gasLimit用于检查手续费，创建交易时再预执行估算
+func (decoder *TransactionDecoder) gasSettings(rawTx *openwallet.RawTransaction) (uint64, uint64, error) {
+	var (
+		gasPrice = ontologyTransaction.DefaultGasPrice
+		gasLimit = ontologyTransaction.DefaultGasLimit
+		err      error
+	)
+
+	if rawTx.FeeRate != "" {
+		feeprice, err := convertIntStringToBigInt(rawTx.FeeRate)
+		if err != nil {
+			return 0, 0, errors.New("fee rate passed through error")
+		}
+		gasPrice = feeprice.Uint64()
+	} else {
+		if decoder.wm.Config.GasPriceType == 0 {
+			gasPrice = decoder.wm.Config.GasPriceFixed
+		} else {
+			gasPrice, err = decoder.wm.RPCClient.getGasPrice()
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	if decoder.wm.Config.GasLimit != 0 {
+		gasLimit = decoder.wm.Config.GasLimit
+	}
+
+	return gasPrice, gasLimit, nil
+}
+
 func (decoder *TransactionDecoder) GetRawTransactionFeeRate() (feeRate string, unit string, err error) {
 	var (
 		gasPrice = decoder.wm.Config.GasPriceFixed
 		gasLimit = decoder.wm.Config.GasLimit
 	)
+	//没有配置gasLimit时交易按预执行估算，这里使用最低的gasLimit
+	if gasLimit == 0 {
+		gasLimit = ontologyTransaction.DefaultGasLimit
+	}
 	if decoder.wm.Config.GasPriceType == 0 {
 		gasPrice = decoder.wm.Config.GasPriceFixed
 	} else {
@@ -0,0 +1,769 @@
+package ontology
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/blocktree/go-owcdrivers/ontologyTransaction"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//testWalletDAI 只实现 GetAddress 和 GetAddressList 的钱包数据
+type testWalletDAI struct {
+	openwallet.WalletDAI
+	addresses []*openwallet.Address
+}
+
+func newTestWalletDAI(accountID string, addresses ...string) *testWalletDAI {
+	dai := &testWalletDAI{}
+	for _, address := range addresses {
+		dai.addresses = append(dai.addresses, &openwallet.Address{AccountID: accountID, Address: address})
+	}
+	return dai
+}
+
+func (dai *testWalletDAI) GetAddress(address string) (*openwallet.Address, error) {
+	for _, addr := range dai.addresses {
+		if addr.Address == address {
+			return addr, nil
+		}
+	}
+	return nil, openwallet.Errorf(openwallet.ErrAccountNotAddress, "address %s not found", address)
+}
+
+func (dai *testWalletDAI) GetAddressList(offset, limit int, cols ...interface{}) ([]*openwallet.Address, error) {
+	if len(cols) < 2 || cols[0] != "AccountID" {
+		return dai.addresses, nil
+	}
+	list := make([]*openwallet.Address, 0)
+	for _, addr := range dai.addresses {
+		if addr.AccountID == cols[1] {
+			list = append(list, addr)
+		}
+	}
+	return list, nil
+}
+
+//newTestBalanceNode 所有地址都有足够的ONT和ONG
+func newTestBalanceNode() *httptest.Server {
+	return newTestFeePayerNode()
+}
+
+//newTestFeePayerNode 所有地址都有足够的ONT，只有payers有ONG，不指定payers时所有地址都有ONG
+func newTestFeePayerNode(payers ...string) *httptest.Server {
+	ong := make(map[string]string)
+	for _, payer := range payers {
+		ong[payer] = "2000000000000000000"
+	}
+	if len(payers) == 0 {
+		ong[""] = "2000000000000000000"
+	}
+	return newTestONGNode(ong)
+}
+
+//newTestONGNode 所有地址都有1000 ONT，ONG余额按地址取ong中的值，键为空字符串的值作为其他地址的ONG，默认为0
+func newTestONGNode(ong map[string]string) *httptest.Server {
+	return newTestNode(func(method string, params []interface{}) interface{} {
+		switch method {
+		case "getbalancev2":
+			balance, exist := ong[params[0].(string)]
+			if !exist {
+				balance = ong[""]
+			}
+			if balance == "" {
+				balance = "0"
+			}
+			return map[string]string{"ont": "1000", "ong": balance}
+		case "getunboundong":
+			return "0"
+		}
+		return nil
+	})
+}
+
+func TestBuildNeoVMInvokeCode(t *testing.T) {
+	code, err := BuildNeoVMInvokeCode(testOEP4Contract, "approve",
+		NeoVMParam{Type: NeoVMParamAddress, Value: testAddress1},
+		NeoVMParam{Type: NeoVMParamInteger, Value: "1000"},
+		NeoVMParam{Type: NeoVMParamBool, Value: "true"},
+		NeoVMParam{Type: NeoVMParamString, Value: "hi"},
+		NeoVMParam{Type: NeoVMParamArray, Array: []NeoVMParam{
+			{Type: NeoVMParamBytes, Value: "ab"},
+			{Type: NeoVMParamInteger, Value: "-1"},
+		}},
+	)
+	if err != nil {
+		t.Fatalf("BuildNeoVMInvokeCode failed unexpected error: %v", err)
+	}
+	want := "4f" + "01ab" + "52c1" +
+		"026869" +
+		"51" +
+		"02e803" +
+		"14" + "0000000000000000000000000000000000000001" +
+		"55c1" + "07617070726f7665" +
+		"67" + "44332211908f7e6d5c4b3a291807f6e5d4c3b2a1"
+	if hex.EncodeToString(code) != want {
+		t.Errorf("invoke code = %x, want %s", code, want)
+	}
+
+	if _, err := BuildNeoVMInvokeCode(testOEP4Contract, "f", NeoVMParam{Type: "map"}); err == nil {
+		t.Errorf("unsupported param type should fail")
+	}
+}
+
+func TestTransactionDecoder_CreateNeoVMInvokeRawTransaction(t *testing.T) {
+	node := newTestBalanceNode()
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.GasPriceFixed = 2500
+	wm.Config.GasLimit = 20000
+
+	wrapper := newTestWalletDAI("account", testAddress1, testAddress7)
+	rawTx := &openwallet.RawTransaction{Account: &openwallet.AssetsAccount{AccountID: "account"}}
+	err := wm.TxDecoder.(*TransactionDecoder).CreateNeoVMInvokeRawTransaction(wrapper, rawTx, &NeoVMInvoke{
+		Contract: testOEP4Contract,
+		Method:   "approve",
+		Params:   []NeoVMParam{{Type: NeoVMParamAddress, Value: testAddress7}, {Type: NeoVMParamInteger, Value: "1"}},
+		Payer:    testAddress1,
+		Signers:  []string{testAddress7, testAddress1},
+	})
+	if err != nil {
+		t.Fatalf("CreateNeoVMInvokeRawTransaction failed unexpected error: %v", err)
+	}
+
+	if !rawTx.IsBuilt || rawTx.FeeRate != "2500" || rawTx.Fees != "0.05" {
+		t.Errorf("unexpected raw transaction: %+v", rawTx)
+	}
+	sigs := rawTx.Signatures["account"]
+	if len(sigs) != 2 || sigs[0].Address.Address != testAddress1 || sigs[1].Address.Address != testAddress7 {
+		t.Fatalf("unexpected signatures: %+v", sigs)
+	}
+	if len(sigs[0].Message) != 64 || sigs[0].Message != sigs[1].Message {
+		t.Errorf("unexpected message: %s", sigs[0].Message)
+	}
+	raw, _ := hex.DecodeString(rawTx.RawHex)
+	if len(raw) < 2 || raw[1] != txTypeInvoke || raw[len(raw)-1] != 0 {
+		t.Errorf("unexpected empty transaction: %s", rawTx.RawHex)
+	}
+
+	//payer的ONG只够gasLimit×gasPrice个最小单位，不够0.05 ONG手续费
+	poor := newTestONGNode(map[string]string{testAddress1: "50000000"})
+	defer poor.Close()
+	wm.RPCClient = NewRpcClient(poor.URL)
+	wm.RPCClient.SetRetry(0, 0)
+	err = wm.TxDecoder.(*TransactionDecoder).CreateNeoVMInvokeRawTransaction(wrapper, &openwallet.RawTransaction{Account: &openwallet.AssetsAccount{AccountID: "account"}}, &NeoVMInvoke{
+		Contract: testOEP4Contract,
+		Method:   "approve",
+		Params:   []NeoVMParam{{Type: NeoVMParamAddress, Value: testAddress7}, {Type: NeoVMParamInteger, Value: "1"}},
+		Payer:    testAddress1,
+	})
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientFees {
+		t.Errorf("CreateNeoVMInvokeRawTransaction error = %v, want insufficient fees", err)
+	}
+}
+
+func TestTransactionDecoder_CreateMultiRecipientRawTransaction(t *testing.T) {
+	node := newTestBalanceNode()
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.GasPriceFixed = 2500
+	wm.Config.GasLimit = 20000
+
+	address2, _ := scriptHashToAddress("0000000000000000000000000000000000000002")
+	address3, _ := scriptHashToAddress("0000000000000000000000000000000000000003")
+	wrapper := newTestWalletDAI("account", testAddress1, address2)
+	ont := openwallet.Coin{Symbol: "ONT", IsContract: true, Contract: openwallet.SmartContract{Address: "0100000000000000000000000000000000000000", Token: "ONT"}}
+
+	//每个地址有1000 ONT，需要两个地址共同转出
+	rawTx := &openwallet.RawTransaction{
+		Coin:    ont,
+		Account: &openwallet.AssetsAccount{AccountID: "account"},
+		To:      map[string]string{testAddress7: "600", address3: "700"},
+	}
+	if err := wm.TxDecoder.(*TransactionDecoder).CreateONTRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateONTRawTransaction failed unexpected error: %v", err)
+	}
+
+	wantTo := []string{address3, testAddress7}
+	if testAddress7 < address3 {
+		wantTo = []string{testAddress7, address3}
+	}
+	if !rawTx.IsBuilt || rawTx.TxAmount != "1300" || len(rawTx.TxFrom) != 2 || strings.Join(rawTx.TxTo, ",") != strings.Join(wantTo, ",") {
+		t.Errorf("unexpected raw transaction: %+v", rawTx)
+	}
+	sigs := rawTx.Signatures["account"]
+	if len(sigs) != 2 || sigs[0].Address.Address == sigs[1].Address.Address || sigs[0].Message != sigs[1].Message {
+		t.Fatalf("unexpected signatures: %+v", sigs)
+	}
+	if !strings.Contains(rawTx.RawHex, hex.EncodeToString([]byte(nativeTransferMethod))) {
+		t.Errorf("unexpected raw hex: %s", rawTx.RawHex)
+	}
+
+	//单个接收地址，没有地址余额足够时由多个地址共同转出
+	rawTx = &openwallet.RawTransaction{
+		Coin:    ont,
+		Account: &openwallet.AssetsAccount{AccountID: "account"},
+		To:      map[string]string{address3: "1500"},
+	}
+	if err := wm.TxDecoder.(*TransactionDecoder).CreateONTRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateONTRawTransaction failed unexpected error: %v", err)
+	}
+	if rawTx.TxAmount != "1500" || len(rawTx.TxFrom) != 2 || len(rawTx.TxTo) != 1 || len(rawTx.Signatures["account"]) != 2 {
+		t.Errorf("unexpected raw transaction: %+v", rawTx)
+	}
+	//两笔转账按链上格式倒序压栈后打包为一个数组，余额相同的地址先后顺序不固定
+	from0, _ := addressToScriptHash(rawTx.TxFrom[0])
+	from1, _ := addressToScriptHash(rawTx.TxFrom[1])
+	wantCode := "00c66b" + "14" + hex.EncodeToString(from1) + "6a7cc8" + "14" + "0000000000000000000000000000000000000003" + "6a7cc8" + "02f401" + "6a7cc8" + "6c" +
+		"00c66b" + "14" + hex.EncodeToString(from0) + "6a7cc8" + "14" + "0000000000000000000000000000000000000003" + "6a7cc8" + "02e803" + "6a7cc8" + "6c" +
+		"52c1" + "0a" + hex.EncodeToString([]byte("transferV2")) + "14" + "0000000000000000000000000000000000000001" +
+		"00" + "68" + "16" + hex.EncodeToString([]byte("Ontology.Native.Invoke"))
+	if !strings.Contains(rawTx.RawHex, hex.EncodeToString([]byte{byte(len(wantCode) / 2)})+wantCode) {
+		t.Errorf("raw hex = %s, want code %s", rawTx.RawHex, wantCode)
+	}
+
+	rawTx = &openwallet.RawTransaction{
+		Coin:    ont,
+		Account: &openwallet.AssetsAccount{AccountID: "account"},
+		To:      map[string]string{testAddress7: "1500", address3: "1000"},
+	}
+	err := wm.TxDecoder.(*TransactionDecoder).CreateONTRawTransaction(wrapper, rawTx)
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientBalanceOfAccount {
+		t.Errorf("CreateONTRawTransaction error = %v, want insufficient balance", err)
+	}
+}
+
+func TestTransactionDecoder_CreateMultiRecipientONGRawTransaction(t *testing.T) {
+	address3, _ := scriptHashToAddress("0000000000000000000000000000000000000003")
+	node := newTestONGNode(map[string]string{testAddress1: "1060000000000000000"})
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.GasPriceFixed = 2500
+	wm.Config.GasLimit = 20000
+	decoder := wm.TxDecoder.(*TransactionDecoder)
+
+	wrapper := newTestWalletDAI("account", testAddress1)
+	ong := openwallet.Coin{Symbol: "ONT", IsContract: true, Contract: openwallet.SmartContract{Address: ontologyTransaction.ONGContractAddress, Token: "ONG", Decimals: 18}}
+
+	//转出地址同时支付0.05 ONG手续费，1.06 ONG 可以转出1 ONG
+	rawTx := &openwallet.RawTransaction{
+		Coin:    ong,
+		Account: &openwallet.AssetsAccount{AccountID: "account"},
+		To:      map[string]string{testAddress7: "0.5", address3: "0.5"},
+	}
+	if err := decoder.CreateONTRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateONTRawTransaction failed unexpected error: %v", err)
+	}
+	recipients := []string{testAddress7, address3}
+	if address3 < testAddress7 {
+		recipients = []string{address3, testAddress7}
+	}
+	code, _ := buildNativeTransferCode(ontologyTransaction.ONGContractAddress,
+		nativeTransferState{From: testAddress1, To: recipients[0], Amount: big.NewInt(500000000000000000)},
+		nativeTransferState{From: testAddress1, To: recipients[1], Amount: big.NewInt(500000000000000000)})
+	if rawTx.TxAmount != "1" || rawTx.Fees != "0.05" || !strings.Contains(rawTx.RawHex, hex.EncodeToString(code)) {
+		t.Errorf("unexpected raw transaction: amount %s fees %s raw hex %s", rawTx.TxAmount, rawTx.Fees, rawTx.RawHex)
+	}
+
+	//转出1.02 ONG后不够支付手续费
+	rawTx.To = map[string]string{testAddress7: "0.51", address3: "0.51"}
+	err := decoder.CreateONTRawTransaction(wrapper, rawTx)
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientBalanceOfAccount {
+		t.Errorf("CreateONTRawTransaction error = %v, want insufficient balance", err)
+	}
+}
+
+func TestTransactionDecoder_CreateMultiSenderONGRawTransaction(t *testing.T) {
+	address2, _ := scriptHashToAddress("0000000000000000000000000000000000000002")
+	node := newTestONGNode(map[string]string{testAddress1: "1000000000000000000", address2: "500000000000000000"})
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.GasPriceFixed = 2500
+	wm.Config.GasLimit = 20000
+	decoder := wm.TxDecoder.(*TransactionDecoder)
+
+	wrapper := newTestWalletDAI("account", testAddress1, address2)
+	ong := openwallet.Coin{Symbol: "ONT", IsContract: true, Contract: openwallet.SmartContract{Address: ontologyTransaction.ONGContractAddress, Token: "ONG", Decimals: 18}}
+
+	//testAddress1 支付手续费，只能转出扣除0.05 ONG手续费后的0.95 ONG
+	rawTx := &openwallet.RawTransaction{
+		Coin:    ong,
+		Account: &openwallet.AssetsAccount{AccountID: "account"},
+		To:      map[string]string{testAddress7: "1.4"},
+	}
+	if err := decoder.CreateONTRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateONTRawTransaction failed unexpected error: %v", err)
+	}
+	code, _ := buildNativeTransferCode(ontologyTransaction.ONGContractAddress,
+		nativeTransferState{From: testAddress1, To: testAddress7, Amount: big.NewInt(950000000000000000)},
+		nativeTransferState{From: address2, To: testAddress7, Amount: big.NewInt(450000000000000000)})
+	if !strings.Contains(rawTx.RawHex, hex.EncodeToString(code)) {
+		t.Errorf("raw hex = %s, want code %x", rawTx.RawHex, code)
+	}
+
+	rawTx.To = map[string]string{testAddress7: "1.46"}
+	err := decoder.CreateONTRawTransaction(wrapper, rawTx)
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientBalanceOfAccount {
+		t.Errorf("CreateONTRawTransaction error = %v, want insufficient balance", err)
+	}
+}
+
+func TestTransactionDecoder_CreateFeePayerRawTransaction(t *testing.T) {
+	address2, _ := scriptHashToAddress("0000000000000000000000000000000000000002")
+	address3, _ := scriptHashToAddress("0000000000000000000000000000000000000003")
+	node := newTestFeePayerNode(address3)
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.GasPriceFixed = 2500
+	wm.Config.GasLimit = 20000
+	decoder := wm.TxDecoder.(*TransactionDecoder)
+
+	//充值地址没有ONG，由其他账户的地址支付手续费，两个地址共同转出
+	wrapper := newTestWalletDAI("account", testAddress1, address2)
+	wrapper.addresses = append(wrapper.addresses, &openwallet.Address{AccountID: "fee", Address: address3})
+	ont := openwallet.Coin{Symbol: "ONT", IsContract: true, Contract: openwallet.SmartContract{Address: "0100000000000000000000000000000000000000", Token: "ONT"}}
+
+	for _, extParam := range []string{`{"feePayer":"` + address3 + `"}`, `{"feePayerAccount":"fee"}`} {
+		rawTx := &openwallet.RawTransaction{
+			Coin:     ont,
+			Account:  &openwallet.AssetsAccount{AccountID: "account"},
+			To:       map[string]string{testAddress7: "1500"},
+			ExtParam: extParam,
+		}
+		if err := decoder.CreateONTRawTransaction(wrapper, rawTx); err != nil {
+			t.Fatalf("CreateONTRawTransaction failed unexpected error: %v", err)
+		}
+		sigs, feeSigs := rawTx.Signatures["account"], rawTx.Signatures["fee"]
+		if len(sigs) != 2 || len(feeSigs) != 1 || feeSigs[0].Address.Address != address3 || sigs[0].Message != feeSigs[0].Message {
+			t.Fatalf("unexpected signatures: %+v", rawTx.Signatures)
+		}
+		payer, _ := addressToScriptHash(address3)
+		if !strings.Contains(rawTx.RawHex, hex.EncodeToString(payer)) {
+			t.Errorf("payer %x not in raw hex: %s", payer, rawTx.RawHex)
+		}
+	}
+
+	//没有指定支付地址时，转出地址需要有ONG
+	rawTx := &openwallet.RawTransaction{
+		Coin:    ont,
+		Account: &openwallet.AssetsAccount{AccountID: "account"},
+		To:      map[string]string{testAddress7: "1500"},
+	}
+	for _, extParam := range []string{"", `{"feePayer":"` + address2 + `"}`} {
+		rawTx.ExtParam = extParam
+		err := decoder.CreateONTRawTransaction(wrapper, rawTx)
+		if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientFees {
+			t.Errorf("CreateONTRawTransaction error = %v, want insufficient fees", err)
+		}
+	}
+
+	//支付地址的ONG只够gasLimit×gasPrice个最小单位，不够按18位小数计算的0.05 ONG手续费
+	node = newTestONGNode(map[string]string{address3: "50000000"})
+	defer node.Close()
+	wm.RPCClient = NewRpcClient(node.URL)
+	wm.RPCClient.SetRetry(0, 0)
+	rawTx.ExtParam = `{"feePayer":"` + address3 + `"}`
+	err := decoder.CreateONTRawTransaction(wrapper, rawTx)
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientFees {
+		t.Errorf("CreateONTRawTransaction error = %v, want insufficient fees", err)
+	}
+}
+
+func TestTransactionDecoder_CreateFeePayerWithdrawRawTransaction(t *testing.T) {
+	address3, _ := scriptHashToAddress("0000000000000000000000000000000000000003")
+	//testAddress1 只有未解绑的ONG，由其他账户的地址支付手续费
+	node := newTestNode(func(method string, params []interface{}) interface{} {
+		switch method {
+		case "getbalancev2":
+			if params[0] == address3 {
+				return map[string]string{"ont": "0", "ong": "2000000000000000000"}
+			}
+			return map[string]string{"ont": "0", "ong": "0"}
+		case "getunboundong":
+			if params[0] == testAddress1 {
+				return "300000000000000000"
+			}
+			return "0"
+		}
+		return nil
+	})
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.GasPriceFixed = 2500
+	wm.Config.GasLimit = 20000
+	decoder := wm.TxDecoder.(*TransactionDecoder)
+
+	wrapper := newTestWalletDAI("account", testAddress1)
+	wrapper.addresses = append(wrapper.addresses, &openwallet.Address{AccountID: "fee", Address: address3})
+	ong := openwallet.Coin{Symbol: "ONT", IsContract: true, Contract: openwallet.SmartContract{Address: "0200000000000000000000000000000000000000", Token: "ONG", Decimals: 9}}
+
+	rawTx := &openwallet.RawTransaction{
+		Coin:     ong,
+		Account:  &openwallet.AssetsAccount{AccountID: "account"},
+		To:       map[string]string{testAddress1: "0"},
+		ExtParam: `{"feePayer":"` + address3 + `"}`,
+	}
+	if err := decoder.CreateONTRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateONTRawTransaction failed unexpected error: %v", err)
+	}
+	sigs, feeSigs := rawTx.Signatures["account"], rawTx.Signatures["fee"]
+	if len(sigs) != 1 || sigs[0].Address.Address != testAddress1 || len(feeSigs) != 1 || feeSigs[0].Address.Address != address3 {
+		t.Errorf("unexpected signatures: %+v", rawTx.Signatures)
+	}
+	if rawTx.TxFrom[0] != testAddress1 || rawTx.TxTo[0] != testAddress1 || rawTx.Fees != "0.05" {
+		t.Errorf("unexpected raw transaction: %+v", rawTx)
+	}
+
+	//提取地址不属于账户
+	rawTx.To = map[string]string{testAddress7: "0"}
+	if err := decoder.CreateONTRawTransaction(wrapper, rawTx); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("CreateONTRawTransaction error = %v, want address not found", err)
+	}
+}
+
+func TestTransactionDecoder_CreateOEP4SummaryRawTransaction(t *testing.T) {
+	//预执行查询的代币余额为1，估算gas为20000
+	node := newTestNode(func(method string, params []interface{}) interface{} {
+		switch method {
+		case "getbalancev2":
+			return map[string]string{"ont": "0", "ong": "2000000000000000000"}
+		case "getunboundong":
+			return "0"
+		case "sendrawtransaction":
+			return testPreExecResult(20000, "00e1f505")
+		}
+		return nil
+	})
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.GasPriceFixed = 2500
+
+	wrapper := newTestWalletDAI("account", testAddress1)
+	rawTxs, err := wm.TxDecoder.(*TransactionDecoder).CreateSummaryRawTransaction(wrapper, &openwallet.SummaryRawTransaction{
+		Coin: openwallet.Coin{
+			Symbol:     "ONT",
+			IsContract: true,
+			ContractID: testOEP4Contract,
+			Contract:   openwallet.SmartContract{Address: testOEP4Contract, Token: "TST", Decimals: 8},
+		},
+		Account:         &openwallet.AssetsAccount{AccountID: "account"},
+		SummaryAddress:  testAddress7,
+		MinTransfer:     "0.5",
+		RetainedBalance: "0.1",
+		AddressLimit:    10,
+	})
+	if err != nil {
+		t.Fatalf("CreateSummaryRawTransaction failed unexpected error: %v", err)
+	}
+	if len(rawTxs) != 1 {
+		t.Fatalf("got %d summary transactions, want 1", len(rawTxs))
+	}
+	rawTx := rawTxs[0]
+	if rawTx.TxFrom[0] != testAddress1 || rawTx.TxTo[0] != testAddress7 || rawTx.TxAmount != "0.9" || !rawTx.IsBuilt {
+		t.Errorf("unexpected summary transaction: from %v to %v amount %s", rawTx.TxFrom, rawTx.TxTo, rawTx.TxAmount)
+	}
+	code, _ := buildOEP4TransferCode(testOEP4Contract, testAddress1, testAddress7, big.NewInt(90000000))
+	if !strings.Contains(rawTx.RawHex, hex.EncodeToString(code)) {
+		t.Errorf("raw hex %s does not invoke transfer of 0.9 token", rawTx.RawHex)
+	}
+}
+
+func TestTransactionDecoder_CreateOEP4RawTransaction(t *testing.T) {
+	//代币余额都为1，testAddress1 的ONG只够gasLimit×gasPrice个最小单位
+	address2, _ := scriptHashToAddress("0000000000000000000000000000000000000002")
+	node := newTestNode(func(method string, params []interface{}) interface{} {
+		switch method {
+		case "getbalancev2":
+			if params[0] == address2 {
+				return map[string]string{"ont": "0", "ong": "50000000000000000"}
+			}
+			return map[string]string{"ont": "0", "ong": "50000000"}
+		case "getunboundong":
+			return "0"
+		case "sendrawtransaction":
+			return testPreExecResult(20000, "00e1f505")
+		}
+		return nil
+	})
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.GasPriceFixed = 2500
+	wm.Config.GasLimit = 20000
+	decoder := wm.TxDecoder.(*TransactionDecoder)
+	token := openwallet.Coin{Symbol: "ONT", IsContract: true, Contract: openwallet.SmartContract{Address: testOEP4Contract, Token: "TST", Decimals: 8}}
+
+	rawTx := &openwallet.RawTransaction{
+		Coin:    token,
+		Account: &openwallet.AssetsAccount{AccountID: "account"},
+		To:      map[string]string{testAddress7: "0.5"},
+	}
+	err := decoder.CreateONTRawTransaction(newTestWalletDAI("account", testAddress1), rawTx)
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientFees {
+		t.Errorf("CreateONTRawTransaction error = %v, want insufficient fees", err)
+	}
+
+	//有0.05 ONG的地址可以支付手续费
+	if err := decoder.CreateONTRawTransaction(newTestWalletDAI("account", testAddress1, address2), rawTx); err != nil {
+		t.Fatalf("CreateONTRawTransaction failed unexpected error: %v", err)
+	}
+	if rawTx.TxFrom[0] != address2 || rawTx.Fees != "0.05" {
+		t.Errorf("unexpected raw transaction: from %v fees %s", rawTx.TxFrom, rawTx.Fees)
+	}
+}
+
+//newTestPreExecGasNode 预执行交易消耗gas，地址的ONG为ong
+func newTestPreExecGasNode(gas uint64, ong string, preExecs *int32) *httptest.Server {
+	return newTestNode(func(method string, params []interface{}) interface{} {
+		switch method {
+		case "getbalancev2":
+			return map[string]string{"ont": "1000", "ong": ong}
+		case "getunboundong":
+			return "0"
+		case "sendrawtransaction":
+			atomic.AddInt32(preExecs, 1)
+			return testPreExecResult(gas, "01")
+		}
+		return nil
+	})
+}
+
+func TestTransactionDecoder_EstimateGasLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		gas       uint64
+		ong       string
+		gasLimit  uint64
+		wantLimit uint64
+		wantFees  string
+		preExecs  int32
+	}{
+		{name: "minimum gas", gas: 15000, ong: "2000000000000000000", wantLimit: 20000, wantFees: "0.05", preExecs: 1},
+		{name: "gas with margin", gas: 100000, ong: "2000000000000000000", wantLimit: 120000, wantFees: "0.3", preExecs: 1},
+		{name: "configured gas limit", gas: 100000, ong: "2000000000000000000", gasLimit: 30000, wantLimit: 30000, wantFees: "0.075"},
+		{name: "not enough ONG for estimated fee", gas: 100000, ong: "200000000000000000"},
+	}
+
+	for _, test := range tests {
+		var preExecs int32
+		node := newTestPreExecGasNode(test.gas, test.ong, &preExecs)
+
+		wm := newTestWalletManager(node.URL)
+		wm.Config.GasPriceFixed = 2500
+		wm.Config.GasLimit = test.gasLimit
+
+		wrapper := newTestWalletDAI("account", testAddress1)
+		rawTx := &openwallet.RawTransaction{Account: &openwallet.AssetsAccount{AccountID: "account"}}
+		err := wm.TxDecoder.(*TransactionDecoder).CreateNeoVMInvokeRawTransaction(wrapper, rawTx, &NeoVMInvoke{
+			Contract: testOEP4Contract,
+			Method:   "approve",
+			Params:   []NeoVMParam{{Type: NeoVMParamAddress, Value: testAddress7}, {Type: NeoVMParamInteger, Value: "1"}},
+			Payer:    testAddress1,
+		})
+		node.Close()
+
+		if test.wantLimit == 0 {
+			if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientFees {
+				t.Errorf("%s: CreateNeoVMInvokeRawTransaction error = %v, want insufficient fees", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: CreateNeoVMInvokeRawTransaction failed unexpected error: %v", test.name, err)
+		}
+		gasLimit := make([]byte, 8)
+		binary.LittleEndian.PutUint64(gasLimit, test.wantLimit)
+		if rawTx.Fees != test.wantFees || rawTx.RawHex[28:44] != hex.EncodeToString(gasLimit) {
+			t.Errorf("%s: fees = %s, raw hex = %s, want gas limit %d", test.name, rawTx.Fees, rawTx.RawHex, test.wantLimit)
+		}
+		if preExecs != test.preExecs {
+			t.Errorf("%s: pre-executed %d times, want %d", test.name, preExecs, test.preExecs)
+		}
+	}
+}
+
+func TestTransactionDecoder_EstimateMultiSenderONGGas(t *testing.T) {
+	//预执行消耗100000 gas，估算的手续费为0.3 ONG
+	address2, _ := scriptHashToAddress("0000000000000000000000000000000000000002")
+	node := newTestNode(func(method string, params []interface{}) interface{} {
+		switch method {
+		case "getbalancev2":
+			if params[0] == testAddress1 {
+				return map[string]string{"ont": "0", "ong": "1000000000000000000"}
+			}
+			return map[string]string{"ont": "0", "ong": "500000000000000000"}
+		case "getunboundong":
+			return "0"
+		case "sendrawtransaction":
+			return testPreExecResult(100000, "01")
+		}
+		return nil
+	})
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.GasPriceFixed = 2500
+	decoder := wm.TxDecoder.(*TransactionDecoder)
+
+	wrapper := newTestWalletDAI("account", testAddress1, address2)
+	ong := openwallet.Coin{Symbol: "ONT", IsContract: true, Contract: openwallet.SmartContract{Address: ontologyTransaction.ONGContractAddress, Token: "ONG", Decimals: 18}}
+
+	//testAddress1 支付手续费，按估算的手续费预留0.3 ONG后转出0.7 ONG
+	rawTx := &openwallet.RawTransaction{
+		Coin:    ong,
+		Account: &openwallet.AssetsAccount{AccountID: "account"},
+		To:      map[string]string{testAddress7: "1.1"},
+	}
+	if err := decoder.CreateONTRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateONTRawTransaction failed unexpected error: %v", err)
+	}
+	code, _ := buildNativeTransferCode(ontologyTransaction.ONGContractAddress,
+		nativeTransferState{From: testAddress1, To: testAddress7, Amount: big.NewInt(700000000000000000)},
+		nativeTransferState{From: address2, To: testAddress7, Amount: big.NewInt(400000000000000000)})
+	if rawTx.Fees != "0.3" || !strings.Contains(rawTx.RawHex, hex.EncodeToString(code)) {
+		t.Errorf("fees = %s, raw hex = %s, want code %x", rawTx.Fees, rawTx.RawHex, code)
+	}
+
+	//按创建时的手续费足够，预留估算的手续费后不够
+	rawTx.To = map[string]string{testAddress7: "1.25"}
+	err := decoder.CreateONTRawTransaction(wrapper, rawTx)
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientBalanceOfAccount {
+		t.Errorf("CreateONTRawTransaction error = %v, want insufficient balance", err)
+	}
+}
+
+func TestTransactionDecoder_EstimateONGSummaryGas(t *testing.T) {
+	//预执行消耗100000 gas，估算的手续费0.3 ONG比创建时的0.05 ONG多0.25 ONG
+	node := newTestNode(func(method string, params []interface{}) interface{} {
+		switch method {
+		case "getbalancev2":
+			return map[string]string{"ont": "0", "ong": "1000000000000000000"}
+		case "getunboundong":
+			return "0"
+		case "sendrawtransaction":
+			return testPreExecResult(100000, "01")
+		}
+		return nil
+	})
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+	wm.Config.GasPriceFixed = 2500
+
+	rawTxs, err := wm.TxDecoder.(*TransactionDecoder).CreateSummaryRawTransaction(newTestWalletDAI("account", testAddress1), &openwallet.SummaryRawTransaction{
+		Coin:            openwallet.Coin{Symbol: "ONT", IsContract: true, Contract: openwallet.SmartContract{Address: ontologyTransaction.ONGContractAddress, Token: "ONG", Decimals: 18}},
+		Account:         &openwallet.AssetsAccount{AccountID: "account"},
+		SummaryAddress:  testAddress7,
+		MinTransfer:     "0",
+		RetainedBalance: "0",
+		AddressLimit:    10,
+	})
+	if err != nil {
+		t.Fatalf("CreateSummaryRawTransaction failed unexpected error: %v", err)
+	}
+	if len(rawTxs) != 1 {
+		t.Fatalf("got %d summary transactions, want 1", len(rawTxs))
+	}
+	rawTx := rawTxs[0]
+	if rawTx.Fees != "0.3" || rawTx.TxAmount != "0.7" || rawTx.To[testAddress7] != "0.7" {
+		t.Errorf("fees = %s, amount = %s, to = %v, want 0.7 ONG after 0.3 ONG fee", rawTx.Fees, rawTx.TxAmount, rawTx.To)
+	}
+}
+
+func TestTransactionDecoder_EstimateWithdrawGas(t *testing.T) {
+	var preExecs int32
+	node := newTestPreExecGasNode(100000, "0", &preExecs)
+	defer node.Close()
+
+	wm := newTestWalletManager(node.URL)
+
+	//提取ONG时增加的手续费从提取数量中扣除
+	txState := &ontologyTransaction.TxStateV2{
+		AssetType: ontologyTransaction.AssetONGWithdraw,
+		From:      testAddress1,
+		To:        testAddress1,
+		Amount:    big.NewInt(1000000000),
+	}
+	gasLimit, err := wm.TxDecoder.(*TransactionDecoder).estimateTxStateGas(txState, 2500, 20000, false)
+	if err != nil {
+		t.Fatalf("estimateTxStateGas failed unexpected error: %v", err)
+	}
+	if gasLimit != 120000 || txState.Amount.Int64() != 1000000000-100000*2500 {
+		t.Errorf("gas limit = %d, amount = %s", gasLimit, txState.Amount)
+	}
+
+	txState.AssetType = ontologyTransaction.AssetONT
+	_, err = wm.TxDecoder.(*TransactionDecoder).estimateTxStateGas(txState, 2500, 20000, false)
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientFees {
+		t.Errorf("estimateTxStateGas error = %v, want insufficient fees", err)
+	}
+}
+
+func TestTransactionDecoder_EstimateTxStateCode(t *testing.T) {
+	var (
+		address1    = "0000000000000000000000000000000000000001"
+		address7    = "0000000000000000000000000000000000000007"
+		ontContract = "0000000000000000000000000000000000000001"
+		ongContract = "0000000000000000000000000000000000000002"
+		invoke      = "00" + "68" + "16" + hex.EncodeToString([]byte("Ontology.Native.Invoke"))
+	)
+	tests := []struct {
+		txState *ontologyTransaction.TxStateV2
+		want    string
+	}{
+		{
+			txState: &ontologyTransaction.TxStateV2{AssetType: ontologyTransaction.AssetONT, From: testAddress1, To: testAddress7, Amount: big.NewInt(100)},
+			want: "00c66b" + "14" + address1 + "6a7cc8" + "14" + address7 + "6a7cc8" + "0164" + "6a7cc8" + "6c" + "51c1" +
+				"0a" + hex.EncodeToString([]byte("transferV2")) + "14" + ontContract + invoke,
+		},
+		{
+			txState: &ontologyTransaction.TxStateV2{AssetType: ontologyTransaction.AssetONGWithdraw, From: testAddress1, To: testAddress7, Amount: big.NewInt(1000000000)},
+			want: "00c66b" + "14" + address1 + "6a7cc8" + "14" + ontContract + "6a7cc8" + "14" + address7 + "6a7cc8" + "0400ca9a3b" + "6a7cc8" + "6c" +
+				"0e" + hex.EncodeToString([]byte("transferFromV2")) + "14" + ongContract + invoke,
+		},
+	}
+
+	for _, test := range tests {
+		code, err := txStateCode(test.txState)
+		if err != nil {
+			t.Fatalf("txStateCode failed unexpected error: %v", err)
+		}
+		if hex.EncodeToString(code) != test.want {
+			t.Errorf("asset %d code = %x, want %s", test.txState.AssetType, code, test.want)
+		}
+
+		//节点只接受与链上交易相同的调用脚本
+		node := newTestNode(func(method string, params []interface{}) interface{} {
+			switch method {
+			case "getbalancev2":
+				return map[string]string{"ont": "1000", "ong": "2000000000000000000"}
+			case "getunboundong":
+				return "0"
+			case "sendrawtransaction":
+				if !strings.Contains(params[0].(string), test.want) {
+					return testRpcError(43001)
+				}
+				return testPreExecResult(30000, "01")
+			}
+			return nil
+		})
+		wm := newTestWalletManager(node.URL)
+		gasLimit, err := wm.TxDecoder.(*TransactionDecoder).estimateTxStateGas(test.txState, 2500, 20000, false)
+		node.Close()
+		if err != nil || gasLimit != 36000 {
+			t.Errorf("asset %d estimateTxStateGas = %d, %v", test.txState.AssetType, gasLimit, err)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package ontology
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	DefaultWSReconnectInterval = 5 * time.Second  //WebSocket断线重连间隔
+	wsHeartbeatInterval        = 60 * time.Second //心跳间隔，节点默认300秒没有消息会关闭连接
+	wsReadTimeout              = 3 * wsHeartbeatInterval
+	wsWriteTimeout             = 10 * time.Second
+)
+
+//Ontology WebSocket 消息类型
+const (
+	wsActionSubscribe    = "subscribe"
+	wsActionHeartbeat    = "heartbeat"
+	wsActionBlockTxHashs = "sendblocktxhashs"
+	wsActionNotify       = "Notify"
+)
+
+//wsRequest WebSocket请求
+type wsRequest struct {
+	Action                string
+	Version               string
+	SubscribeEvent        bool `json:",omitempty"`
+	SubscribeBlockTxHashs bool `json:",omitempty"`
+}
+
+//wsBlockTxHashs 推送的新区块交易列表
+type wsBlockTxHashs struct {
+	Hash         string
+	Height       uint64
+	Transactions []string
+}
+
+//WebSocketClient Ontology节点 WebSocket 客户端，订阅新区块和合约事件
+type WebSocketClient struct {
+	url               string
+	reconnectInterval time.Duration
+	onBlock           func(block *wsBlockTxHashs)
+	onEvent           func(event *smartCodeEvent)
+	conn              *websocket.Conn
+	stop              chan struct{}
+	mu                sync.RWMutex
+	writeMu           sync.Mutex
+}
+
+//NewWebSocketClient 创建WebSocket客户端，onBlock和onEvent在收到推送时被调用
+func NewWebSocketClient(url string, onBlock func(block *wsBlockTxHashs), onEvent func(event *smartCodeEvent)) *WebSocketClient {
+	return &WebSocketClient{
+		url:               url,
+		reconnectInterval: DefaultWSReconnectInterval,
+		onBlock:           onBlock,
+		onEvent:           onEvent,
+	}
+}
+
+//SetReconnectInterval 设置断线重连间隔
+func (ws *WebSocketClient) SetReconnectInterval(interval time.Duration) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if interval > 0 {
+		ws.reconnectInterval = interval
+	}
+}
+
+//IsConnected 是否已连接并完成订阅
+func (ws *WebSocketClient) IsConnected() bool {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return ws.conn != nil
+}
+
+//Start 启动连接，断开后自动重连，直到调用Stop
+func (ws *WebSocketClient) Start() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	ws.stop = stop
+	go ws.run(stop)
+}
+
+//Stop 关闭连接并停止重连
+func (ws *WebSocketClient) Stop() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.stop != nil {
+		close(ws.stop)
+		ws.stop = nil
+	}
+	if ws.conn != nil {
+		ws.conn.Close()
+	}
+}
+
+func (ws *WebSocketClient) run(stop chan struct{}) {
+	for {
+		err := ws.serve(stop)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		ws.mu.RLock()
+		interval := ws.reconnectInterval
+		ws.mu.RUnlock()
+
+		log.Std.Warning("websocket %s disconnected, reconnect after %v; unexpected error: %v", ws.url, interval, err)
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+//serve 建立连接并订阅，阻塞读取推送直到连接断开
+func (ws *WebSocketClient) serve(stop chan struct{}) error {
+	conn, _, err := websocket.DefaultDialer.Dial(ws.url, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = ws.write(conn, &wsRequest{
+		Action:                wsActionSubscribe,
+		Version:               restApiVersion,
+		SubscribeEvent:        true,
+		SubscribeBlockTxHashs: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	ws.mu.Lock()
+	select {
+	case <-stop:
+		//连接期间已调用Stop
+		ws.mu.Unlock()
+		return nil
+	default:
+	}
+	ws.conn = conn
+	ws.mu.Unlock()
+
+	log.Std.Info("websocket %s connected", ws.url)
+
+	defer func() {
+		ws.mu.Lock()
+		ws.conn = nil
+		ws.mu.Unlock()
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go ws.heartbeat(conn, done)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if err := ws.handleMessage(msg); err != nil {
+			return err
+		}
+	}
+}
+
+//heartbeat 定时发送心跳，防止节点关闭空闲连接
+func (ws *WebSocketClient) heartbeat(conn *websocket.Conn, done chan struct{}) {
+	ticker := time.NewTicker(wsHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := ws.write(conn, &wsRequest{Action: wsActionHeartbeat, Version: restApiVersion})
+			if err != nil {
+				log.Std.Warning("websocket %s send heartbeat failed; unexpected error: %v", ws.url, err)
+				conn.Close()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (ws *WebSocketClient) write(conn *websocket.Conn, req *wsRequest) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return conn.WriteJSON(req)
+}
+
+//handleMessage 处理节点消息，返回错误时断开重连
+func (ws *WebSocketClient) handleMessage(msg []byte) error {
+	rsp := restResponse{}
+	if err := json.Unmarshal(msg, &rsp); err != nil {
+		log.Std.Warning("websocket %s receive invalid message: %s", ws.url, msg)
+		return nil
+	}
+
+	switch rsp.Action {
+	case wsActionSubscribe:
+		if rsp.Error != ErrCodeSuccess {
+			return fmt.Errorf("websocket subscribe failed, error code:%d desc:%s", rsp.Error, rsp.Desc)
+		}
+	case wsActionBlockTxHashs:
+		block := &wsBlockTxHashs{}
+		if err := json.Unmarshal(rsp.Result, block); err != nil {
+			log.Std.Warning("websocket %s receive invalid block: %s", ws.url, rsp.Result)
+			return nil
+		}
+		if ws.onBlock != nil {
+			ws.onBlock(block)
+		}
+	case wsActionNotify:
+		event, err := parseSmartCodeEvent(rsp.Result)
+		if err != nil {
+			log.Std.Warning("websocket %s can not parse event: %s; unexpected error: %v", ws.url, rsp.Result, err)
+			return nil
+		}
+		if len(event.TxHash) == 0 {
+			log.Std.Warning("websocket %s receive invalid event: %s", ws.url, rsp.Result)
+			return nil
+		}
+		if ws.onEvent != nil {
+			ws.onEvent(event)
+		}
+	}
+	return nil
+}
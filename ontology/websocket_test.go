@@ -0,0 +1,91 @@
+package ontology
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestWebSocketNode(t *testing.T, connects *int32) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req := wsRequest{}
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		if req.Action != wsActionSubscribe || !req.SubscribeEvent || !req.SubscribeBlockTxHashs {
+			t.Errorf("unexpected subscribe request: %+v", req)
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"Action":"subscribe","Desc":"SUCCESS","Error":0,"Result":{},"Version":"1.0.0"}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"Action":"Notify","Desc":"SUCCESS","Error":0,"Result":{"TxHash":"tx1","State":1,"GasConsumed":0,"Notify":[]},"Version":"1.0.0"}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"Action":"sendblocktxhashs","Desc":"SUCCESS","Error":0,"Result":{"Hash":"hash1","Height":100,"Transactions":["tx1"]},"Version":"1.0.0"}`))
+
+		//第一次连接推送后断开，验证重连
+		if atomic.AddInt32(connects, 1) == 1 {
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestWebSocketClient_Subscribe(t *testing.T) {
+	var connects int32
+	node := newTestWebSocketNode(t, &connects)
+	defer node.Close()
+
+	blocks := make(chan *wsBlockTxHashs, 2)
+	events := make(chan string, 2)
+	ws := NewWebSocketClient("ws"+strings.TrimPrefix(node.URL, "http"),
+		func(block *wsBlockTxHashs) { blocks <- block },
+		func(event *smartCodeEvent) { events <- event.TxHash })
+	ws.SetReconnectInterval(10 * time.Millisecond)
+	ws.Start()
+	defer ws.Stop()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case txid := <-events:
+			if txid != "tx1" {
+				t.Errorf("event txid = %s, want tx1", txid)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event push %d not received", i)
+		}
+		select {
+		case block := <-blocks:
+			if block.Height != 100 || block.Hash != "hash1" || len(block.Transactions) != 1 {
+				t.Errorf("unexpected block push: %+v", block)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("block push %d not received", i)
+		}
+	}
+
+	if n := atomic.LoadInt32(&connects); n < 2 {
+		t.Errorf("connects = %d, want reconnect", n)
+	}
+	if !ws.IsConnected() {
+		t.Errorf("websocket should be connected")
+	}
+
+	ws.Stop()
+	time.Sleep(50 * time.Millisecond)
+	if ws.IsConnected() {
+		t.Errorf("websocket should be disconnected after Stop")
+	}
+}